@@ -1,21 +1,48 @@
 package auth
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
+	"gos/app/models"
 	"gos/app/repo"
+	"strings"
+	"time"
 )
 
 // TokenHeader token for auth
 const TokenHeader = "x-access-token"
 
+// AuthorizationHeader accepts the access tokens and the personal access tokens as bearer tokens
+const AuthorizationHeader = "Authorization"
+
 // AccessTokenExpirationMinutes is the expiry time for the token
 const AccessTokenExpirationMinutes = 300
 
 // IAuth is an interface for handling auth
 type IAuth interface {
 	AuthenticateUser(ctx *gin.Context, accessToken string) (string, error)
+	GenerateAccessToken(user models.User, scopes []string, sessionId int64) (string, int64, error)
+	GenerateVerificationToken(user models.User, ttl time.Duration) (string, error)
+	ParseVerificationToken(verificationToken string) (*VerificationClaims, error)
+	GenerateEmailChangeToken(user models.User, newEmail string, ttl time.Duration) (string, error)
+	ParseEmailChangeToken(emailChangeToken string) (*EmailChangeClaims, error)
+	GenerateExportDownloadToken(job models.ExportJob, expiresAt int64) (string, error)
+	ParseExportDownloadToken(downloadToken string) (*ExportDownloadClaims, error)
+	GenerateMFAChallengeToken(user models.User, scopes []string, ttl time.Duration) (string, int64, error)
+	ParseMFAChallengeToken(challengeToken string) (*MFAChallengeClaims, error)
+	GenerateOAuthAccessToken(user *models.User, clientId string, scopes []string, ttl time.Duration) (string, int64, error)
+	ParseAccessToken(accessToken string) (*Claims, error)
+	AuthenticateClient(ctx context.Context, clientId string, clientSecret string) (*models.OAuthClient, error)
+	IntrospectToken(ctx context.Context, token string) *models.IntrospectionResponse
+	RevokeToken(ctx context.Context, client *models.OAuthClient, token string) error
+	GenerateOIDCStateToken(scopes []string, cookie bool, ttl time.Duration) (string, *OIDCStateClaims, error)
+	ParseOIDCStateToken(stateToken string) (*OIDCStateClaims, error)
+	GenerateCSRFToken(sessionToken string) (string, error)
+	ValidateCSRFToken(sessionToken string, csrfToken string) bool
 	GetJWTKey() []byte
 }
 
@@ -24,9 +51,29 @@ type Claims struct {
 	UserId int64  `json:"userId"`
 	Email  string `json:"email"`
 	Name   string `json:"name"`
+	// TokenVersion is compared with the user's version, bumping it revokes all issued tokens
+	TokenVersion int `json:"ver"`
+	// Scope is the space separated list of the scopes granted to the token
+	Scope string `json:"scope,omitempty"`
+	// ClientId is set for the tokens issued to oauth clients
+	ClientId string `json:"client_id,omitempty"`
+	// Role is the role of the user when the token was issued, the tokens of oauth clients have none
+	Role string `json:"role,omitempty"`
+	// SessionId binds the token to the session of the login, revoking the session revokes the token
+	SessionId int64 `json:"sid,omitempty"`
 	jwt.StandardClaims
 }
 
+// Scopes returns the scopes granted to the token
+func (c *Claims) Scopes() []string {
+	return SplitScopes(c.Scope)
+}
+
+// HasScope checks if the scope is granted to the token
+func (c *Claims) HasScope(scope string) bool {
+	return containsScope(c.Scopes(), scope)
+}
+
 // Auth keeps the auth and a secret key
 type Auth struct {
 	jwtKey []byte
@@ -48,23 +95,134 @@ func (auth *Auth) GetJWTKey() []byte {
 
 // AuthenticateUser will auth user and returns a access token with expiry
 func (auth *Auth) AuthenticateUser(ctx *gin.Context, accessToken string) (string, error) {
-	claims := &Claims{}
-	tkn, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return auth.jwtKey, nil
-	})
+	if strings.HasPrefix(accessToken, PersonalAccessTokenPrefix) {
+		return auth.authenticatePersonalAccessToken(ctx, accessToken)
+	}
 
+	claim, err := auth.ParseAccessToken(accessToken)
+	if err != nil {
+		return "", err
+	}
+
+	if claim.UserId == 0 {
+		return "", errors.New("client access tokens can't access user resources")
+	}
+
+	if len(claim.Id) > 0 {
+		revoked, err := auth.repo.IsTokenRevoked(ctx.Request.Context(), claim.Id)
+		if err != nil {
+			return "", errors.Wrap(err, "access token is invalid")
+		}
+
+		if revoked {
+			return "", errors.New("access token has been revoked")
+		}
+	}
+
+	user, err := auth.repo.GetUserById(ctx.Request.Context(), claim.UserId)
 	if err != nil {
 		return "", errors.Wrap(err, "access token is invalid")
 	}
 
-	if !tkn.Valid {
-		return "", errors.New("access token is invalid")
+	if user.TokenVersion != claim.TokenVersion {
+		return "", errors.New("access token has been revoked")
+	}
+
+	if user.Disabled {
+		return "", errors.New("account is disabled")
+	}
+
+	if claim.SessionId != 0 {
+		if err := auth.checkSession(ctx, claim); err != nil {
+			return "", err
+		}
 	}
 
-	claim := tkn.Claims.(*Claims)
 	ctx.Set("claims", claim)
+	ctx.Set("user", user)
 
 	return accessToken, nil
 }
 
+// GenerateAccessToken signs a new access token for the user with the given scopes and returns it with its expiry,
+// the token is bound to the session unless the session id is 0
+func (auth *Auth) GenerateAccessToken(user models.User, scopes []string, sessionId int64) (string, int64, error) {
+	expiresAt := time.Now().UTC().Add(AccessTokenExpirationMinutes * time.Minute).Unix()
+	claims := &Claims{
+		UserId:       user.UserId,
+		Email:        user.Email,
+		Name:         user.Name,
+		TokenVersion: user.TokenVersion,
+		Scope:        JoinScopes(scopes),
+		Role:         UserRole(user.Role),
+		SessionId:    sessionId,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expiresAt,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString(auth.jwtKey)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "failed to sign access token")
+	}
+
+	return tokenString, expiresAt, nil
+}
+
+// RequestAccessToken reads the token from the x-access-token header or else from the bearer authorization
+func RequestAccessToken(ctx *gin.Context) string {
+	if accessToken := ctx.GetHeader(TokenHeader); len(accessToken) > 0 {
+		return accessToken
+	}
+
+	authorization := ctx.GetHeader(AuthorizationHeader)
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+
+	return ""
+}
+
+// The tokens other than the access tokens, like the verification links or the mfa challenges, are signed with a key
+// derived from the jwt key and their purpose, so a token of one purpose is rejected as a token of any other purpose,
+// an access token included, and their claims don't have to tell them apart.
+
+// signPurpose signs the claims of a token of the purpose
+func (auth *Auth) signPurpose(purpose string, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(auth.purposeKey(purpose))
+}
+
+// parsePurpose validates a token of the purpose and reads its claims
+func (auth *Auth) parsePurpose(purpose string, tokenString string, claims jwt.Claims) error {
+	tkn, err := jwt.ParseWithClaims(tokenString, claims, auth.keyFunc(auth.purposeKey(purpose)))
+	if err != nil {
+		return err
+	}
+
+	if !tkn.Valid {
+		return errors.New("token is invalid")
+	}
+
+	return nil
+}
+
+// purposeKey derives the signing key of the tokens of the purpose
+func (auth *Auth) purposeKey(purpose string) []byte {
+	mac := hmac.New(sha256.New, auth.jwtKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (auth *Auth) keyFunc(key []byte) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		return key, nil
+	}
+}
+
 var _ = (*IAuth)(nil)
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+const (
+	// SessionCookie keeps the access token when the login asked for the cookie mode, it is HttpOnly
+	SessionCookie = "gos_session"
+	// CSRFCookie keeps the csrf token, it is readable by the front-end which sends it back in the CSRFHeader
+	CSRFCookie = "gos_csrf"
+	// CSRFHeader must repeat the csrf cookie on the state-changing requests authenticated by the session cookie
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// csrfPurpose derives the key of the csrf tokens, see purposeKey
+const csrfPurpose = "csrf"
+
+// GenerateCSRFToken returns a csrf token signed for the session, a token planted by
+// another site or another session doesn't validate
+func (auth *Auth) GenerateCSRFToken(sessionToken string) (string, error) {
+	random, err := GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	return random + "." + auth.csrfSignature(random, sessionToken), nil
+}
+
+// ValidateCSRFToken checks that the csrf token was issued for the session
+func (auth *Auth) ValidateCSRFToken(sessionToken string, csrfToken string) bool {
+	i := strings.LastIndex(csrfToken, ".")
+	if i <= 0 {
+		return false
+	}
+
+	expected := auth.csrfSignature(csrfToken[:i], sessionToken)
+	return hmac.Equal([]byte(expected), []byte(csrfToken[i+1:]))
+}
+
+func (auth *Auth) csrfSignature(random string, sessionToken string) string {
+	mac := hmac.New(sha256.New, auth.purposeKey(csrfPurpose))
+	mac.Write([]byte(random + "." + HashToken(sessionToken)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
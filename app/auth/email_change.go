@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"gos/app/models"
+	"time"
+)
+
+// emailChangePurpose signs the links confirming a new email
+const emailChangePurpose = "email-change"
+
+// EmailChangeClaims is used for the links confirming a new email, the current email binds the link to it
+// so a link stops working once the email changed
+type EmailChangeClaims struct {
+	UserId   int64  `json:"userId"`
+	Email    string `json:"email"`
+	NewEmail string `json:"newEmail"`
+	jwt.StandardClaims
+}
+
+// GenerateEmailChangeToken signs a token proving the ownership of the new email
+func (auth *Auth) GenerateEmailChangeToken(user models.User, newEmail string, ttl time.Duration) (string, error) {
+	claims := &EmailChangeClaims{
+		UserId:   user.UserId,
+		Email:    user.Email,
+		NewEmail: newEmail,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().UTC().Add(ttl).Unix(),
+		},
+	}
+
+	tokenString, err := auth.signPurpose(emailChangePurpose, claims)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign email change token")
+	}
+
+	return tokenString, nil
+}
+
+// ParseEmailChangeToken validates an email change token and returns its claims
+func (auth *Auth) ParseEmailChangeToken(emailChangeToken string) (*EmailChangeClaims, error) {
+	claims := &EmailChangeClaims{}
+	if err := auth.parsePurpose(emailChangePurpose, emailChangeToken, claims); err != nil {
+		return nil, errors.Wrap(err, "email change token is invalid")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"gos/app/models"
+)
+
+// exportDownloadPurpose signs the download links of the exports
+const exportDownloadPurpose = "export-download"
+
+// ExportDownloadClaims is used for the download links of the data exports
+type ExportDownloadClaims struct {
+	JobId  int64 `json:"jobId"`
+	UserId int64 `json:"userId"`
+	jwt.StandardClaims
+}
+
+// GenerateExportDownloadToken signs a token for downloading the file of an export job until expiresAt
+func (auth *Auth) GenerateExportDownloadToken(job models.ExportJob, expiresAt int64) (string, error) {
+	claims := &ExportDownloadClaims{
+		JobId:  job.JobId,
+		UserId: job.UserId,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expiresAt,
+		},
+	}
+
+	tokenString, err := auth.signPurpose(exportDownloadPurpose, claims)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign download token")
+	}
+
+	return tokenString, nil
+}
+
+// ParseExportDownloadToken validates a download token and returns its claims
+func (auth *Auth) ParseExportDownloadToken(downloadToken string) (*ExportDownloadClaims, error) {
+	claims := &ExportDownloadClaims{}
+	if err := auth.parsePurpose(exportDownloadPurpose, downloadToken, claims); err != nil {
+		return nil, errors.Wrap(err, "download token is invalid")
+	}
+
+	return claims, nil
+}
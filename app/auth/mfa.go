@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"gos/app/models"
+	"time"
+)
+
+// mfaChallengePurpose signs the challenges of the second login step
+const mfaChallengePurpose = "mfa-challenge"
+
+// MFAChallengeClaims is used for the second step of the login when 2fa is enabled
+type MFAChallengeClaims struct {
+	UserId       int64  `json:"userId"`
+	TokenVersion int    `json:"ver"`
+	Scope        string `json:"scope,omitempty"`
+	jwt.StandardClaims
+}
+
+// GenerateMFAChallengeToken signs a short-lived token proving the first login step (the password) succeeded,
+// the scopes requested at the first step are kept for the access token issued after the second one
+func (auth *Auth) GenerateMFAChallengeToken(user models.User, scopes []string, ttl time.Duration) (string, int64, error) {
+	expiresAt := time.Now().UTC().Add(ttl).Unix()
+	claims := &MFAChallengeClaims{
+		UserId:       user.UserId,
+		TokenVersion: user.TokenVersion,
+		Scope:        JoinScopes(scopes),
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expiresAt,
+		},
+	}
+
+	tokenString, err := auth.signPurpose(mfaChallengePurpose, claims)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "failed to sign mfa challenge token")
+	}
+
+	return tokenString, expiresAt, nil
+}
+
+// ParseMFAChallengeToken validates a challenge token and returns its claims
+func (auth *Auth) ParseMFAChallengeToken(challengeToken string) (*MFAChallengeClaims, error) {
+	claims := &MFAChallengeClaims{}
+	if err := auth.parsePurpose(mfaChallengePurpose, challengeToken, claims); err != nil {
+		return nil, errors.Wrap(err, "mfa token is invalid")
+	}
+
+	return claims, nil
+}
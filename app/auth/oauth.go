@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"gos/app/models"
+	"strings"
+	"time"
+)
+
+// PKCEMethodS256 is the only supported code challenge method, plain is rejected
+const PKCEMethodS256 = "S256"
+
+// clientSubjectPrefix marks the subject of the tokens issued with the client credentials grant
+const clientSubjectPrefix = "client:"
+
+// GenerateOAuthAccessToken signs an access token issued to an oauth client,
+// the user is nil for the client credentials grant where the client acts on its own behalf
+func (auth *Auth) GenerateOAuthAccessToken(user *models.User, clientId string, scopes []string, ttl time.Duration) (string, int64, error) {
+	tokenId, err := GenerateRandomToken()
+	if err != nil {
+		return "", 0, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(ttl).Unix()
+	claims := &Claims{
+		ClientId: clientId,
+		Scope:    JoinScopes(scopes),
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt,
+			Subject:   clientSubjectPrefix + clientId,
+		},
+	}
+
+	if user != nil {
+		claims.UserId = user.UserId
+		claims.Email = user.Email
+		claims.Name = user.Name
+		claims.TokenVersion = user.TokenVersion
+		claims.Subject = fmt.Sprint(user.UserId)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString(auth.jwtKey)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "failed to sign access token")
+	}
+
+	return tokenString, expiresAt, nil
+}
+
+// ParseAccessToken validates the signature and expiry of a jwt access token and returns its claims
+func (auth *Auth) ParseAccessToken(accessToken string) (*Claims, error) {
+	claims := &Claims{}
+	tkn, err := jwt.ParseWithClaims(accessToken, claims, auth.keyFunc(auth.jwtKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "access token is invalid")
+	}
+
+	if !tkn.Valid {
+		return nil, errors.New("access token is invalid")
+	}
+
+	return claims, nil
+}
+
+// AuthenticateClient checks the client credentials, public clients are authenticated by their id only
+func (auth *Auth) AuthenticateClient(ctx context.Context, clientId string, clientSecret string) (*models.OAuthClient, error) {
+	client, err := auth.repo.GetOAuthClientById(ctx, clientId)
+	if err != nil {
+		return nil, errors.New("client authentication failed")
+	}
+
+	if !client.Confidential {
+		if len(clientSecret) > 0 {
+			return nil, errors.New("client authentication failed")
+		}
+
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashToken(clientSecret)), []byte(client.ClientSecretHash)) != 1 {
+		return nil, errors.New("client authentication failed")
+	}
+
+	return client, nil
+}
+
+// IntrospectToken returns the state of an access token or a personal access token as defined by RFC 7662
+func (auth *Auth) IntrospectToken(ctx context.Context, token string) *models.IntrospectionResponse {
+	inactive := &models.IntrospectionResponse{Active: false}
+
+	if strings.HasPrefix(token, PersonalAccessTokenPrefix) {
+		pat, err := auth.repo.GetAccessTokenByTokenHash(ctx, HashToken(token))
+		if err != nil || pat.DateRevoked != 0 || (pat.ExpiresAt != 0 && pat.ExpiresAt < time.Now().Unix()) {
+			return inactive
+		}
+
+		user, err := auth.repo.GetUserById(ctx, pat.UserId)
+		if err != nil || user.Disabled {
+			return inactive
+		}
+
+		return &models.IntrospectionResponse{
+			Active:    true,
+			Scope:     JoinScopes(pat.Scopes),
+			Username:  user.Email,
+			TokenType: "Bearer",
+			Exp:       pat.ExpiresAt,
+			Iat:       pat.DateCreated,
+			Sub:       fmt.Sprint(user.UserId),
+		}
+	}
+
+	claims, err := auth.ParseAccessToken(token)
+	if err != nil {
+		return inactive
+	}
+
+	if len(claims.Id) > 0 {
+		revoked, err := auth.repo.IsTokenRevoked(ctx, claims.Id)
+		if err != nil || revoked {
+			return inactive
+		}
+	}
+
+	response := &models.IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientId:  claims.ClientId,
+		TokenType: "Bearer",
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Sub:       claims.Subject,
+	}
+
+	if claims.UserId != 0 {
+		user, err := auth.repo.GetUserById(ctx, claims.UserId)
+		if err != nil || user.TokenVersion != claims.TokenVersion || user.Disabled {
+			return inactive
+		}
+
+		// a token bound to a revoked session is dead, as it is under /api/secured
+		if claims.SessionId != 0 {
+			if _, err := auth.activeSession(ctx, claims); err != nil {
+				return inactive
+			}
+		}
+
+		response.Username = user.Email
+		response.Sub = fmt.Sprint(user.UserId)
+	}
+
+	return response
+}
+
+// RevokeToken revokes an access token issued to the client as defined by RFC 7009,
+// tokens which are invalid or issued to other clients are ignored
+func (auth *Auth) RevokeToken(ctx context.Context, client *models.OAuthClient, token string) error {
+	claims, err := auth.ParseAccessToken(token)
+	if err != nil || len(claims.Id) == 0 || claims.ClientId != client.ClientId {
+		return nil
+	}
+
+	_, err = auth.repo.AddRevokedToken(ctx, claims.Id, claims.ExpiresAt, time.Now().Unix())
+	return err
+}
+
+// VerifyPKCE checks the code verifier against the code challenge as defined by RFC 7636
+func VerifyPKCE(codeVerifier string, codeChallenge string, method string) bool {
+	if method != PKCEMethodS256 || len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(codeVerifier)), []byte(codeChallenge)) == 1
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// oidcStatePurpose signs the state cookies of the external logins
+const oidcStatePurpose = "oidc-state"
+
+// OIDCStateClaims keeps what the callback of an external login needs, it is stored in a cookie bound to the browser
+type OIDCStateClaims struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"cv"`
+	Scope        string `json:"scope,omitempty"`
+	// Cookie asks for the session cookie instead of the token in the response
+	Cookie bool `json:"cookie,omitempty"`
+	jwt.StandardClaims
+}
+
+// GenerateOIDCStateToken creates the state, nonce and PKCE verifier of an external login and signs them,
+// the state token is returned with the claims so the caller can build the authorization url
+func (auth *Auth) GenerateOIDCStateToken(scopes []string, cookie bool, ttl time.Duration) (string, *OIDCStateClaims, error) {
+	claims := &OIDCStateClaims{
+		Scope:  JoinScopes(scopes),
+		Cookie: cookie,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().UTC().Add(ttl).Unix(),
+		},
+	}
+
+	var err error
+	if claims.State, err = GenerateRandomToken(); err != nil {
+		return "", nil, err
+	}
+
+	if claims.Nonce, err = GenerateRandomToken(); err != nil {
+		return "", nil, err
+	}
+
+	if claims.CodeVerifier, err = GenerateRandomToken(); err != nil {
+		return "", nil, err
+	}
+
+	tokenString, err := auth.signPurpose(oidcStatePurpose, claims)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to sign state token")
+	}
+
+	return tokenString, claims, nil
+}
+
+// ParseOIDCStateToken validates a state token and returns its claims
+func (auth *Auth) ParseOIDCStateToken(stateToken string) (*OIDCStateClaims, error) {
+	claims := &OIDCStateClaims{}
+	if err := auth.parsePurpose(oidcStatePurpose, stateToken, claims); err != nil {
+		return nil, errors.Wrap(err, "state token is invalid")
+	}
+
+	return claims, nil
+}
+
+// PKCEChallenge returns the S256 code challenge of a code verifier as defined by RFC 7636
+func PKCEChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
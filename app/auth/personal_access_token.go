@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// PersonalAccessTokenPrefix marks the personal access tokens, so they can be told apart from the jwt access tokens
+const PersonalAccessTokenPrefix = "gos_pat_"
+
+// lastUsedResolution limits how often the last used timestamp of a token is written
+const lastUsedResolution = time.Minute
+
+// GeneratePersonalAccessToken creates a new random personal access token
+func GeneratePersonalAccessToken() (string, error) {
+	token, err := GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+func (auth *Auth) authenticatePersonalAccessToken(ctx *gin.Context, accessToken string) (string, error) {
+	token, err := auth.repo.GetAccessTokenByTokenHash(ctx.Request.Context(), HashToken(accessToken))
+	if err != nil {
+		return "", errors.Wrap(err, "access token is invalid")
+	}
+
+	now := time.Now().Unix()
+	if token.DateRevoked != 0 {
+		return "", errors.New("access token has been revoked")
+	}
+
+	if token.ExpiresAt != 0 && token.ExpiresAt < now {
+		return "", errors.New("access token is expired")
+	}
+
+	user, err := auth.repo.GetUserById(ctx.Request.Context(), token.UserId)
+	if err != nil {
+		return "", errors.Wrap(err, "access token is invalid")
+	}
+
+	if user.Disabled {
+		return "", errors.New("account is disabled")
+	}
+
+	if now-token.LastUsed >= int64(lastUsedResolution.Seconds()) {
+		_, err = auth.repo.UpdateAccessTokenLastUsed(ctx.Request.Context(), token.TokenId, now)
+		if err != nil {
+			fmt.Println(errors.Wrap(err, "failed to update the last used of the access token"))
+		}
+	}
+
+	ctx.Set("claims", &Claims{
+		UserId:       user.UserId,
+		Email:        user.Email,
+		Name:         user.Name,
+		TokenVersion: user.TokenVersion,
+		Scope:        JoinScopes(token.Scopes),
+		Role:         UserRole(user.Role),
+	})
+	ctx.Set("user", user)
+	ctx.Set("personalAccessToken", token)
+
+	return accessToken, nil
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// RoleUser is the role of every registered user
+	RoleUser = "user"
+	// RoleAdmin can use the admin routes
+	RoleAdmin = "admin"
+)
+
+// customRolePattern is what custom role names may look like, e.g. support or billing-viewer
+var customRolePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,49}$`)
+
+// ValidateRole checks the name of a built-in or custom role
+func ValidateRole(role string) error {
+	if !customRolePattern.MatchString(role) {
+		return fmt.Errorf("role [%s] must be lowercase letters, digits, - or _ and at most 50 characters", role)
+	}
+
+	return nil
+}
+
+// UserRole returns the role of the user, users created before the roles were introduced are plain users
+func UserRole(role string) string {
+	if len(role) == 0 {
+		return RoleUser
+	}
+
+	return role
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ScopeTasksRead allows reading the tasks
+	ScopeTasksRead = "tasks:read"
+	// ScopeTasksWrite allows adding and changing the tasks
+	ScopeTasksWrite = "tasks:write"
+	// ScopeAccountAdmin allows managing the account, e.g. 2fa and access tokens
+	ScopeAccountAdmin = "account:admin"
+	// ScopeAdmin allows the admin routes, the user must have the admin role as well
+	ScopeAdmin = "admin"
+)
+
+// AllScopes are the scopes a user can grant
+var AllScopes = []string{ScopeTasksRead, ScopeTasksWrite, ScopeAccountAdmin, ScopeAdmin}
+
+// ValidateScopes checks that all the scopes are known
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !containsScope(AllScopes, scope) {
+			return fmt.Errorf("unknown scope [%s]", scope)
+		}
+	}
+
+	return nil
+}
+
+// IsSubset checks that all the requested scopes are in the granted ones
+func IsSubset(requested []string, granted []string) bool {
+	for _, scope := range requested {
+		if !containsScope(granted, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// JoinScopes returns the space separated form of the scopes used in storage and tokens
+func JoinScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// SplitScopes parses the space separated form of the scopes
+func SplitScopes(scopes string) []string {
+	return strings.Fields(scopes)
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/models"
+	"strings"
+	"time"
+)
+
+// checkSession rejects the tokens of revoked sessions and records when the session was last seen
+func (auth *Auth) checkSession(ctx *gin.Context, claim *Claims) error {
+	session, err := auth.activeSession(ctx.Request.Context(), claim)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if now-session.LastSeen >= int64(lastUsedResolution.Seconds()) {
+		_, err = auth.repo.UpdateSessionLastSeen(ctx.Request.Context(), session.SessionId, now)
+		if err != nil {
+			fmt.Println(errors.Wrap(err, "failed to update the last seen of the session"))
+		}
+	}
+
+	ctx.Set("session", session)
+	return nil
+}
+
+// activeSession returns the session of the token, an error when it doesn't exist, belongs to another user or was revoked
+func (auth *Auth) activeSession(ctx context.Context, claim *Claims) (*models.Session, error) {
+	session, err := auth.repo.GetSessionById(ctx, claim.SessionId)
+	if err != nil || session.UserId != claim.UserId {
+		return nil, errors.New("access token is invalid")
+	}
+
+	if session.DateRevoked != 0 {
+		return nil, errors.New("session has been revoked")
+	}
+
+	return session, nil
+}
+
+// DeviceName returns a short name like "Firefox on Linux" for a user agent
+func DeviceName(userAgent string) string {
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"curl/", "curl"}, {"PostmanRuntime/", "Postman"},
+	}
+	systems := []struct{ token, name string }{
+		{"Windows", "Windows"}, {"iPhone", "iOS"}, {"iPad", "iOS"}, {"Mac OS X", "macOS"},
+		{"Android", "Android"}, {"Linux", "Linux"},
+	}
+
+	browser := "Unknown browser"
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			return browser + " on " + s.name
+		}
+	}
+
+	return browser
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"gos/app/models"
+	"time"
+)
+
+// emailVerificationPurpose signs the verification links
+const emailVerificationPurpose = "email-verification"
+
+// VerificationClaims is used for the email verification links
+type VerificationClaims struct {
+	UserId int64  `json:"userId"`
+	Email  string `json:"email"`
+	jwt.StandardClaims
+}
+
+// GenerateVerificationToken signs a token proving the ownership of the user's current email
+func (auth *Auth) GenerateVerificationToken(user models.User, ttl time.Duration) (string, error) {
+	claims := &VerificationClaims{
+		UserId: user.UserId,
+		Email:  user.Email,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().UTC().Add(ttl).Unix(),
+		},
+	}
+
+	tokenString, err := auth.signPurpose(emailVerificationPurpose, claims)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign verification token")
+	}
+
+	return tokenString, nil
+}
+
+// ParseVerificationToken validates a verification token and returns its claims
+func (auth *Auth) ParseVerificationToken(verificationToken string) (*VerificationClaims, error) {
+	claims := &VerificationClaims{}
+	if err := auth.parsePurpose(emailVerificationPurpose, verificationToken, claims); err != nil {
+		return nil, errors.Wrap(err, "verification token is invalid")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,344 @@
+package config
+
+import (
+	"gos/app/mailer"
+	"gos/app/oidc"
+	"gos/app/password"
+	"gos/app/repo"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config keeps all the settings of the server, values are read from env variables
+type Config struct {
+	Address string
+	JWTKey  string
+	Db      repo.DbConfig
+	Mail    MailConfig
+	// Cache keeps the users and the tasks read from the repo, it is disabled when its driver is empty
+	Cache CacheConfig
+
+	// DbRequestTimeout bounds the db calls of a request, they are also canceled when the client goes away, 0 disables it
+	DbRequestTimeout time.Duration
+
+	// PasswordResetURL is the page the reset token is sent to, the token is appended as a query param
+	PasswordResetURL string
+	PasswordResetTTL time.Duration
+	// Password is the policy new passwords are checked against
+	Password password.Policy
+	// PasswordHasher is how new passwords are hashed, the older hashes are upgraded on login
+	PasswordHasher password.HasherConfig
+
+	// VerificationURL is the endpoint the verification token is sent to, the token is appended as a query param
+	VerificationURL            string
+	VerificationTTL            time.Duration
+	VerificationResendInterval time.Duration
+	// UnverifiedAccess is what users with an unverified email may do under /api/secured
+	UnverifiedAccess string
+	// EmailChangeURL is the endpoint the link confirming a new email is sent to, it is valid for VerificationTTL
+	EmailChangeURL string
+
+	// AccountDeletion is how deleted accounts are purged
+	AccountDeletion AccountDeletionConfig
+
+	// Export is where and for how long the data exports are kept
+	Export ExportConfig
+
+	// Lockout is when a login is locked after failed attempts
+	Lockout LockoutConfig
+
+	// SecurityEvents is how long the security events are kept
+	SecurityEvents SecurityEventsConfig
+
+	// TOTPIssuer is the name shown in the authenticator apps
+	TOTPIssuer      string
+	MFAChallengeTTL time.Duration
+	// ReauthWindow is how long after the login an account without a password may make the sensitive changes
+	// without a 2fa code
+	ReauthWindow time.Duration
+
+	// OAuthCodeTTL is how long an authorization code may be exchanged for a token
+	OAuthCodeTTL        time.Duration
+	OAuthAccessTokenTTL time.Duration
+
+	// Cookie is used when the login asks for the session cookie instead of the token in the response
+	Cookie CookieConfig
+
+	// OIDC is the external provider used for the login, it is disabled when the issuer is empty
+	OIDC OIDCConfig
+}
+
+const (
+	// UnverifiedAccessFull lets unverified users use all the secured routes
+	UnverifiedAccessFull = "full"
+	// UnverifiedAccessReadOnly lets unverified users only read under the secured routes
+	UnverifiedAccessReadOnly = "read-only"
+	// UnverifiedAccessNone blocks unverified users from all the secured routes
+	UnverifiedAccessNone = "none"
+)
+
+// MailConfig keeps the settings for delivering emails
+type MailConfig struct {
+	// Driver is either smtp or log
+	Driver string
+	// LogFile is used by the log driver, empty means stdout
+	LogFile string
+	SMTP    mailer.SMTPConfig
+}
+
+// CacheConfig keeps the settings of the cache of the repo
+type CacheConfig struct {
+	// Driver is memory for an in-process cache, empty disables the cache
+	Driver string
+	// Size is how many entries the memory cache keeps
+	Size int
+	// TTL is how long an entry is kept, the writes of the other instances are seen after it
+	TTL time.Duration
+}
+
+// AccountDeletionConfig keeps the settings for deleting accounts
+type AccountDeletionConfig struct {
+	// GracePeriod is how long the user has to cancel the deletion, the account is deleted right away when 0
+	GracePeriod time.Duration
+	// Tasks is what happens to the tasks of a deleted account: delete or anonymize
+	Tasks string
+	// PurgeInterval is how often the accounts due for deletion are purged
+	PurgeInterval time.Duration
+}
+
+// ExportConfig keeps the settings of the data exports
+type ExportConfig struct {
+	// Dir keeps the export files, it must be shared by all the instances
+	Dir string
+	// TTL is how long an export can be downloaded once it is done
+	TTL time.Duration
+	// LinkTTL is how long a download link is valid, it is never valid after the export expired
+	LinkTTL time.Duration
+	// DownloadURL is the endpoint the download links point to, the token is appended as a query param
+	DownloadURL string
+	// PurgeInterval is how often the expired exports are deleted
+	PurgeInterval time.Duration
+}
+
+// LockoutConfig keeps the settings for locking the login after failed attempts
+type LockoutConfig struct {
+	// MaxFailedAttempts is how many failed attempts in a row lock the login, the login is never locked when 0
+	MaxFailedAttempts int
+	// Duration is how long the login stays locked
+	Duration time.Duration
+}
+
+// SecurityEventsConfig keeps the settings of the security event log
+type SecurityEventsConfig struct {
+	// Retention is how long an event is kept
+	Retention time.Duration
+	// PurgeInterval is how often the events past the retention are deleted
+	PurgeInterval time.Duration
+}
+
+const (
+	// AccountDeletionTasksDelete deletes the tasks with the account
+	AccountDeletionTasksDelete = "delete"
+	// AccountDeletionTasksAnonymize keeps the tasks without an owner
+	AccountDeletionTasksAnonymize = "anonymize"
+)
+
+// CookieConfig keeps the attributes of the session and csrf cookies
+type CookieConfig struct {
+	// Domain is empty for a host-only cookie
+	Domain string
+	// Secure should only be turned off for local development over http
+	Secure bool
+	// SameSite is strict, lax or none
+	SameSite string
+}
+
+// OIDCConfig keeps the settings for the login with an external OpenID Connect provider
+type OIDCConfig struct {
+	Provider oidc.Config
+	// AutoProvision creates an account on the first login when no user has the email
+	AutoProvision bool
+	// StateTTL is how long the user has to complete the login at the provider
+	StateTTL time.Duration
+}
+
+// Enabled tells if a provider is configured
+func (c OIDCConfig) Enabled() bool {
+	return len(c.Provider.IssuerURL) > 0
+}
+
+// Load reads the config from env variables, falling back to defaults for local development
+func Load() Config {
+	return Config{
+		Address: getEnv("GOS_ADDRESS", ":8080"),
+		JWTKey:  getEnv("GOS_JWT_KEY", "some-key"),
+		Db: repo.DbConfig{
+			Driver:       getEnv("GOS_DB_DRIVER", repo.DriverMySQL),
+			DSN:          getEnv("GOS_DB_DSN", ""),
+			BusyTimeout:  getEnvDuration("GOS_DB_BUSY_TIMEOUT", 5*time.Second),
+			AutoMigrate:  getEnvBool("GOS_DB_AUTO_MIGRATE", false),
+			Host:         getEnv("GOS_DB_HOST", "127.0.0.1"),
+			Port:         getEnvInt("GOS_DB_PORT", 3306),
+			DatabaseName: getEnv("GOS_DB_NAME", "gos"),
+			User:         getEnv("GOS_DB_USER", "gos"),
+			Password:     getEnv("GOS_DB_PASSWORD", "1234"),
+
+			MaxOpenConns:    getEnvInt("GOS_DB_MAX_OPEN_CONNS", 25),
+			MaxIdleConns:    getEnvInt("GOS_DB_MAX_IDLE_CONNS", 10),
+			ConnMaxLifetime: getEnvDuration("GOS_DB_CONN_MAX_LIFETIME", 30*time.Minute),
+			ConnMaxIdleTime: getEnvDuration("GOS_DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+			ConnectRetries:  getEnvInt("GOS_DB_CONNECT_RETRIES", 5),
+			ConnectBackoff:  getEnvDuration("GOS_DB_CONNECT_BACKOFF", time.Second),
+
+			TLS:       getEnv("GOS_DB_TLS", ""),
+			TLSCA:     getEnv("GOS_DB_TLS_CA", ""),
+			Charset:   getEnv("GOS_DB_CHARSET", ""),
+			Collation: getEnv("GOS_DB_COLLATION", "utf8mb4_general_ci"),
+			ParseTime: getEnvBool("GOS_DB_PARSE_TIME", false),
+
+			Replicas:             getEnvList("GOS_DB_REPLICAS", ""),
+			ReplicaCheckInterval: getEnvDuration("GOS_DB_REPLICA_CHECK_INTERVAL", 5*time.Second),
+			ReadYourWritesWindow: getEnvDuration("GOS_DB_READ_YOUR_WRITES_WINDOW", 5*time.Second),
+		},
+		Cache: CacheConfig{
+			Driver: getEnv("GOS_CACHE", ""),
+			Size:   getEnvInt("GOS_CACHE_SIZE", 10000),
+			TTL:    getEnvDuration("GOS_CACHE_TTL", 30*time.Second),
+		},
+		DbRequestTimeout: getEnvDuration("GOS_DB_REQUEST_TIMEOUT", 10*time.Second),
+		Mail: MailConfig{
+			Driver:  getEnv("GOS_MAIL_DRIVER", "log"),
+			LogFile: getEnv("GOS_MAIL_LOG_FILE", ""),
+			SMTP: mailer.SMTPConfig{
+				Host:     getEnv("GOS_SMTP_HOST", "127.0.0.1"),
+				Port:     getEnvInt("GOS_SMTP_PORT", 25),
+				User:     getEnv("GOS_SMTP_USER", ""),
+				Password: getEnv("GOS_SMTP_PASSWORD", ""),
+				From:     getEnv("GOS_SMTP_FROM", "no-reply@gos.local"),
+			},
+		},
+		PasswordResetURL: getEnv("GOS_PASSWORD_RESET_URL", "http://localhost:8080/reset-password"),
+		PasswordResetTTL: getEnvDuration("GOS_PASSWORD_RESET_TTL", 30*time.Minute),
+		Password: password.Policy{
+			MinLength:        getEnvInt("GOS_PASSWORD_MIN_LENGTH", 8),
+			MaxBytes:         getEnvInt("GOS_PASSWORD_MAX_BYTES", 72),
+			Classes:          getEnvList("GOS_PASSWORD_CLASSES", "lower,upper,digit"),
+			BreachedDir:      getEnv("GOS_PASSWORD_BREACHED_DIR", ""),
+			BreachedMinCount: getEnvInt("GOS_PASSWORD_BREACHED_MIN_COUNT", 1),
+		},
+		PasswordHasher: password.HasherConfig{
+			Algorithm:  getEnv("GOS_PASSWORD_HASHER", password.AlgorithmBcrypt),
+			BcryptCost: getEnvInt("GOS_BCRYPT_COST", 10),
+			Argon2id: password.Argon2idParams{
+				Time:    uint32(getEnvInt("GOS_ARGON2_TIME", 3)),
+				Memory:  uint32(getEnvInt("GOS_ARGON2_MEMORY", 64*1024)),
+				Threads: uint8(getEnvInt("GOS_ARGON2_THREADS", 2)),
+			},
+		},
+
+		VerificationURL:            getEnv("GOS_VERIFICATION_URL", "http://localhost:8080/api/auth/verify"),
+		VerificationTTL:            getEnvDuration("GOS_VERIFICATION_TTL", 48*time.Hour),
+		VerificationResendInterval: getEnvDuration("GOS_VERIFICATION_RESEND_INTERVAL", 5*time.Minute),
+		UnverifiedAccess:           getEnv("GOS_UNVERIFIED_ACCESS", UnverifiedAccessReadOnly),
+		EmailChangeURL:             getEnv("GOS_EMAIL_CHANGE_URL", "http://localhost:8080/api/auth/email/confirm"),
+
+		AccountDeletion: AccountDeletionConfig{
+			GracePeriod:   getEnvDuration("GOS_ACCOUNT_DELETION_GRACE_PERIOD", 7*24*time.Hour),
+			Tasks:         getEnv("GOS_ACCOUNT_DELETION_TASKS", AccountDeletionTasksDelete),
+			PurgeInterval: getEnvDuration("GOS_ACCOUNT_PURGE_INTERVAL", time.Hour),
+		},
+
+		Export: ExportConfig{
+			Dir:           getEnv("GOS_EXPORT_DIR", filepath.Join(os.TempDir(), "gos-exports")),
+			TTL:           getEnvDuration("GOS_EXPORT_TTL", 24*time.Hour),
+			LinkTTL:       getEnvDuration("GOS_EXPORT_LINK_TTL", 15*time.Minute),
+			DownloadURL:   getEnv("GOS_EXPORT_DOWNLOAD_URL", "http://localhost:8080/api/exports/download"),
+			PurgeInterval: getEnvDuration("GOS_EXPORT_PURGE_INTERVAL", time.Hour),
+		},
+
+		Lockout: LockoutConfig{
+			MaxFailedAttempts: getEnvInt("GOS_LOGIN_MAX_FAILED_ATTEMPTS", 5),
+			Duration:          getEnvDuration("GOS_LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		},
+
+		SecurityEvents: SecurityEventsConfig{
+			Retention:     getEnvDuration("GOS_SECURITY_EVENT_RETENTION", 90*24*time.Hour),
+			PurgeInterval: getEnvDuration("GOS_SECURITY_EVENT_PURGE_INTERVAL", time.Hour),
+		},
+
+		TOTPIssuer:      getEnv("GOS_TOTP_ISSUER", "GOS"),
+		MFAChallengeTTL: getEnvDuration("GOS_MFA_CHALLENGE_TTL", 5*time.Minute),
+		ReauthWindow:    getEnvDuration("GOS_REAUTH_WINDOW", 5*time.Minute),
+
+		OAuthCodeTTL:        getEnvDuration("GOS_OAUTH_CODE_TTL", 10*time.Minute),
+		OAuthAccessTokenTTL: getEnvDuration("GOS_OAUTH_ACCESS_TOKEN_TTL", time.Hour),
+
+		Cookie: CookieConfig{
+			Domain:   getEnv("GOS_COOKIE_DOMAIN", ""),
+			Secure:   getEnvBool("GOS_COOKIE_SECURE", true),
+			SameSite: getEnv("GOS_COOKIE_SAMESITE", "strict"),
+		},
+
+		OIDC: OIDCConfig{
+			Provider: oidc.Config{
+				IssuerURL:    getEnv("GOS_OIDC_ISSUER_URL", ""),
+				ClientId:     getEnv("GOS_OIDC_CLIENT_ID", ""),
+				ClientSecret: getEnv("GOS_OIDC_CLIENT_SECRET", ""),
+				RedirectURL:  getEnv("GOS_OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/callback"),
+				Scopes:       strings.Fields(getEnv("GOS_OIDC_SCOPES", "openid email profile")),
+			},
+			AutoProvision: getEnvBool("GOS_OIDC_AUTO_PROVISION", true),
+			StateTTL:      getEnvDuration("GOS_OIDC_STATE_TTL", 10*time.Minute),
+		},
+	}
+}
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+// getEnvList splits a comma separated value, empty items are dropped
+func getEnvList(key string, fallback string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(getEnv(key, fallback), ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
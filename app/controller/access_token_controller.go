@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/auth"
+	"gos/app/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultAccessTokenDays is the lifetime of a personal access token when none is given
+const defaultAccessTokenDays = 30
+
+// maxAccessTokenDays is the longest lifetime of a personal access token
+const maxAccessTokenDays = 365
+
+// swagger:operation POST /api/secured/tokens CreateAccessToken
+//
+// CreateAccessToken creates a personal access token, the token is shown only once
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: body
+//   in: body
+//   description: the access token to be created
+//   schema:
+//    $ref: '#/definitions/CreateAccessTokenRequest'
+// responses:
+//  '201':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: insufficient scope
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) CreateAccessToken(ctx *gin.Context) {
+	claims := ctx.MustGet("claims")
+	claimsObj := claims.(*auth.Claims)
+	request := new(models.CreateAccessTokenRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if len(request.Name) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field name is required")))
+		return
+	}
+
+	if len(request.Scopes) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field scopes is required")))
+		return
+	}
+
+	if err := auth.ValidateScopes(request.Scopes); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	// a token can only be down-scoped, it never gets more than the token used to create it
+	if !auth.IsSubset(request.Scopes, claimsObj.Scopes()) {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, getErrorResponse("failed to create access token",
+			errors.New("the requested scopes exceed the scopes of the current token")))
+		return
+	}
+
+	if request.ExpiresInDays == 0 {
+		request.ExpiresInDays = defaultAccessTokenDays
+	}
+
+	if request.ExpiresInDays < 0 || request.ExpiresInDays > maxAccessTokenDays {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request",
+			fmt.Errorf("field expiresInDays must be between 1 and %d", maxAccessTokenDays)))
+		return
+	}
+
+	tokenString, err := auth.GeneratePersonalAccessToken()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to create access token", err)
+		return
+	}
+
+	now := time.Now()
+	token := &models.AccessToken{
+		UserId:      claimsObj.UserId,
+		Name:        request.Name,
+		TokenHash:   auth.HashToken(tokenString),
+		Scopes:      request.Scopes,
+		DateCreated: now.Unix(),
+		ExpiresAt:   now.AddDate(0, 0, request.ExpiresInDays).Unix(),
+	}
+
+	result, err := c.appRepo.AddAccessToken(ctx.Request.Context(), *token)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to create access token", err)
+		return
+	}
+
+	token.TokenId, err = result.LastInsertId()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to create access token", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, &models.Response{
+		Message: "successfully created access token, store it safely, it is shown only once",
+		Data: models.CreateAccessTokenResponse{
+			Token:       tokenString,
+			AccessToken: token,
+		},
+	})
+}
+
+// swagger:operation GET /api/secured/tokens GetAccessTokens
+//
+// GetAccessTokens gets the personal access tokens of the logged in user which are not revoked
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetAccessTokens(ctx *gin.Context) {
+	claims := ctx.MustGet("claims")
+	claimsObj := claims.(*auth.Claims)
+
+	tokens, err := c.appRepo.GetAccessTokens(ctx.Request.Context(), claimsObj.UserId)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to get access tokens", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully retrieved %d access token/s", len(tokens)),
+		Data:    tokens,
+	})
+}
+
+// swagger:operation DELETE /api/secured/tokens/:tokenId RevokeAccessToken
+//
+// RevokeAccessToken revokes a personal access token of the logged in user
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: access token not found
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) RevokeAccessToken(ctx *gin.Context) {
+	tokenId, err := strconv.ParseInt(ctx.Param("tokenId"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("token id is invalid")))
+		return
+	}
+
+	claims := ctx.MustGet("claims")
+	claimsObj := claims.(*auth.Claims)
+
+	result, err := c.appRepo.RevokeAccessToken(ctx.Request.Context(), tokenId, claimsObj.UserId, time.Now().Unix())
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to revoke access token", err)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, getErrorResponse("failed to revoke access token", errors.New("access token not found")))
+		return
+	}
+
+	c.securityEvent(ctx, claimsObj.UserId, eventTokenRevoked, fmt.Sprintf("access token=%d", tokenId))
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully revoked access token with id %d", tokenId),
+	})
+}
@@ -0,0 +1,598 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/config"
+	"gos/app/mailer"
+	"gos/app/models"
+	"gos/app/repo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxNameLength is the size of the name column
+const maxNameLength = 100
+
+// maxEmailLength is the size of the email column
+const maxEmailLength = 200
+
+// purgeBatchSize is how many accounts are deleted in a purge run
+const purgeBatchSize = 100
+
+// swagger:operation GET /api/secured/me GetAccount
+//
+// GetAccount gets the account of the logged in user
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetAccount(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully retrieved account",
+		Data:    user,
+	})
+}
+
+// swagger:operation PATCH /api/secured/me UpdateAccount
+//
+// UpdateAccount changes the profile of the logged in user, the password and the email have their own endpoints
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: body
+//   in: body
+//   description: the fields to change
+//   schema:
+//    $ref: '#/definitions/UpdateAccountRequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) UpdateAccount(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+	request := new(models.UpdateAccountRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if request.Name != nil {
+		name := strings.TrimSpace(*request.Name)
+		if len(name) == 0 || len(name) > maxNameLength {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request",
+				fmt.Errorf("field name is required and must have at most %d characters", maxNameLength)))
+			return
+		}
+
+		user.Name = name
+	}
+
+	user.DateUpdated = time.Now().Unix()
+	_, err := c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to update account", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully updated account",
+		Data:    user,
+	})
+}
+
+// swagger:operation POST /api/secured/me/password ChangePassword
+//
+// ChangePassword sets a new password, all the access tokens issued before are revoked including the current one,
+// with the sessions and the personal access tokens
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: body
+//   in: body
+//   description: the current and the new password
+//   schema:
+//    $ref: '#/definitions/ChangePasswordRequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request or the password doesn't meet the policy
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: the current password is incorrect, or the change of an account without one isn't confirmed
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) ChangePassword(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+	request := new(models.ChangePasswordRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if !c.reauthenticate(ctx, user, request.CurrentPassword, request.Code, request.RecoveryCode, "failed to change password") {
+		return
+	}
+
+	if !c.checkPasswordPolicy(ctx, request.NewPassword, user.Email) {
+		return
+	}
+
+	hash, err := c.hasher.Hash(request.NewPassword)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to change password", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	user.Password = hash
+	user.FailedLoginAttempt = 0
+	user.DateUpdated = now
+	user.TokenVersion++
+
+	// a password changed after a compromise must cut off the sessions and the tokens made with the old one
+	err = c.appRepo.WithTx(ctx.Request.Context(), func(tx repo.IAppRepo) error {
+		_, err := tx.UpdateUser(ctx.Request.Context(), *user)
+		if err != nil {
+			return err
+		}
+
+		return c.revokeCredentials(ctx.Request.Context(), tx, user.UserId, now)
+	})
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to change password", err)
+		return
+	}
+
+	_, err = c.appRepo.InvalidatePasswordResets(ctx.Request.Context(), user.UserId, now)
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to invalidate password resets"))
+	}
+
+	c.securityEvent(ctx, user.UserId, eventPasswordChanged, "")
+	c.clearSessionCookies(ctx)
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully changed password, sign in again",
+	})
+}
+
+// swagger:operation POST /api/secured/me/email ChangeEmail
+//
+// ChangeEmail sends a confirmation link to the new email, the email changes once the link is opened
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: body
+//   in: body
+//   description: the password and the new email
+//   schema:
+//    $ref: '#/definitions/ChangeEmailRequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: the password is incorrect, or the change of an account without one isn't confirmed
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '409':
+//    description: the email is used by another account
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) ChangeEmail(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+	request := new(models.ChangeEmailRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	newEmail := strings.TrimSpace(request.Email)
+	if !strings.Contains(newEmail, "@") || len(newEmail) > maxEmailLength {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field email must be a valid email")))
+		return
+	}
+
+	if strings.EqualFold(newEmail, user.Email) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("the email is the current one")))
+		return
+	}
+
+	if !c.reauthenticate(ctx, user, request.Password, request.Code, request.RecoveryCode, "failed to change email") {
+		return
+	}
+
+	if _, err := c.appRepo.GetUserByEmail(ctx.Request.Context(), newEmail); err == nil {
+		ctx.AbortWithStatusJSON(http.StatusConflict, getErrorResponse(fmt.Sprintf("user with email [%s] exists", newEmail), nil))
+		return
+	} else if !isNotFound(err) {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to change email", err)
+		return
+	}
+
+	token, err := c.auth.GenerateEmailChangeToken(*user, newEmail, c.config.VerificationTTL)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to change email", err)
+		return
+	}
+
+	err = c.mailer.Send(ctx, mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to confirm your new email, it expires in %v.\n\n%s\n",
+			user.Name, c.config.VerificationTTL, tokenLink(c.config.EmailChangeURL, token)),
+	})
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to send the confirmation link", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "a confirmation link has been sent to the new email",
+	})
+}
+
+// swagger:operation GET /api/auth/email/confirm ConfirmEmailChange
+//
+// ConfirmEmailChange changes the email using the token from the confirmation link, the new email is verified
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: token
+//   in: query
+//   description: the email change token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '409':
+//    description: the email is used by another account
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) ConfirmEmailChange(ctx *gin.Context) {
+	token := ctx.Query("token")
+	if len(token) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("query param token is required")))
+		return
+	}
+
+	claims, err := c.auth.ParseEmailChangeToken(token)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "failed to change email", err)
+		return
+	}
+
+	user, err := c.appRepo.GetUserById(ctx.Request.Context(), claims.UserId)
+	if err != nil && !isNotFound(err) {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to change email", err)
+		return
+	}
+
+	if err != nil || user.Email != claims.Email {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to change email", errors.New("email change token is invalid")))
+		return
+	}
+
+	// the email could have been taken since the link was sent
+	if _, err := c.appRepo.GetUserByEmail(ctx.Request.Context(), claims.NewEmail); err == nil {
+		ctx.AbortWithStatusJSON(http.StatusConflict, getErrorResponse(fmt.Sprintf("user with email [%s] exists", claims.NewEmail), nil))
+		return
+	} else if !isNotFound(err) {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to change email", err)
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = claims.NewEmail
+	user.EmailVerified = true
+	user.DateUpdated = time.Now().Unix()
+
+	_, err = c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if isCause(err, repo.ErrDuplicate) {
+		ctx.AbortWithStatusJSON(http.StatusConflict, getErrorResponse(fmt.Sprintf("user with email [%s] exists", claims.NewEmail), nil))
+		return
+	}
+
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to change email", err)
+		return
+	}
+
+	c.securityEvent(ctx, user.UserId, eventEmailChanged, fmt.Sprintf("from %s to %s", oldEmail, user.Email))
+
+	// the old address is told, so a hijacked account doesn't go unnoticed
+	err = c.mailer.Send(ctx, mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email of your account was changed to %s. If it wasn't you, reset your password and contact us.\n",
+			user.Name, user.Email),
+	})
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to notify the old email"))
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully changed email",
+	})
+}
+
+// swagger:operation DELETE /api/secured/me DeleteAccount
+//
+// DeleteAccount schedules the deletion of the account after the grace period, it is deleted right away without one
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: body
+//   in: body
+//   description: the password
+//   schema:
+//    $ref: '#/definitions/DeleteAccountRequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: the password is incorrect, or the deletion of an account without one isn't confirmed
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) DeleteAccount(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+	request := new(models.DeleteAccountRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if !c.reauthenticate(ctx, user, request.Password, request.Code, request.RecoveryCode, "failed to delete account") {
+		return
+	}
+
+	if c.config.AccountDeletion.GracePeriod <= 0 {
+		err := c.deleteAccount(ctx.Request.Context(), user.UserId)
+		if err != nil {
+			abortWithError(ctx, http.StatusInternalServerError, "failed to delete account", err)
+			return
+		}
+
+		c.clearSessionCookies(ctx)
+		ctx.JSON(http.StatusOK, &models.Response{
+			Message: "successfully deleted account",
+		})
+		return
+	}
+
+	if user.DeletionScheduledAt == 0 {
+		now := time.Now()
+		user.DeletionScheduledAt = now.Add(c.config.AccountDeletion.GracePeriod).Unix()
+		user.DateUpdated = now.Unix()
+
+		_, err := c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+		if err != nil {
+			abortWithError(ctx, http.StatusInternalServerError, "failed to delete account", err)
+			return
+		}
+
+		c.securityEvent(ctx, user.UserId, eventAccountDeletionScheduled, fmt.Sprintf("deletion at %d", user.DeletionScheduledAt))
+
+		err = c.mailer.Send(ctx, mailer.Message{
+			To:      user.Email,
+			Subject: "Your account will be deleted",
+			Body: fmt.Sprintf("Hi %s,\n\nYour account and its data will be deleted on %s. Sign in and restore the account before then to keep it.\n",
+				user.Name, time.Unix(user.DeletionScheduledAt, 0).UTC().Format(time.RFC1123)),
+		})
+		if err != nil {
+			fmt.Println(errors.Wrap(err, "failed to send account deletion email"))
+		}
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "account deletion is scheduled, restore the account before then to keep it",
+		Data:    user,
+	})
+}
+
+// swagger:operation POST /api/secured/me/restore RestoreAccount
+//
+// RestoreAccount cancels the scheduled deletion of the account
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: no deletion is scheduled
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) RestoreAccount(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+	if user.DeletionScheduledAt == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to restore account", errors.New("no deletion is scheduled")))
+		return
+	}
+
+	user.DeletionScheduledAt = 0
+	user.DateUpdated = time.Now().Unix()
+
+	_, err := c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to restore account", err)
+		return
+	}
+
+	c.securityEvent(ctx, user.UserId, eventAccountRestored, "")
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully restored account",
+		Data:    user,
+	})
+}
+
+// PurgeDeletedAccounts deletes the accounts whose grace period is over, the number of deleted accounts is returned,
+// an account that fails is logged and retried on the next run
+func (c *AppController) PurgeDeletedAccounts(ctx context.Context, now int64) (int, error) {
+	users, err := c.appRepo.GetUsersDueForDeletion(ctx, now, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, user := range users {
+		err := c.deleteAccount(ctx, user.UserId)
+		if err != nil {
+			fmt.Println(errors.Wrapf(err, "failed to delete account %d", user.UserId))
+			continue
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// deleteAccount deletes the user with its records and export files
+func (c *AppController) deleteAccount(ctx context.Context, userId int64) error {
+	if err := c.removeExportFiles(ctx, userId); err != nil {
+		return err
+	}
+
+	return c.appRepo.DeleteUser(ctx, userId, c.config.AccountDeletion.Tasks == config.AccountDeletionTasksAnonymize)
+}
+
+// reauthenticate confirms a sensitive change with the password. The accounts without one (external login) confirm it
+// with a 2fa code or a recovery code, or else with a token of a login more recent than the reauth window, so a token
+// without a login session, like a personal access token, can't make the change. A wrong password or code counts as
+// a failed login, so a stolen token can't be used to guess them, and a locked login can't confirm anything. False is
+// returned when the request was aborted.
+func (c *AppController) reauthenticate(ctx *gin.Context, user *models.User, password string, code string, recoveryCode string, msg string) bool {
+	if c.loginLocked(ctx, user) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse(msg, errors.New("too many failed attempts, try again later")))
+		return false
+	}
+
+	if len(user.Password) > 0 {
+		if ok, _, err := c.hasher.Verify(user.Password, password); err != nil || !ok {
+			c.loginFailed(ctx, user, "wrong password")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse(msg, errors.New("password is incorrect")))
+			return false
+		}
+
+		return true
+	}
+
+	if user.TOTPEnabled && (len(code) > 0 || len(recoveryCode) > 0) {
+		if err := c.verifySecondFactor(ctx.Request.Context(), user, code, recoveryCode); err != nil {
+			c.loginFailed(ctx, user, "wrong 2fa code")
+			abortWithError(ctx, http.StatusUnauthorized, msg, err)
+			return false
+		}
+
+		return true
+	}
+
+	if session, ok := ctx.Get("session"); ok && time.Now().Unix()-session.(*models.Session).DateCreated <= int64(c.config.ReauthWindow.Seconds()) {
+		return true
+	}
+
+	ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse(msg, errors.New("login again or send a 2fa code to confirm the change")))
+	return false
+}
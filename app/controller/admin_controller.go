@@ -0,0 +1,629 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/auth"
+	"gos/app/models"
+	"gos/app/repo"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the actions written to the audit log
+const (
+	auditSearchUsers        = "user.search"
+	auditViewUser           = "user.view"
+	auditDisableUser        = "user.disable"
+	auditEnableUser         = "user.enable"
+	auditForcePasswordReset = "user.password_reset"
+	auditUpdateRole         = "user.role"
+	auditViewTasks          = "user.tasks"
+	auditViewAuditLog       = "audit.view"
+	auditViewSecurityLog    = "security.view"
+)
+
+// adminPageParams are the paging query params of the admin listings
+type adminPageParams struct {
+	Query  string `form:"q"`
+	UserId int64  `form:"userId"`
+	Type   string `form:"type"`
+	LastId int64  `form:"lastId"`
+	Limit  int    `form:"limit"`
+}
+
+// swagger:operation GET /api/admin/users GetUsers
+//
+// GetUsers lists the users, optionally those whose email or name contains q
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token of an admin
+//   type: string
+// - name: q
+//   in: query
+//   description: the text to search in the emails and names
+//   type: string
+// - name: lastId
+//   in: query
+//   description: the id of the last user in the previous page
+//   type: string
+// - name: limit
+//   in: query
+//   description: the page size value
+//   type: integer
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: not an admin
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetUsers(ctx *gin.Context) {
+	params, err := bindAdminPageParams(ctx)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "failed to extract query params", err)
+		return
+	}
+
+	users, err := c.appRepo.SearchUsers(ctx.Request.Context(), params.Query, params.LastId, params.Limit)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to get users", err)
+		return
+	}
+
+	c.audit(ctx, auditSearchUsers, 0, fmt.Sprintf("q=%q lastId=%d", params.Query, params.LastId))
+
+	var nextUserId int64
+	if len(users) > 0 {
+		nextUserId = users[len(users)-1].UserId
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully retrieved %d user/s", len(users)),
+		Data: &models.Paged{
+			Items:  users,
+			LastId: nextUserId,
+			Limit:  params.Limit,
+		},
+	})
+}
+
+// swagger:operation GET /api/admin/users/:userId GetUser
+//
+// GetUser gets any user
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token of an admin
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: not an admin
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: user not found
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetUser(ctx *gin.Context) {
+	user, ok := c.targetUser(ctx)
+	if !ok {
+		return
+	}
+
+	c.audit(ctx, auditViewUser, user.UserId, "")
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully retrieved user with id %d", user.UserId),
+		Data:    user,
+	})
+}
+
+// swagger:operation POST /api/admin/users/:userId/disable DisableUser
+//
+// DisableUser disables an account, its tokens are rejected and it can't login until it is enabled again
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token of an admin
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: not an admin
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: user not found
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) DisableUser(ctx *gin.Context) {
+	c.setUserDisabled(ctx, true)
+}
+
+// swagger:operation POST /api/admin/users/:userId/enable EnableUser
+//
+// EnableUser enables a disabled account
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token of an admin
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: not an admin
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: user not found
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) EnableUser(ctx *gin.Context) {
+	c.setUserDisabled(ctx, false)
+}
+
+// swagger:operation POST /api/admin/users/:userId/password-reset ForcePasswordReset
+//
+// ForcePasswordReset clears the password of a user, revokes its access tokens and emails a reset link
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token of an admin
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: not an admin
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: user not found
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) ForcePasswordReset(ctx *gin.Context) {
+	user, ok := c.targetUser(ctx)
+	if !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	user.Password = ""
+	user.TokenVersion++
+	user.DateUpdated = now
+
+	err := c.appRepo.WithTx(ctx.Request.Context(), func(tx repo.IAppRepo) error {
+		_, err := tx.UpdateUser(ctx.Request.Context(), *user)
+		if err != nil {
+			return err
+		}
+
+		return c.revokeCredentials(ctx.Request.Context(), tx, user.UserId, now)
+	})
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to force password reset", err)
+		return
+	}
+
+	c.audit(ctx, auditForcePasswordReset, user.UserId, "")
+
+	err = c.sendPasswordReset(ctx, *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to send password reset", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully forced a password reset for user with id %d", user.UserId),
+	})
+}
+
+// swagger:operation PUT /api/admin/users/:userId/role UpdateUserRole
+//
+// UpdateUserRole changes the role of a user, admins can't change their own role
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token of an admin
+//   type: string
+// - name: body
+//   in: body
+//   description: the new role
+//   schema:
+//    $ref: '#/definitions/UpdateRoleRequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: not an admin
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: user not found
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) UpdateUserRole(ctx *gin.Context) {
+	request := new(models.UpdateRoleRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if err := auth.ValidateRole(request.Role); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	user, ok := c.targetUser(ctx)
+	if !ok {
+		return
+	}
+
+	if c.isCurrentUser(ctx, user) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("admins can't change their own role")))
+		return
+	}
+
+	previous := auth.UserRole(user.Role)
+	user.Role = request.Role
+	user.DateUpdated = time.Now().Unix()
+
+	_, err := c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to update role", err)
+		return
+	}
+
+	c.audit(ctx, auditUpdateRole, user.UserId, fmt.Sprintf("%s -> %s", previous, user.Role))
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully changed the role of user with id %d to %s", user.UserId, user.Role),
+		Data:    user,
+	})
+}
+
+// swagger:operation GET /api/admin/users/:userId/tasks GetUserTasks
+//
+// GetUserTasks gets the tasks of any user
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token of an admin
+//   type: string
+// - name: lastId
+//   in: query
+//   description: the id of the last task in the previous page
+//   type: string
+// - name: limit
+//   in: query
+//   description: the page size value
+//   type: integer
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: not an admin
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: user not found
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetUserTasks(ctx *gin.Context) {
+	params, err := bindAdminPageParams(ctx)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "failed to extract query params", err)
+		return
+	}
+
+	user, ok := c.targetUser(ctx)
+	if !ok {
+		return
+	}
+
+	tasks, err := c.appRepo.GetAllTasks(ctx.Request.Context(), params.LastId, user.UserId, params.Limit)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to get tasks", err)
+		return
+	}
+
+	c.audit(ctx, auditViewTasks, user.UserId, fmt.Sprintf("lastId=%d", params.LastId))
+
+	var nextTaskId int64
+	if len(tasks) > 0 {
+		nextTaskId = tasks[len(tasks)-1].TaskId
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully retrieved %d task/s", len(tasks)),
+		Data: &models.Paged{
+			Items:  tasks,
+			LastId: nextTaskId,
+			Limit:  params.Limit,
+		},
+	})
+}
+
+// swagger:operation GET /api/admin/audit GetAuditLog
+//
+// GetAuditLog pages through the actions of the admins, optionally only those about a user
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token of an admin
+//   type: string
+// - name: userId
+//   in: query
+//   description: only the actions about this user
+//   type: integer
+// - name: lastId
+//   in: query
+//   description: the id of the last entry in the previous page
+//   type: string
+// - name: limit
+//   in: query
+//   description: the page size value
+//   type: integer
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: not an admin
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetAuditLog(ctx *gin.Context) {
+	params, err := bindAdminPageParams(ctx)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "failed to extract query params", err)
+		return
+	}
+
+	entries, err := c.appRepo.GetAuditEntries(ctx.Request.Context(), params.UserId, params.LastId, params.Limit)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to get audit log", err)
+		return
+	}
+
+	c.audit(ctx, auditViewAuditLog, params.UserId, fmt.Sprintf("lastId=%d", params.LastId))
+
+	var nextAuditId int64
+	if len(entries) > 0 {
+		nextAuditId = entries[len(entries)-1].AuditId
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully retrieved %d audit entries", len(entries)),
+		Data: &models.Paged{
+			Items:  entries,
+			LastId: nextAuditId,
+			Limit:  params.Limit,
+		},
+	})
+}
+
+func (c *AppController) setUserDisabled(ctx *gin.Context, disabled bool) {
+	user, ok := c.targetUser(ctx)
+	if !ok {
+		return
+	}
+
+	if c.isCurrentUser(ctx, user) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("admins can't disable their own account")))
+		return
+	}
+
+	user.Disabled = disabled
+	user.DateUpdated = time.Now().Unix()
+
+	_, err := c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to update user", err)
+		return
+	}
+
+	action, state := auditEnableUser, "enabled"
+	if disabled {
+		action, state = auditDisableUser, "disabled"
+	}
+
+	c.audit(ctx, action, user.UserId, "")
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully %s user with id %d", state, user.UserId),
+	})
+}
+
+// targetUser loads the user of the userId path param, the request is aborted when it can't
+func (c *AppController) targetUser(ctx *gin.Context) (*models.User, bool) {
+	userId, err := strconv.ParseInt(ctx.Param("userId"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("user id is invalid")))
+		return nil, false
+	}
+
+	user, err := c.appRepo.GetUserById(ctx.Request.Context(), userId)
+	if err != nil {
+		abortWithError(ctx, http.StatusNotFound, "failed to get user", err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (c *AppController) isCurrentUser(ctx *gin.Context, user *models.User) bool {
+	claims := ctx.MustGet("claims").(*auth.Claims)
+	return claims.UserId == user.UserId
+}
+
+// audit writes an admin action to the audit log, a failed write is only logged since the action already happened
+func (c *AppController) audit(ctx *gin.Context, action string, targetUserId int64, details string) {
+	claims := ctx.MustGet("claims").(*auth.Claims)
+
+	_, err := c.appRepo.AddAuditEntry(ctx.Request.Context(), models.AuditEntry{
+		ActorUserId:  claims.UserId,
+		Action:       action,
+		TargetUserId: targetUserId,
+		Details:      details,
+		IP:           ctx.ClientIP(),
+		DateCreated:  time.Now().Unix(),
+	})
+	if err != nil {
+		fmt.Println(errors.Wrapf(err, "failed to write audit entry %s by user %d", action, claims.UserId))
+	}
+}
+
+func bindAdminPageParams(ctx *gin.Context) (*adminPageParams, error) {
+	params := &adminPageParams{
+		Limit: 100,
+	}
+
+	if err := ctx.BindQuery(params); err != nil {
+		return nil, err
+	}
+
+	if params.Limit > 100 || params.Limit <= 0 {
+		params.Limit = 100
+	}
+
+	return params, nil
+}
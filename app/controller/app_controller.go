@@ -3,6 +3,10 @@ package controller
 import (
 	"github.com/gin-gonic/gin"
 	"gos/app/auth"
+	"gos/app/config"
+	"gos/app/mailer"
+	"gos/app/oidc"
+	"gos/app/password"
 	"gos/app/repo"
 )
 
@@ -10,22 +14,79 @@ import (
 type IAppController interface {
 	Register(ctx *gin.Context)
 	Login(ctx *gin.Context)
+	LoginMFA(ctx *gin.Context)
+	Logout(ctx *gin.Context)
+	ForgotPassword(ctx *gin.Context)
+	ResetPassword(ctx *gin.Context)
+	VerifyEmail(ctx *gin.Context)
+	ConfirmEmailChange(ctx *gin.Context)
+	ResendVerification(ctx *gin.Context)
+	OIDCLogin(ctx *gin.Context)
+	OIDCCallback(ctx *gin.Context)
+
+	EnrollTOTP(ctx *gin.Context)
+	ConfirmTOTP(ctx *gin.Context)
+	DisableTOTP(ctx *gin.Context)
+
+	CreateAccessToken(ctx *gin.Context)
+	GetAccessTokens(ctx *gin.Context)
+	RevokeAccessToken(ctx *gin.Context)
+
+	GetSessions(ctx *gin.Context)
+	RevokeSession(ctx *gin.Context)
+
+	GetAccount(ctx *gin.Context)
+	UpdateAccount(ctx *gin.Context)
+	ChangePassword(ctx *gin.Context)
+	ChangeEmail(ctx *gin.Context)
+	DeleteAccount(ctx *gin.Context)
+	RestoreAccount(ctx *gin.Context)
+	RequestExport(ctx *gin.Context)
+	GetExport(ctx *gin.Context)
+	DownloadExport(ctx *gin.Context)
+	GetSecurityEvents(ctx *gin.Context)
+
+	CreateOAuthClient(ctx *gin.Context)
+	GetOAuthClients(ctx *gin.Context)
+	GetAuthorization(ctx *gin.Context)
+	Authorize(ctx *gin.Context)
+	Token(ctx *gin.Context)
+	Introspect(ctx *gin.Context)
+	Revoke(ctx *gin.Context)
 
 	GetTasks(ctx *gin.Context)
 	GetTask(ctx *gin.Context)
 	AddTask(ctx *gin.Context)
+
+	GetUsers(ctx *gin.Context)
+	GetUser(ctx *gin.Context)
+	DisableUser(ctx *gin.Context)
+	EnableUser(ctx *gin.Context)
+	ForcePasswordReset(ctx *gin.Context)
+	UpdateUserRole(ctx *gin.Context)
+	GetUserTasks(ctx *gin.Context)
+	GetAuditLog(ctx *gin.Context)
+	GetSecurityLog(ctx *gin.Context)
 }
 
-// AppController holds the repo connection and auth service
+// AppController holds the repo connection, auth service, mailer, the external login provider and the app config
 type AppController struct {
 	appRepo repo.IAppRepo
 	auth    auth.IAuth
+	mailer  mailer.IMailer
+	hasher  password.IHasher
+	oidc    oidc.IProvider
+	config  config.Config
 }
 
-// NewAppController returns a new controller for the app
-func NewAppController(userRepo repo.IAppRepo, auth auth.IAuth) *AppController {
+// NewAppController returns a new controller for the app, the oidc provider is nil when the external login is disabled
+func NewAppController(userRepo repo.IAppRepo, auth auth.IAuth, mailer mailer.IMailer, hasher password.IHasher, oidcProvider oidc.IProvider, cfg config.Config) *AppController {
 	return &AppController{
 		appRepo: userRepo,
 		auth:    auth,
+		mailer:  mailer,
+		hasher:  hasher,
+		oidc:    oidcProvider,
+		config:  cfg,
 	}
 }
@@ -2,12 +2,11 @@ package controller
 
 import (
 	"fmt"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/bcrypt"
 	"gos/app/auth"
 	"gos/app/models"
+	"gos/app/repo"
 	"net/http"
 	"time"
 )
@@ -40,52 +39,105 @@ import (
 func (c *AppController) Login(ctx *gin.Context) {
 	request := new(models.LoginRequest)
 	if err := ctx.BindJSON(request); err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", err))
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
 		return
 	}
 
-	user, err := c.appRepo.GetUserByEmail(ctx, request.Email)
-	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("email or password is incorrect", err))
+	scopes := request.Scopes
+	if len(scopes) == 0 {
+		scopes = auth.AllScopes
+	}
+
+	if err := auth.ValidateScopes(scopes); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	user, err := c.appRepo.GetUserByEmail(ctx.Request.Context(), request.Email)
+	if err != nil && !isNotFound(err) {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to login", err)
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password))
 	if err != nil {
-		user.FailedLoginAttempt++
-		_, err := c.appRepo.UpdateUser(ctx, *user)
-		if err != nil {
-			fmt.Println(errors.Wrap(err, "failed to update FailedLoginAttempt"))
+		email := request.Email
+		if len(email) > maxEmailLength {
+			email = email[:maxEmailLength]
 		}
 
+		c.securityEvent(ctx, 0, eventLoginFailure, "unknown email "+email)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("email or password is incorrect", errors.New("email or password is incorrect")))
+		return
+	}
+
+	if c.loginLocked(ctx, user) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("email or password is incorrect", errors.New("email or password is incorrect")))
+		return
+	}
+
+	ok, rehash, err := c.hasher.Verify(user.Password, request.Password)
+	if err != nil || !ok {
+		c.loginFailed(ctx, user, "wrong password")
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("email or password is incorrect", errors.New("email or password is incorrect")))
 		return
 	}
 
-	expiresAt := time.Now().UTC().Add(auth.AccessTokenExpirationMinutes * time.Minute).Unix()
-	claims := &auth.Claims{
-		UserId: user.UserId,
-		Email:  user.Email,
-		Name:   user.Name,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expiresAt,
-		},
+	if rehash {
+		c.rehashPassword(ctx, user, request.Password)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(c.auth.GetJWTKey())
+	c.completeLogin(ctx, user, scopes, loginOptions{UseCookie: request.UseCookie, DeviceName: request.DeviceName, Method: "password"})
+}
+
+// rehashPassword replaces a hash made by an older algorithm or with outdated params while the password is known,
+// a failure is only logged and the old hash keeps working
+func (c *AppController) rehashPassword(ctx *gin.Context, user *models.User, plainPassword string) {
+	hash, err := c.hasher.Hash(plainPassword)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("email or password is incorrect", err))
+		fmt.Println(errors.Wrap(err, "failed to rehash password"))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, &models.Response{
-		Message: "successfully logged in user",
-		Data: models.LoginResponse{
-			Token:     tokenString,
-			ExpiresAt: expiresAt,
-		},
-	})
+	// only the hash that was verified is replaced, a password changed meanwhile is kept
+	result, err := c.appRepo.RehashPassword(ctx.Request.Context(), user.UserId, user.Password, hash)
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to rehash password"))
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+		user.Password = hash
+	}
+}
+
+// completeLogin returns an access token for a user who passed the first login step,
+// or the mfa challenge when 2fa is enabled, the login options are asked again at the second step
+func (c *AppController) completeLogin(ctx *gin.Context, user *models.User, scopes []string, options loginOptions) {
+	if user.Disabled {
+		c.securityEvent(ctx, user.UserId, eventLoginFailure, "account is disabled")
+		ctx.AbortWithStatusJSON(http.StatusForbidden, getErrorResponse("failed to login", errors.New("account is disabled")))
+		return
+	}
+
+	if user.TOTPEnabled {
+		mfaToken, expiresAt, err := c.auth.GenerateMFAChallengeToken(*user, scopes, c.config.MFAChallengeTTL)
+		if err != nil {
+			abortWithError(ctx, http.StatusInternalServerError, "failed to login", err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, &models.Response{
+			Message: "2fa is enabled, complete the login with a code",
+			Data: models.LoginResponse{
+				MFARequired: true,
+				MFAToken:    mfaToken,
+				ExpiresAt:   expiresAt,
+			},
+		})
+		return
+	}
+
+	c.respondWithAccessToken(ctx, *user, scopes, options)
 }
 
 // swagger:operation POST /api/aut/register Register
@@ -106,7 +158,11 @@ func (c *AppController) Login(ctx *gin.Context) {
 //    schema:
 //     $ref: '#/definitions/Response'
 //  '400':
-//    description: invalid request
+//    description: invalid request or the password doesn't meet the policy
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '409':
+//    description: the email is used by another account
 //    schema:
 //     $ref: '#/definitions/Response'
 //  '500':
@@ -116,26 +172,34 @@ func (c *AppController) Login(ctx *gin.Context) {
 func (c *AppController) Register(ctx *gin.Context) {
 	request := new(models.RegisterRequest)
 	if err := ctx.BindJSON(request); err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", err))
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
 		return
 	}
 
-	found, err := c.appRepo.GetUserByEmail(ctx, request.Email)
+	found, err := c.appRepo.GetUserByEmail(ctx.Request.Context(), request.Email)
 
 	if err == nil && found != nil {
-		ctx.AbortWithStatusJSON(http.StatusConflict, getErrorResponse(fmt.Sprintf("user with email [%s] exists", request.Email), err))
+		abortWithError(ctx, http.StatusConflict, fmt.Sprintf("user with email [%s] exists", request.Email), err)
+		return
+	}
+
+	if err != nil && !isNotFound(err) {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to register user", err)
 		return
 	}
 
 	now := time.Now().Unix()
 	user := models.User{
-		Name:               request.Name,
-		Email:              request.Email,
-		Password:           request.Password,
-		DateUpdated:        now,
-		DateCreated:        now,
-		FailedLoginAttempt: 0,
-		LastLogin:          0,
+		Name:                 request.Name,
+		Email:                request.Email,
+		Password:             request.Password,
+		DateUpdated:          now,
+		DateCreated:          now,
+		FailedLoginAttempt:   0,
+		LastLogin:            0,
+		EmailVerified:        false,
+		DateVerificationSent: now,
+		Role:                 auth.RoleUser,
 	}
 
 	if len(user.Email) == 0 {
@@ -143,31 +207,87 @@ func (c *AppController) Register(ctx *gin.Context) {
 		return
 	}
 
-	if len(user.Password) == 0 {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field password is required")))
+	if len(user.Name) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field name is required")))
 		return
 	}
 
-	if len(user.Name) == 0 {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field name is required")))
+	if !c.checkPasswordPolicy(ctx, user.Password, user.Email) {
 		return
 	}
 
-	bytesPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
+	user.Password, err = c.hasher.Hash(user.Password)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to register user", err))
+		abortWithError(ctx, http.StatusInternalServerError, "failed to register user", err)
 		return
 	}
 
-	user.Password = string(bytesPassword)
+	// the unique key of the emails settles two registrations of the same email racing past the check above
+	result, err := c.appRepo.AddUser(ctx.Request.Context(), user)
+	if isCause(err, repo.ErrDuplicate) {
+		ctx.AbortWithStatusJSON(http.StatusConflict, getErrorResponse(fmt.Sprintf("user with email [%s] exists", request.Email), nil))
+		return
+	}
 
-	_, err = c.appRepo.AddUser(ctx, user)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to register user", err))
+		abortWithError(ctx, http.StatusInternalServerError, "failed to register user", err)
 		return
 	}
 
+	user.UserId, err = result.LastInsertId()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to register user", err)
+		return
+	}
+
+	c.securityEvent(ctx, user.UserId, eventRegistration, "")
+
+	err = c.sendVerificationEmail(ctx, user)
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to send verification email"))
+	}
+
 	ctx.JSON(http.StatusCreated, &models.Response{
-		Message: "successfully registered user",
+		Message: "successfully registered user, a verification link has been sent to the email",
+	})
+}
+
+// swagger:operation POST /api/auth/logout Logout
+//
+// Logout revokes the session of the access token and clears the session cookies of the cookie mode
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token, the session cookie is used when missing
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) Logout(ctx *gin.Context) {
+	accessToken := auth.RequestAccessToken(ctx)
+	if len(accessToken) == 0 {
+		accessToken, _ = ctx.Cookie(auth.SessionCookie)
+	}
+
+	// an invalid or expired token has nothing left to revoke, the logout still succeeds
+	if claims, err := c.auth.ParseAccessToken(accessToken); err == nil && claims.SessionId != 0 {
+		_, err = c.appRepo.RevokeSession(ctx.Request.Context(), claims.SessionId, claims.UserId, time.Now().Unix())
+		if err != nil {
+			abortWithError(ctx, http.StatusInternalServerError, "failed to logout", err)
+			return
+		}
+
+		c.securityEvent(ctx, claims.UserId, eventLogout, fmt.Sprintf("session=%d", claims.SessionId))
+	}
+
+	c.clearSessionCookies(ctx)
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully logged out",
 	})
 }
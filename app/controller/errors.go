@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gos/app/repo"
+	"net/http"
+)
+
+// StatusClientClosedRequest is the status of the requests whose client went away before the answer, as nginx logs them
+const StatusClientClosedRequest = 499
+
+// CanceledStatus returns the status of an error caused by a db call canceled with its request:
+// 503 when the db timeout of the request passed and 499 when the client went away, false for any other error.
+// The errors wrapped by github.com/pkg/errors are followed through their cause.
+func CanceledStatus(err error) (int, bool) {
+	if !isCause(err, repo.ErrCanceled) {
+		return 0, false
+	}
+
+	if isCause(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable, true
+	}
+
+	return StatusClientClosedRequest, true
+}
+
+// ErrorStatus maps the kinds of the errors of the repo and the services to their status: 404 when not found,
+// 409 on a conflict like a duplicate email, 400 for an invalid value, 503 when the database is unavailable,
+// and the status of CanceledStatus. False for an error of no kind, its status is up to the caller.
+func ErrorStatus(err error) (int, bool) {
+	if status, ok := FailureStatus(err); ok {
+		return status, true
+	}
+
+	switch {
+	case isCause(err, repo.ErrNotFound):
+		return http.StatusNotFound, true
+	case isCause(err, repo.ErrConflict):
+		return http.StatusConflict, true
+	case isCause(err, repo.ErrValidation):
+		return http.StatusBadRequest, true
+	default:
+		return 0, false
+	}
+}
+
+// FailureStatus returns the status of a failure of the infrastructure, the database or a canceled db call,
+// the request may succeed later. False for any other error.
+func FailureStatus(err error) (int, bool) {
+	if status, ok := CanceledStatus(err); ok {
+		return status, true
+	}
+
+	if isCause(err, repo.ErrUnavailable) {
+		return http.StatusServiceUnavailable, true
+	}
+
+	return 0, false
+}
+
+// isNotFound tells whether err is the error of a row which doesn't exist
+func isNotFound(err error) bool {
+	return isCause(err, repo.ErrNotFound)
+}
+
+// isCause is errors.Is following the causes of github.com/pkg/errors too, its old wrappers don't unwrap
+func isCause(err error, target error) bool {
+	for err != nil {
+		if errors.Is(err, target) {
+			return true
+		}
+
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+
+		err = causer.Cause()
+	}
+
+	return false
+}
+
+// serverErrorStatus is the status of a failure of the server, 500 unless the database failed or a db call was canceled
+func serverErrorStatus(err error) int {
+	if status, ok := FailureStatus(err); ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// abortWithError aborts the request with the error, the status of its kind wins over the given one, which is the
+// status of an error of no kind. A given 401 or 403 is only replaced by a failure of the infrastructure,
+// they must not tell whether a row exists.
+func abortWithError(ctx *gin.Context, status int, msg string, err error) {
+	if failure, ok := FailureStatus(err); ok {
+		status = failure
+	} else if kind, ok := ErrorStatus(err); ok && status != http.StatusUnauthorized && status != http.StatusForbidden {
+		status = kind
+	}
+
+	ctx.AbortWithStatusJSON(status, getErrorResponse(msg, err))
+}
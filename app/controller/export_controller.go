@@ -0,0 +1,435 @@
+package controller
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/models"
+	"gos/app/repo"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// exportTimeout is how long assembling an export may take, a job still pending after it was interrupted
+const exportTimeout = 15 * time.Minute
+
+// exportPageSize is how many rows are read at once while assembling an export
+const exportPageSize = 500
+
+// swagger:operation POST /api/secured/me/export RequestExport
+//
+// RequestExport starts assembling everything stored about the logged in user into a zip of json files,
+// the job is polled until it is done, a pending job is returned instead of starting another one
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// responses:
+//  '202':
+//    description: the export job
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '409':
+//    description: the pending export finished meanwhile, try again
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) RequestExport(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+
+	job := models.ExportJob{
+		UserId:      user.UserId,
+		Status:      models.ExportStatusPending,
+		DateCreated: time.Now().Unix(),
+	}
+
+	// the schema allows a single pending job per user, of concurrent requests one inserts and the others find its job
+	result, err := c.appRepo.AddExportJob(ctx.Request.Context(), job)
+	if isCause(err, repo.ErrDuplicate) {
+		c.respondWithPendingExport(ctx, user.UserId)
+		return
+	}
+
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to start export", err)
+		return
+	}
+
+	job.JobId, err = result.LastInsertId()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to start export", err)
+		return
+	}
+
+	go c.runExport(job)
+
+	c.respondWithExportJob(ctx, http.StatusAccepted, "successfully started export", job)
+}
+
+// respondWithPendingExport answers with the pending job of the user which kept a new one from starting
+func (c *AppController) respondWithPendingExport(ctx *gin.Context, userId int64) {
+	jobs, err := c.appRepo.GetExportJobs(ctx.Request.Context(), userId)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to start export", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if job.Status == models.ExportStatusPending {
+			c.respondWithExportJob(ctx, http.StatusAccepted, "an export is already being assembled", job)
+			return
+		}
+	}
+
+	// the pending job finished meanwhile
+	ctx.AbortWithStatusJSON(http.StatusConflict, getErrorResponse("failed to start export", errors.New("an export just finished, try again")))
+}
+
+// swagger:operation GET /api/secured/me/export/:jobId GetExport
+//
+// GetExport gets an export job of the logged in user, a done job has a short-lived download link
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: jobId
+//   in: path
+//   description: the job id
+//   type: integer
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: export job not found
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetExport(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+
+	jobId, err := strconv.ParseInt(ctx.Param("jobId"), 10, 64)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	job, err := c.appRepo.GetExportJob(ctx.Request.Context(), jobId, user.UserId)
+	if err != nil {
+		abortWithError(ctx, http.StatusNotFound, "export job not found", err)
+		return
+	}
+
+	c.respondWithExportJob(ctx, http.StatusOK, "successfully retrieved export job", *job)
+}
+
+// swagger:operation GET /api/exports/download DownloadExport
+//
+// DownloadExport downloads the zip of an export with the token of a download link
+// ---
+// produces:
+// - application/zip
+// - application/json
+// parameters:
+// - name: token
+//   in: query
+//   description: the download token
+//   type: string
+// responses:
+//  '200':
+//    description: the zip file
+//  '400':
+//    description: the link is invalid or expired
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '410':
+//    description: the export expired
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) DownloadExport(ctx *gin.Context) {
+	claims, err := c.auth.ParseExportDownloadToken(ctx.Query("token"))
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "failed to download export", err)
+		return
+	}
+
+	job, err := c.appRepo.GetExportJob(ctx.Request.Context(), claims.JobId, claims.UserId)
+	if err != nil || job.Status != models.ExportStatusDone || job.ExpiresAt <= time.Now().Unix() {
+		ctx.AbortWithStatusJSON(http.StatusGone, getErrorResponse("failed to download export", errors.New("the export expired")))
+		return
+	}
+
+	path := c.exportPath(job.JobId)
+	if _, err := os.Stat(path); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusGone, getErrorResponse("failed to download export", errors.New("the export expired")))
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.FileAttachment(path, fmt.Sprintf("gos-export-%d.zip", job.JobId))
+}
+
+// PurgeExpiredExports deletes the exports whose file expired, the failed jobs older than the export ttl,
+// and fails the jobs interrupted by a restart, the number of deleted jobs is returned
+func (c *AppController) PurgeExpiredExports(ctx context.Context, now int64) (int, error) {
+	pendingBefore := now - int64(exportTimeout.Seconds())
+	failedBefore := now - int64(c.config.Export.TTL.Seconds())
+
+	jobs, err := c.appRepo.GetStaleExportJobs(ctx, now, pendingBefore, failedBefore, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, job := range jobs {
+		if job.Status == models.ExportStatusPending {
+			c.failExport(ctx, job, errors.New("the export was interrupted"))
+			continue
+		}
+
+		if err := c.removeExportFile(job.JobId); err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		if _, err := c.appRepo.DeleteExportJob(ctx, job.JobId); err != nil {
+			fmt.Println(errors.Wrapf(err, "failed to delete export job %d", job.JobId))
+			continue
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// respondWithExportJob adds the download link to a done job
+func (c *AppController) respondWithExportJob(ctx *gin.Context, status int, msg string, job models.ExportJob) {
+	now := time.Now()
+	if job.Status == models.ExportStatusDone && job.ExpiresAt > now.Unix() {
+		expiresAt := now.Add(c.config.Export.LinkTTL).Unix()
+		if expiresAt > job.ExpiresAt {
+			expiresAt = job.ExpiresAt
+		}
+
+		token, err := c.auth.GenerateExportDownloadToken(job, expiresAt)
+		if err != nil {
+			abortWithError(ctx, http.StatusInternalServerError, "failed to create the download link", err)
+			return
+		}
+
+		job.DownloadURL = tokenLink(c.config.Export.DownloadURL, token)
+	}
+
+	ctx.JSON(status, &models.Response{
+		Message: msg,
+		Data:    job,
+	})
+}
+
+// runExport assembles the export in the background and records the outcome on the job
+func (c *AppController) runExport(job models.ExportJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	if err := c.writeExport(ctx, job); err != nil {
+		c.failExport(ctx, job, err)
+		return
+	}
+
+	now := time.Now()
+	job.Status = models.ExportStatusDone
+	job.DateCompleted = now.Unix()
+	job.ExpiresAt = now.Add(c.config.Export.TTL).Unix()
+
+	if _, err := c.appRepo.UpdateExportJob(ctx, job); err != nil {
+		fmt.Println(errors.Wrapf(err, "failed to complete export job %d", job.JobId))
+	}
+}
+
+func (c *AppController) failExport(ctx context.Context, job models.ExportJob, cause error) {
+	fmt.Println(errors.Wrapf(cause, "export job %d failed", job.JobId))
+
+	job.Status = models.ExportStatusFailed
+	// the cause stays in the logs, it could reveal internals
+	job.Error = "failed to assemble the export"
+	job.DateCompleted = time.Now().Unix()
+
+	if _, err := c.appRepo.UpdateExportJob(ctx, job); err != nil {
+		fmt.Println(errors.Wrapf(err, "failed to update export job %d", job.JobId))
+	}
+}
+
+// writeExport writes the zip next to its final path and renames it once complete,
+// so a download never sees a partial file
+func (c *AppController) writeExport(ctx context.Context, job models.ExportJob) error {
+	path := c.exportPath(job.JobId)
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the export file")
+	}
+
+	err = c.writeExportZip(ctx, zip.NewWriter(file), job.UserId)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// writeExportZip writes a json file per kind of record, secrets like the password hash and the token hashes are left out
+func (c *AppController) writeExportZip(ctx context.Context, w *zip.Writer, userId int64) error {
+	user, err := c.appRepo.GetUserById(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	tasks := make([]models.Task, 0)
+	for lastTaskId := int64(0); ; {
+		page, err := c.appRepo.GetTasksByUser(ctx, userId, lastTaskId, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		tasks = append(tasks, page...)
+		if len(page) < exportPageSize {
+			break
+		}
+		lastTaskId = page[len(page)-1].TaskId
+	}
+
+	sessions, err := c.appRepo.GetUserSessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	accessTokens, err := c.appRepo.GetAccessTokens(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	oauthClients, err := c.appRepo.GetOAuthClients(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	identities, err := c.appRepo.GetUserIdentities(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	auditEntries := make([]models.AuditEntry, 0)
+	for lastAuditId := int64(0); ; {
+		page, err := c.appRepo.GetAuditEntries(ctx, userId, lastAuditId, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		auditEntries = append(auditEntries, page...)
+		if len(page) < exportPageSize {
+			break
+		}
+		lastAuditId = page[len(page)-1].AuditId
+	}
+
+	securityEvents := make([]models.SecurityEvent, 0)
+	for lastEventId := int64(0); ; {
+		page, err := c.appRepo.GetSecurityEvents(ctx, userId, "", lastEventId, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		securityEvents = append(securityEvents, page...)
+		if len(page) < exportPageSize {
+			break
+		}
+		lastEventId = page[len(page)-1].EventId
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"tasks.json", tasks},
+		{"sessions.json", sessions},
+		{"access_tokens.json", accessTokens},
+		{"oauth_clients.json", oauthClients},
+		{"external_identities.json", identities},
+		{"audit_log.json", auditEntries},
+		{"security_events.json", securityEvents},
+	}
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(fw)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(f.data); err != nil {
+			return errors.Wrapf(err, "failed to write %s", f.name)
+		}
+	}
+
+	return w.Close()
+}
+
+// removeExportFiles deletes the export files of the user, it runs before the account is deleted
+func (c *AppController) removeExportFiles(ctx context.Context, userId int64) error {
+	jobs, err := c.appRepo.GetExportJobs(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := c.removeExportFile(job.JobId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *AppController) removeExportFile(jobId int64) error {
+	err := os.Remove(c.exportPath(jobId))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove export file of job %d", jobId)
+	}
+
+	return nil
+}
+
+func (c *AppController) exportPath(jobId int64) string {
+	return filepath.Join(c.config.Export.Dir, fmt.Sprintf("export-%d.zip", jobId))
+}
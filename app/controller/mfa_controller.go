@@ -0,0 +1,407 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/auth"
+	"gos/app/models"
+	"gos/app/repo"
+	"gos/app/totp"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// recoveryCodeCount is the number of recovery codes generated when 2fa is enabled
+const recoveryCodeCount = 10
+
+// totpSkew is the number of time steps accepted before and after the current one
+const totpSkew = 1
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// swagger:operation POST /api/auth/login/2fa LoginMFA
+//
+// LoginMFA completes the login of a user with 2fa enabled using the mfa token returned by Login
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: body
+//   in: body
+//   description: the mfa login obj
+//   schema:
+//    $ref: '#/definitions/LoginMFARequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) LoginMFA(ctx *gin.Context) {
+	request := new(models.LoginMFARequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	claims, err := c.auth.ParseMFAChallengeToken(request.MFAToken)
+	if err != nil {
+		abortWithError(ctx, http.StatusUnauthorized, "failed to login", err)
+		return
+	}
+
+	user, err := c.appRepo.GetUserById(ctx.Request.Context(), claims.UserId)
+	if err != nil || user.TokenVersion != claims.TokenVersion || !user.TOTPEnabled || user.Disabled {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("failed to login", errors.New("mfa token is invalid")))
+		return
+	}
+
+	if c.loginLocked(ctx, user) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("failed to login", errors.New("the code is invalid")))
+		return
+	}
+
+	err = c.verifySecondFactor(ctx.Request.Context(), user, request.Code, request.RecoveryCode)
+	if err != nil {
+		c.loginFailed(ctx, user, "wrong 2fa code")
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("failed to login", errors.New("the code is invalid")))
+		return
+	}
+
+	c.respondWithAccessToken(ctx, *user, auth.SplitScopes(claims.Scope), loginOptions{UseCookie: request.UseCookie, DeviceName: request.DeviceName, Method: "2fa"})
+}
+
+// swagger:operation POST /api/secured/2fa/enroll EnrollTOTP
+//
+// EnrollTOTP starts the 2fa enrollment, the returned secret is pending until it is confirmed with a code
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '409':
+//    description: 2fa is already enabled
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) EnrollTOTP(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+	if user.TOTPEnabled {
+		ctx.AbortWithStatusJSON(http.StatusConflict, getErrorResponse("failed to enroll 2fa", errors.New("2fa is already enabled")))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to enroll 2fa", err)
+		return
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	user.DateUpdated = time.Now().Unix()
+
+	_, err = c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to enroll 2fa", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "confirm the enrollment with a code from the authenticator app",
+		Data: models.EnrollTOTPResponse{
+			Secret: secret,
+			URI:    totp.URI(c.config.TOTPIssuer, user.Email, secret),
+		},
+	})
+}
+
+// swagger:operation POST /api/secured/2fa/confirm ConfirmTOTP
+//
+// ConfirmTOTP enables 2fa with the first code of the enrolled secret and returns the recovery codes
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: body
+//   in: body
+//   description: the confirm obj
+//   schema:
+//    $ref: '#/definitions/ConfirmTOTPRequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '409':
+//    description: 2fa is already enabled
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) ConfirmTOTP(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+	request := new(models.ConfirmTOTPRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if user.TOTPEnabled {
+		ctx.AbortWithStatusJSON(http.StatusConflict, getErrorResponse("failed to confirm 2fa", errors.New("2fa is already enabled")))
+		return
+	}
+
+	if len(user.TOTPSecret) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to confirm 2fa", errors.New("2fa enrollment is not started")))
+		return
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, request.Code, time.Now(), totpSkew)
+	if !ok {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to confirm 2fa", errors.New("the code is invalid")))
+		return
+	}
+
+	codes, err := c.replaceRecoveryCodes(ctx.Request.Context(), user.UserId)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to confirm 2fa", err)
+		return
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.DateUpdated = time.Now().Unix()
+
+	_, err = c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to confirm 2fa", err)
+		return
+	}
+
+	c.securityEvent(ctx, user.UserId, eventMFAEnabled, "")
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully enabled 2fa, store the recovery codes safely, they are shown only once",
+		Data: models.RecoveryCodesResponse{
+			RecoveryCodes: codes,
+		},
+	})
+}
+
+// swagger:operation POST /api/secured/2fa/disable DisableTOTP
+//
+// DisableTOTP disables 2fa, a code or a recovery code is required with the password, an account without a password
+// confirms it with the code alone
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: body
+//   in: body
+//   description: the disable obj
+//   schema:
+//    $ref: '#/definitions/DisableTOTPRequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) DisableTOTP(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+	request := new(models.DisableTOTPRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to disable 2fa", errors.New("2fa is not enabled")))
+		return
+	}
+
+	if len(request.Code) == 0 && len(request.RecoveryCode) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field code or recoveryCode is required")))
+		return
+	}
+
+	// an account without a password is confirmed by the code itself, which can't be used twice
+	if !c.reauthenticate(ctx, user, request.Password, request.Code, request.RecoveryCode, "failed to disable 2fa") {
+		return
+	}
+
+	if len(user.Password) > 0 {
+		if err := c.verifySecondFactor(ctx.Request.Context(), user, request.Code, request.RecoveryCode); err != nil {
+			c.loginFailed(ctx, user, "wrong 2fa code")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("failed to disable 2fa", errors.New("the code is invalid")))
+			return
+		}
+	}
+
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	user.DateUpdated = time.Now().Unix()
+
+	_, err := c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to disable 2fa", err)
+		return
+	}
+
+	_, err = c.appRepo.DeleteRecoveryCodes(ctx.Request.Context(), user.UserId)
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to delete recovery codes"))
+	}
+
+	c.securityEvent(ctx, user.UserId, eventMFADisabled, "")
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully disabled 2fa",
+	})
+}
+
+// verifySecondFactor checks a totp code or, when no code is given, a recovery code.
+// Used totp steps and recovery codes are recorded so they can't be replayed.
+func (c *AppController) verifySecondFactor(ctx context.Context, user *models.User, code string, recoveryCode string) error {
+	now := time.Now()
+
+	if len(code) > 0 {
+		step, ok := totp.Validate(user.TOTPSecret, code, now, totpSkew)
+		if !ok || step <= user.TOTPLastStep {
+			return errors.New("the code is invalid")
+		}
+
+		// the step is only recorded when no later one was, a code replayed by a concurrent request fails here
+		result, err := c.appRepo.UseTOTPStep(ctx, user.UserId, step)
+		if err != nil {
+			return err
+		}
+
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return errors.New("the code is invalid")
+		}
+
+		user.TOTPLastStep = step
+		return nil
+	}
+
+	if len(recoveryCode) > 0 {
+		result, err := c.appRepo.UseRecoveryCode(ctx, user.UserId, auth.HashToken(normalizeRecoveryCode(recoveryCode)), now.Unix())
+		if err != nil {
+			return err
+		}
+
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return errors.New("the recovery code is invalid")
+		}
+
+		return nil
+	}
+
+	return errors.New("a code or a recovery code is required")
+}
+
+// replaceRecoveryCodes deletes the existing recovery codes of the user and stores new ones, the plain codes are returned
+func (c *AppController) replaceRecoveryCodes(ctx context.Context, userId int64) ([]string, error) {
+	var codes []string
+	// in one transaction, so a failure keeps the existing codes
+	err := c.appRepo.WithTx(ctx, func(tx repo.IAppRepo) error {
+		_, err := tx.DeleteRecoveryCodes(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().Unix()
+		codes = make([]string, 0, recoveryCodeCount)
+		for i := 0; i < recoveryCodeCount; i++ {
+			b := make([]byte, 6)
+			if _, err := rand.Read(b); err != nil {
+				return errors.Wrap(err, "failed to generate recovery code")
+			}
+
+			encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+			code := encoded[:5] + "-" + encoded[5:]
+
+			_, err = tx.AddRecoveryCode(ctx, models.RecoveryCode{
+				UserId:      userId,
+				CodeHash:    auth.HashToken(normalizeRecoveryCode(code)),
+				DateCreated: now,
+			})
+			if err != nil {
+				return err
+			}
+
+			codes = append(codes, code)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.Replace(code, "-", "", -1)
+}
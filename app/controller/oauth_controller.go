@@ -0,0 +1,711 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/auth"
+	"gos/app/models"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// oauth error codes as defined by RFC 6749
+const (
+	oauthInvalidRequest       = "invalid_request"
+	oauthInvalidClient        = "invalid_client"
+	oauthInvalidGrant         = "invalid_grant"
+	oauthInvalidScope         = "invalid_scope"
+	oauthUnauthorizedClient   = "unauthorized_client"
+	oauthUnsupportedGrantType = "unsupported_grant_type"
+	oauthServerError          = "server_error"
+	oauthAccessDenied         = "access_denied"
+)
+
+// swagger:operation POST /api/secured/oauth/clients CreateOAuthClient
+//
+// CreateOAuthClient registers an oauth client owned by the logged in user, the secret of confidential clients is shown only once
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: body
+//   in: body
+//   description: the client to be registered
+//   schema:
+//    $ref: '#/definitions/CreateOAuthClientRequest'
+// responses:
+//  '201':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) CreateOAuthClient(ctx *gin.Context) {
+	claims := ctx.MustGet("claims")
+	claimsObj := claims.(*auth.Claims)
+	request := new(models.CreateOAuthClientRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if len(request.Name) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field name is required")))
+		return
+	}
+
+	if len(request.RedirectURIs) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field redirectUris is required")))
+		return
+	}
+
+	for _, redirectURI := range request.RedirectURIs {
+		u, err := url.Parse(redirectURI)
+		if err != nil || !u.IsAbs() || len(u.Fragment) > 0 {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request",
+				fmt.Errorf("redirect uri [%s] must be absolute without a fragment", redirectURI)))
+			return
+		}
+	}
+
+	if len(request.Scopes) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field scopes is required")))
+		return
+	}
+
+	if err := auth.ValidateScopes(request.Scopes); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	clientId, err := auth.GenerateRandomToken()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to register client", err)
+		return
+	}
+
+	client := &models.OAuthClient{
+		ClientId:     clientId,
+		UserId:       claimsObj.UserId,
+		Name:         request.Name,
+		RedirectURIs: request.RedirectURIs,
+		Scopes:       request.Scopes,
+		Confidential: request.Confidential,
+		DateCreated:  time.Now().Unix(),
+	}
+
+	var clientSecret string
+	if client.Confidential {
+		clientSecret, err = auth.GenerateRandomToken()
+		if err != nil {
+			abortWithError(ctx, http.StatusInternalServerError, "failed to register client", err)
+			return
+		}
+
+		client.ClientSecretHash = auth.HashToken(clientSecret)
+	}
+
+	_, err = c.appRepo.AddOAuthClient(ctx.Request.Context(), *client)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to register client", err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, &models.Response{
+		Message: "successfully registered client",
+		Data: models.CreateOAuthClientResponse{
+			ClientSecret: clientSecret,
+			Client:       client,
+		},
+	})
+}
+
+// swagger:operation GET /api/secured/oauth/clients GetOAuthClients
+//
+// GetOAuthClients gets the oauth clients registered by the logged in user
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetOAuthClients(ctx *gin.Context) {
+	claims := ctx.MustGet("claims")
+	claimsObj := claims.(*auth.Claims)
+
+	clients, err := c.appRepo.GetOAuthClients(ctx.Request.Context(), claimsObj.UserId)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to get clients", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully retrieved %d client/s", len(clients)),
+		Data:    clients,
+	})
+}
+
+// swagger:operation GET /api/secured/oauth/authorize GetAuthorization
+//
+// GetAuthorization validates an authorization request and returns what the consent page shows to the user
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: response_type
+//   in: query
+//   description: must be code
+//   type: string
+// - name: client_id
+//   in: query
+//   description: the client id
+//   type: string
+// - name: redirect_uri
+//   in: query
+//   description: one of the registered redirect uris
+//   type: string
+// - name: scope
+//   in: query
+//   description: the space separated scopes, all the client scopes when empty
+//   type: string
+// - name: state
+//   in: query
+//   description: the client state returned with the redirect
+//   type: string
+// - name: code_challenge
+//   in: query
+//   description: the PKCE code challenge, required for public clients
+//   type: string
+// - name: code_challenge_method
+//   in: query
+//   description: must be S256
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: the requested scopes exceed the scopes of the current token
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetAuthorization(ctx *gin.Context) {
+	claims := ctx.MustGet("claims")
+	claimsObj := claims.(*auth.Claims)
+	request := new(models.AuthorizeRequest)
+	if err := ctx.BindQuery(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	client, scopes, err := c.validateAuthorizeRequest(ctx, request)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid authorization request", err)
+		return
+	}
+
+	if !c.tokenHoldsScopes(ctx, claimsObj, scopes) {
+		return
+	}
+
+	consent, err := c.appRepo.GetOAuthConsent(ctx.Request.Context(), claimsObj.UserId, client.ClientId)
+	alreadyApproved := err == nil && auth.IsSubset(scopes, consent.Scopes)
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "authorization request is valid",
+		Data: models.AuthorizeResponse{
+			Client:          client,
+			Scopes:          scopes,
+			AlreadyApproved: alreadyApproved,
+		},
+	})
+}
+
+// swagger:operation POST /api/secured/oauth/authorize Authorize
+//
+// Authorize records the decision of the logged in user and returns where to redirect the user agent,
+// with an authorization code when the request is approved
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: body
+//   in: body
+//   description: the authorization request and the decision
+//   schema:
+//    $ref: '#/definitions/AuthorizeRequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: the requested scopes exceed the scopes of the current token
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) Authorize(ctx *gin.Context) {
+	claims := ctx.MustGet("claims")
+	claimsObj := claims.(*auth.Claims)
+	request := new(models.AuthorizeRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	// errors about the client or the redirect uri are never redirected
+	client, scopes, err := c.validateAuthorizeRequest(ctx, request)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid authorization request", err)
+		return
+	}
+
+	if !c.tokenHoldsScopes(ctx, claimsObj, scopes) {
+		return
+	}
+
+	redirect, _ := url.Parse(request.RedirectURI)
+	query := redirect.Query()
+	if len(request.State) > 0 {
+		query.Set("state", request.State)
+	}
+
+	if !request.Approve {
+		query.Set("error", oauthAccessDenied)
+		redirect.RawQuery = query.Encode()
+		ctx.JSON(http.StatusOK, &models.Response{
+			Message: "authorization denied",
+			Data:    models.AuthorizeResponse{RedirectTo: redirect.String()},
+		})
+		return
+	}
+
+	now := time.Now().Unix()
+	consent := models.OAuthConsent{
+		UserId:      claimsObj.UserId,
+		ClientId:    client.ClientId,
+		Scopes:      scopes,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	if existing, err := c.appRepo.GetOAuthConsent(ctx.Request.Context(), claimsObj.UserId, client.ClientId); err == nil {
+		consent.DateCreated = existing.DateCreated
+		for _, scope := range existing.Scopes {
+			if !auth.IsSubset([]string{scope}, consent.Scopes) {
+				consent.Scopes = append(consent.Scopes, scope)
+			}
+		}
+	}
+
+	_, err = c.appRepo.SaveOAuthConsent(ctx.Request.Context(), consent)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to authorize client", err)
+		return
+	}
+
+	code, err := auth.GenerateRandomToken()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to authorize client", err)
+		return
+	}
+
+	_, err = c.appRepo.AddOAuthCode(ctx.Request.Context(), models.OAuthCode{
+		CodeHash:            auth.HashToken(code),
+		ClientId:            client.ClientId,
+		UserId:              claimsObj.UserId,
+		RedirectURI:         request.RedirectURI,
+		Scopes:              scopes,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		ExpiresAt:           now + int64(c.config.OAuthCodeTTL.Seconds()),
+	})
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to authorize client", err)
+		return
+	}
+
+	query.Set("code", code)
+	redirect.RawQuery = query.Encode()
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully authorized client",
+		Data:    models.AuthorizeResponse{RedirectTo: redirect.String()},
+	})
+}
+
+// swagger:operation POST /oauth/token Token
+//
+// Token is the oauth token endpoint supporting the authorization_code (with PKCE) and client_credentials grants
+// ---
+// consumes:
+// - application/x-www-form-urlencoded
+// produces:
+// - application/json
+// parameters:
+// - name: grant_type
+//   in: formData
+//   description: authorization_code or client_credentials
+//   type: string
+// - name: code
+//   in: formData
+//   description: the authorization code
+//   type: string
+// - name: redirect_uri
+//   in: formData
+//   description: the redirect uri used for the authorization request
+//   type: string
+// - name: code_verifier
+//   in: formData
+//   description: the PKCE code verifier
+//   type: string
+// - name: scope
+//   in: formData
+//   description: the space separated scopes for client_credentials
+//   type: string
+// - name: client_id
+//   in: formData
+//   description: the client id, when http basic auth isn't used
+//   type: string
+// - name: client_secret
+//   in: formData
+//   description: the client secret, when http basic auth isn't used
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/OAuthTokenResponse'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/OAuthErrorResponse'
+//  '401':
+//    description: client authentication failed
+//    schema:
+//     $ref: '#/definitions/OAuthErrorResponse'
+func (c *AppController) Token(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	client, err := c.authenticateOAuthClient(ctx)
+	if err != nil {
+		ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getOAuthErrorResponse(oauthInvalidClient, err))
+		return
+	}
+
+	switch ctx.PostForm("grant_type") {
+	case "authorization_code":
+		c.authorizationCodeGrant(ctx, client)
+	case "client_credentials":
+		c.clientCredentialsGrant(ctx, client)
+	default:
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getOAuthErrorResponse(oauthUnsupportedGrantType,
+			errors.New("grant_type must be authorization_code or client_credentials")))
+	}
+}
+
+// swagger:operation POST /oauth/introspect Introspect
+//
+// Introspect returns the state of a token as defined by RFC 7662, only confidential clients may call it
+// ---
+// consumes:
+// - application/x-www-form-urlencoded
+// produces:
+// - application/json
+// parameters:
+// - name: token
+//   in: formData
+//   description: the token to introspect
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/IntrospectionResponse'
+//  '401':
+//    description: client authentication failed
+//    schema:
+//     $ref: '#/definitions/OAuthErrorResponse'
+func (c *AppController) Introspect(ctx *gin.Context) {
+	client, err := c.authenticateOAuthClient(ctx)
+	if err == nil && !client.Confidential {
+		err = errors.New("only confidential clients may introspect tokens")
+	}
+
+	if err != nil {
+		ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getOAuthErrorResponse(oauthInvalidClient, err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.auth.IntrospectToken(ctx.Request.Context(), ctx.PostForm("token")))
+}
+
+// swagger:operation POST /oauth/revoke Revoke
+//
+// Revoke revokes an access token issued to the client as defined by RFC 7009
+// ---
+// consumes:
+// - application/x-www-form-urlencoded
+// produces:
+// - application/json
+// parameters:
+// - name: token
+//   in: formData
+//   description: the token to revoke
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//  '401':
+//    description: client authentication failed
+//    schema:
+//     $ref: '#/definitions/OAuthErrorResponse'
+func (c *AppController) Revoke(ctx *gin.Context) {
+	client, err := c.authenticateOAuthClient(ctx)
+	if err != nil {
+		ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getOAuthErrorResponse(oauthInvalidClient, err))
+		return
+	}
+
+	err = c.auth.RevokeToken(ctx.Request.Context(), client, ctx.PostForm("token"))
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, getOAuthErrorResponse(oauthServerError, err))
+		return
+	}
+
+	if claims, err := c.auth.ParseAccessToken(ctx.PostForm("token")); err == nil && claims.ClientId == client.ClientId {
+		c.securityEvent(ctx, claims.UserId, eventTokenRevoked, "oauth client="+client.ClientId)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{})
+}
+
+func (c *AppController) authorizationCodeGrant(ctx *gin.Context, client *models.OAuthClient) {
+	code := ctx.PostForm("code")
+	if len(code) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getOAuthErrorResponse(oauthInvalidRequest, errors.New("code is required")))
+		return
+	}
+
+	invalidCode := errors.New("the code is invalid, expired or was issued to another client")
+	now := time.Now().Unix()
+
+	authCode, err := c.appRepo.GetOAuthCodeByCodeHash(ctx.Request.Context(), auth.HashToken(code))
+	if err != nil || authCode.ClientId != client.ClientId || authCode.DateUsed != 0 || authCode.ExpiresAt < now {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getOAuthErrorResponse(oauthInvalidGrant, invalidCode))
+		return
+	}
+
+	if authCode.RedirectURI != ctx.PostForm("redirect_uri") {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getOAuthErrorResponse(oauthInvalidGrant, errors.New("redirect_uri doesn't match")))
+		return
+	}
+
+	if len(authCode.CodeChallenge) > 0 && !auth.VerifyPKCE(ctx.PostForm("code_verifier"), authCode.CodeChallenge, authCode.CodeChallengeMethod) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getOAuthErrorResponse(oauthInvalidGrant, errors.New("code_verifier is invalid")))
+		return
+	}
+
+	result, err := c.appRepo.UseOAuthCode(ctx.Request.Context(), authCode.CodeHash, now)
+	if err != nil {
+		ctx.AbortWithStatusJSON(serverErrorStatus(err), getOAuthErrorResponse(oauthServerError, err))
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getOAuthErrorResponse(oauthInvalidGrant, invalidCode))
+		return
+	}
+
+	user, err := c.appRepo.GetUserById(ctx.Request.Context(), authCode.UserId)
+	if err != nil || user.Disabled {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getOAuthErrorResponse(oauthInvalidGrant, invalidCode))
+		return
+	}
+
+	c.issueOAuthToken(ctx, user, client, authCode.Scopes)
+}
+
+func (c *AppController) clientCredentialsGrant(ctx *gin.Context, client *models.OAuthClient) {
+	if !client.Confidential {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getOAuthErrorResponse(oauthUnauthorizedClient,
+			errors.New("only confidential clients may use client_credentials")))
+		return
+	}
+
+	scopes := auth.SplitScopes(ctx.PostForm("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	if !auth.IsSubset(scopes, client.Scopes) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getOAuthErrorResponse(oauthInvalidScope,
+			errors.New("the requested scopes exceed the scopes of the client")))
+		return
+	}
+
+	c.issueOAuthToken(ctx, nil, client, scopes)
+}
+
+func (c *AppController) issueOAuthToken(ctx *gin.Context, user *models.User, client *models.OAuthClient, scopes []string) {
+	tokenString, _, err := c.auth.GenerateOAuthAccessToken(user, client.ClientId, scopes, c.config.OAuthAccessTokenTTL)
+	if err != nil {
+		ctx.AbortWithStatusJSON(serverErrorStatus(err), getOAuthErrorResponse(oauthServerError, err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &models.OAuthTokenResponse{
+		AccessToken: tokenString,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(c.config.OAuthAccessTokenTTL.Seconds()),
+		Scope:       auth.JoinScopes(scopes),
+	})
+}
+
+// validateAuthorizeRequest checks the client, the redirect uri, the scopes and PKCE,
+// the requested scopes or all the client scopes are returned
+func (c *AppController) validateAuthorizeRequest(ctx *gin.Context, request *models.AuthorizeRequest) (*models.OAuthClient, []string, error) {
+	if request.ResponseType != "code" {
+		return nil, nil, errors.New("response_type must be code")
+	}
+
+	client, err := c.appRepo.GetOAuthClientById(ctx.Request.Context(), request.ClientId)
+	if isNotFound(err) {
+		return nil, nil, errors.New("client_id is invalid")
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	redirectAllowed := false
+	for _, redirectURI := range client.RedirectURIs {
+		if redirectURI == request.RedirectURI {
+			redirectAllowed = true
+			break
+		}
+	}
+
+	if !redirectAllowed {
+		return nil, nil, errors.New("redirect_uri is not registered for the client")
+	}
+
+	scopes := auth.SplitScopes(request.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	if !auth.IsSubset(scopes, client.Scopes) {
+		return nil, nil, errors.New("the requested scopes exceed the scopes of the client")
+	}
+
+	if len(request.CodeChallenge) == 0 && !client.Confidential {
+		return nil, nil, errors.New("code_challenge is required for public clients")
+	}
+
+	if len(request.CodeChallenge) > 0 && request.CodeChallengeMethod != auth.PKCEMethodS256 {
+		return nil, nil, errors.New("code_challenge_method must be S256")
+	}
+
+	return client, scopes, nil
+}
+
+// tokenHoldsScopes aborts with 403 unless the token of the user holds the requested scopes, a client is never
+// authorized beyond them, like a personal access token is only down-scoped
+func (c *AppController) tokenHoldsScopes(ctx *gin.Context, claims *auth.Claims, scopes []string) bool {
+	if !auth.IsSubset(scopes, claims.Scopes()) {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, getErrorResponse("invalid authorization request",
+			errors.New("the requested scopes exceed the scopes of the current token")))
+		return false
+	}
+
+	return true
+}
+
+// authenticateOAuthClient reads the client credentials from http basic auth or else from the form
+func (c *AppController) authenticateOAuthClient(ctx *gin.Context) (*models.OAuthClient, error) {
+	clientId, clientSecret, ok := ctx.Request.BasicAuth()
+	if ok {
+		// the credentials are form encoded before being put in the basic auth header (RFC 6749 2.3.1)
+		clientId, _ = url.QueryUnescape(clientId)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientId = ctx.PostForm("client_id")
+		clientSecret = ctx.PostForm("client_secret")
+	}
+
+	if len(clientId) == 0 {
+		return nil, errors.New("client authentication is required")
+	}
+
+	return c.auth.AuthenticateClient(ctx.Request.Context(), clientId, clientSecret)
+}
+
+func getOAuthErrorResponse(code string, err error) *models.OAuthErrorResponse {
+	return &models.OAuthErrorResponse{
+		Error:            code,
+		ErrorDescription: err.Error(),
+	}
+}
@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/auth"
+	"gos/app/models"
+	"gos/app/oidc"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// oidcStateCookie keeps the signed state of an external login between the redirect and the callback
+const oidcStateCookie = "gos_oidc_state"
+
+// oidcCookiePath limits the state cookie to the external login routes
+const oidcCookiePath = "/api/auth/oidc"
+
+// swagger:operation GET /api/auth/oidc/login OIDCLogin
+//
+// OIDCLogin redirects to the external OpenID Connect provider, the login completes at the callback
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: scope
+//   in: query
+//   description: the space separated scopes of the access token, all the scopes when empty
+//   type: string
+// - name: cookie
+//   in: query
+//   description: true to get the access token in the session cookie
+//   type: boolean
+// responses:
+//  '302':
+//    description: redirect to the provider
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: external login is not configured
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '503':
+//    description: the provider is unavailable
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) OIDCLogin(ctx *gin.Context) {
+	if c.oidc == nil {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, getErrorResponse("external login is not configured", errors.New("no oidc provider")))
+		return
+	}
+
+	scopes := auth.SplitScopes(ctx.Query("scope"))
+	if len(scopes) == 0 {
+		scopes = auth.AllScopes
+	}
+
+	if err := auth.ValidateScopes(scopes); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	stateToken, state, err := c.auth.GenerateOIDCStateToken(scopes, ctx.Query("cookie") == "true", c.config.OIDC.StateTTL)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to start external login", err)
+		return
+	}
+
+	authURL, err := c.oidc.AuthCodeURL(ctx, state.State, state.Nonce, auth.PKCEChallenge(state.CodeVerifier))
+	if err != nil {
+		abortWithError(ctx, http.StatusServiceUnavailable, "failed to start external login", err)
+		return
+	}
+
+	c.setOIDCStateCookie(ctx, stateToken, int(c.config.OIDC.StateTTL.Seconds()))
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// swagger:operation GET /api/auth/oidc/callback OIDCCallback
+//
+// OIDCCallback completes the external login, the user is linked by verified email or provisioned on the first login
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: code
+//   in: query
+//   description: the authorization code from the provider
+//   type: string
+// - name: state
+//   in: query
+//   description: the state sent to the provider
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: the login at the provider failed
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: the identity can't be linked to an account
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: external login is not configured
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) OIDCCallback(ctx *gin.Context) {
+	if c.oidc == nil {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, getErrorResponse("external login is not configured", errors.New("no oidc provider")))
+		return
+	}
+
+	stateToken, err := ctx.Cookie(oidcStateCookie)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("the login wasn't started from this browser or it expired")))
+		return
+	}
+
+	// the state is single-use, the cookie is cleared whatever the outcome
+	c.setOIDCStateCookie(ctx, "", -1)
+
+	state, err := c.auth.ParseOIDCStateToken(stateToken)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(state.State), []byte(ctx.Query("state"))) != 1 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("state doesn't match")))
+		return
+	}
+
+	if providerError := ctx.Query("error"); len(providerError) > 0 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, getErrorResponse("external login failed",
+			fmt.Errorf("%s: %s", providerError, ctx.Query("error_description"))))
+		return
+	}
+
+	code := ctx.Query("code")
+	if len(code) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("code is required")))
+		return
+	}
+
+	token, err := c.oidc.Exchange(ctx, code, state.CodeVerifier)
+	if err != nil {
+		abortWithError(ctx, http.StatusUnauthorized, "external login failed", err)
+		return
+	}
+
+	claims, err := c.oidc.VerifyIDToken(ctx, token.IDToken, state.Nonce)
+	if err != nil {
+		abortWithError(ctx, http.StatusUnauthorized, "external login failed", err)
+		return
+	}
+
+	user, status, err := c.resolveOIDCUser(ctx, claims)
+	if err != nil {
+		abortWithError(ctx, status, "external login failed", err)
+		return
+	}
+
+	c.completeLogin(ctx, user, auth.SplitScopes(state.Scope), loginOptions{UseCookie: state.Cookie, Method: "oidc"})
+}
+
+// resolveOIDCUser finds the user linked to the external identity, links the user with the same verified email
+// or provisions a new one, the http status is returned with the error
+func (c *AppController) resolveOIDCUser(ctx *gin.Context, claims *oidc.IDTokenClaims) (*models.User, int, error) {
+	now := time.Now().Unix()
+
+	identity, err := c.appRepo.GetUserIdentity(ctx.Request.Context(), claims.Issuer, claims.Subject)
+	if err == nil {
+		user, err := c.appRepo.GetUserById(ctx.Request.Context(), identity.UserId)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		_, err = c.appRepo.UpdateUserIdentityLastLogin(ctx.Request.Context(), identity.IdentityId, now)
+		if err != nil {
+			fmt.Println(errors.Wrap(err, "failed to update identity last login"))
+		}
+
+		return user, http.StatusOK, nil
+	}
+
+	// an email the provider didn't verify could be anyone's, it is never used to link or provision
+	email := strings.TrimSpace(claims.Email)
+	if len(email) == 0 || !claims.EmailVerified {
+		return nil, http.StatusForbidden, errors.New("the provider didn't return a verified email")
+	}
+
+	user, err := c.appRepo.GetUserByEmail(ctx.Request.Context(), email)
+	if err != nil && !isNotFound(err) {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	if err == nil {
+		if !user.EmailVerified {
+			// whoever registered the unverified account didn't prove the email, their password and tokens are dropped
+			user.Password = ""
+			user.TokenVersion++
+			user.EmailVerified = true
+			user.DateUpdated = now
+
+			_, err = c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+			if err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+		}
+	} else {
+		if !c.config.OIDC.AutoProvision {
+			return nil, http.StatusForbidden, fmt.Errorf("no account with the email [%s]", email)
+		}
+
+		name := claims.Name
+		if len(name) == 0 {
+			name = email
+		}
+
+		user = &models.User{
+			Name:          name,
+			Email:         email,
+			DateCreated:   now,
+			DateUpdated:   now,
+			EmailVerified: true,
+			Role:          auth.RoleUser,
+		}
+
+		result, err := c.appRepo.AddUser(ctx.Request.Context(), *user)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		user.UserId, err = result.LastInsertId()
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+
+		c.securityEvent(ctx, user.UserId, eventRegistration, "external login "+claims.Issuer)
+	}
+
+	_, err = c.appRepo.AddUserIdentity(ctx.Request.Context(), models.UserIdentity{
+		UserId:      user.UserId,
+		Issuer:      claims.Issuer,
+		Subject:     claims.Subject,
+		Email:       email,
+		DateCreated: now,
+		LastLogin:   now,
+	})
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return user, http.StatusOK, nil
+}
+
+func (c *AppController) setOIDCStateCookie(ctx *gin.Context, value string, maxAge int) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(c.config.OIDC.Provider.RedirectURL, "https://"),
+		// lax lets the cookie through on the top-level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/auth"
+	"gos/app/mailer"
+	"gos/app/models"
+	"gos/app/password"
+	"gos/app/repo"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// forgotPasswordMessage is returned whether the email exists or not, so accounts can't be enumerated
+const forgotPasswordMessage = "if the email is registered, a password reset link has been sent to it"
+
+// swagger:operation POST /api/auth/password/forgot ForgotPassword
+//
+// ForgotPassword sends a password reset link to the user's email
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: body
+//   in: body
+//   description: the forgot password obj
+//   schema:
+//    $ref: '#/definitions/ForgotPasswordRequest'
+// responses:
+//  '202':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) ForgotPassword(ctx *gin.Context) {
+	request := new(models.ForgotPasswordRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if len(request.Email) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field email is required")))
+		return
+	}
+
+	user, err := c.appRepo.GetUserByEmail(ctx.Request.Context(), request.Email)
+	if err != nil && !isNotFound(err) {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to send password reset", err)
+		return
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusAccepted, &models.Response{
+			Message: forgotPasswordMessage,
+		})
+		return
+	}
+
+	err = c.sendPasswordReset(ctx, *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to create password reset", err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, &models.Response{
+		Message: forgotPasswordMessage,
+	})
+}
+
+// swagger:operation POST /api/auth/password/reset ResetPassword
+//
+// ResetPassword sets a new password using a reset token, all existing sessions are revoked
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: body
+//   in: body
+//   description: the reset password obj
+//   schema:
+//    $ref: '#/definitions/ResetPasswordRequest'
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request or the password doesn't meet the policy
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) ResetPassword(ctx *gin.Context) {
+	request := new(models.ResetPasswordRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if len(request.Token) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field token is required")))
+		return
+	}
+
+	invalidToken := errors.New("reset token is invalid or expired")
+	now := time.Now().Unix()
+
+	reset, err := c.appRepo.GetPasswordResetByTokenHash(ctx.Request.Context(), auth.HashToken(request.Token))
+	if err != nil || reset.DateUsed != 0 || reset.ExpiresAt < now {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to reset password", invalidToken))
+		return
+	}
+
+	user, err := c.appRepo.GetUserById(ctx.Request.Context(), reset.UserId)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to reset password", invalidToken))
+		return
+	}
+
+	if !c.checkPasswordPolicy(ctx, request.Password, user.Email) {
+		return
+	}
+
+	hash, err := c.hasher.Hash(request.Password)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to reset password", err)
+		return
+	}
+
+	user.Password = hash
+	user.FailedLoginAttempt = 0
+	user.DateUpdated = now
+	user.TokenVersion++
+	// the reset link was delivered to the email, so it proves the ownership too
+	user.EmailVerified = true
+
+	// the token is consumed first, so two concurrent requests can't both use it,
+	// and in the same transaction as the password so a failed update doesn't burn it
+	err = c.appRepo.WithTx(ctx.Request.Context(), func(tx repo.IAppRepo) error {
+		result, err := tx.UsePasswordReset(ctx.Request.Context(), reset.ResetId, now)
+		if err != nil {
+			return err
+		}
+
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return invalidToken
+		}
+
+		_, err = tx.UpdateUser(ctx.Request.Context(), *user)
+		if err != nil {
+			return err
+		}
+
+		return c.revokeCredentials(ctx.Request.Context(), tx, user.UserId, now)
+	})
+	if err == invalidToken {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to reset password", invalidToken))
+		return
+	}
+
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to reset password", err)
+		return
+	}
+
+	_, err = c.appRepo.InvalidatePasswordResets(ctx.Request.Context(), user.UserId, now)
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to invalidate password resets"))
+	}
+
+	c.securityEvent(ctx, user.UserId, eventPasswordChanged, "reset")
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully reset password",
+	})
+}
+
+// revokeCredentials revokes the sessions and the personal access tokens of a user whose password was reset or changed,
+// the bumped token version only rejects the access tokens, a session could still be refreshed and a token used
+func (c *AppController) revokeCredentials(ctx context.Context, tx repo.IAppRepo, userId int64, now int64) error {
+	_, err := tx.RevokeUserSessions(ctx, userId, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.RevokeUserAccessTokens(ctx, userId, now)
+	return err
+}
+
+// sendPasswordReset stores a single-use reset token for the user and emails the reset link,
+// a failed delivery is only logged
+func (c *AppController) sendPasswordReset(ctx *gin.Context, user models.User) error {
+	token, err := auth.GenerateRandomToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	_, err = c.appRepo.AddPasswordReset(ctx.Request.Context(), models.PasswordReset{
+		UserId:      user.UserId,
+		TokenHash:   auth.HashToken(token),
+		DateCreated: now,
+		ExpiresAt:   now + int64(c.config.PasswordResetTTL.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to reset your password, it expires in %v.\n\n%s\n\nIf you did not ask for a password reset, you can ignore this email.\n",
+			user.Name, c.config.PasswordResetTTL, tokenLink(c.config.PasswordResetURL, token)),
+	})
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to send password reset email"))
+	}
+
+	return nil
+}
+
+// tokenLink adds the token as a query param to the link
+func tokenLink(link string, token string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link + "?token=" + url.QueryEscape(token)
+	}
+
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// checkPasswordPolicy validates a new password and responds with every broken rule when it fails,
+// false is returned when the request was aborted
+func (c *AppController) checkPasswordPolicy(ctx *gin.Context, newPassword string, email string) bool {
+	err := c.config.Password.Validate(newPassword, email)
+	if err == nil {
+		return true
+	}
+
+	if policyErr, ok := err.(*password.PolicyError); ok {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, &models.Response{
+			Message: "password doesn't meet the policy",
+			Errors:  policyErr.Violations,
+		})
+		return false
+	}
+
+	abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+	return false
+}
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/models"
+	"net/http"
+	"time"
+)
+
+// the types of the security events
+const (
+	eventRegistration             = "registration"
+	eventLoginSuccess             = "login.success"
+	eventLoginFailure             = "login.failure"
+	eventLockout                  = "login.lockout"
+	eventLogout                   = "logout"
+	eventTokenRevoked             = "token.revoked"
+	eventPasswordChanged          = "password.changed"
+	eventEmailChanged             = "email.changed"
+	eventMFAEnabled               = "2fa.enabled"
+	eventMFADisabled              = "2fa.disabled"
+	eventAccountDeletionScheduled = "account.deletion_scheduled"
+	eventAccountRestored          = "account.restored"
+)
+
+// securityEventPurgeBatchSize is how many events are deleted by a statement of the retention purge
+const securityEventPurgeBatchSize = 1000
+
+// swagger:operation GET /api/secured/me/security-events GetSecurityEvents
+//
+// GetSecurityEvents lists the security events of the logged in user, oldest first
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// - name: type
+//   in: query
+//   description: only the events of this type
+//   type: string
+// - name: lastId
+//   in: query
+//   description: the id of the last event in the previous page
+//   type: string
+// - name: limit
+//   in: query
+//   description: the page size value
+//   type: integer
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetSecurityEvents(ctx *gin.Context) {
+	user := ctx.MustGet("user").(*models.User)
+
+	params, err := bindAdminPageParams(ctx)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "failed to extract query params", err)
+		return
+	}
+
+	c.respondWithSecurityEvents(ctx, user.UserId, params)
+}
+
+// swagger:operation GET /api/admin/security-events GetSecurityLog
+//
+// GetSecurityLog lists the security events of all the users, oldest first
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token of an admin
+//   type: string
+// - name: userId
+//   in: query
+//   description: only the events of this user
+//   type: integer
+// - name: type
+//   in: query
+//   description: only the events of this type
+//   type: string
+// - name: lastId
+//   in: query
+//   description: the id of the last event in the previous page
+//   type: string
+// - name: limit
+//   in: query
+//   description: the page size value
+//   type: integer
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '403':
+//    description: not an admin
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetSecurityLog(ctx *gin.Context) {
+	params, err := bindAdminPageParams(ctx)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "failed to extract query params", err)
+		return
+	}
+
+	c.audit(ctx, auditViewSecurityLog, params.UserId, fmt.Sprintf("type=%s lastId=%d", params.Type, params.LastId))
+	c.respondWithSecurityEvents(ctx, params.UserId, params)
+}
+
+// PurgeSecurityEvents deletes the events older than the retention, the number of deleted events is returned
+func (c *AppController) PurgeSecurityEvents(ctx context.Context, now int64) (int, error) {
+	before := now - int64(c.config.SecurityEvents.Retention.Seconds())
+
+	deleted := 0
+	for {
+		result, err := c.appRepo.DeleteSecurityEventsBefore(ctx, before, securityEventPurgeBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += int(affected)
+		if affected < securityEventPurgeBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+func (c *AppController) respondWithSecurityEvents(ctx *gin.Context, userId int64, params *adminPageParams) {
+	events, err := c.appRepo.GetSecurityEvents(ctx.Request.Context(), userId, params.Type, params.LastId, params.Limit)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to get security events", err)
+		return
+	}
+
+	var nextEventId int64
+	if len(events) > 0 {
+		nextEventId = events[len(events)-1].EventId
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully retrieved %d security event/s", len(events)),
+		Data: &models.Paged{
+			Items:  events,
+			LastId: nextEventId,
+			Limit:  params.Limit,
+		},
+	})
+}
+
+// securityEvent records an event with the ip and the user agent of the request, a failure is only logged
+func (c *AppController) securityEvent(ctx *gin.Context, userId int64, eventType string, details string) {
+	userAgent := ctx.GetHeader("User-Agent")
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	// the event is written even when the client went away or the db timeout of the request passed
+	_, err := c.appRepo.AddSecurityEvent(context.WithoutCancel(ctx.Request.Context()), models.SecurityEvent{
+		UserId:      userId,
+		Type:        eventType,
+		Details:     details,
+		IP:          ctx.ClientIP(),
+		UserAgent:   userAgent,
+		DateCreated: time.Now().Unix(),
+	})
+	if err != nil {
+		fmt.Println(errors.Wrapf(err, "failed to write security event %s of user %d", eventType, userId))
+	}
+}
+
+// loginFailed counts a failed login attempt in the stored row and locks the login once the stored count reached the limit,
+// the attempts of concurrent requests are all counted and only one of them locks
+func (c *AppController) loginFailed(ctx *gin.Context, user *models.User, reason string) {
+	// a client going away right after a wrong password must not escape the count
+	dbCtx := context.WithoutCancel(ctx.Request.Context())
+
+	c.securityEvent(ctx, user.UserId, eventLoginFailure, reason)
+
+	_, err := c.appRepo.IncrementFailedLoginAttempt(dbCtx, user.UserId)
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to update FailedLoginAttempt"))
+		return
+	}
+
+	maxAttempts := c.config.Lockout.MaxFailedAttempts
+	if maxAttempts <= 0 {
+		return
+	}
+
+	// the next lockout needs as many attempts again
+	result, err := c.appRepo.LockLogin(dbCtx, user.UserId, maxAttempts, time.Now().Add(c.config.Lockout.Duration).Unix())
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to update LockedUntil"))
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+		c.securityEvent(ctx, user.UserId, eventLockout, fmt.Sprintf("locked for %v after %d failed attempts", c.config.Lockout.Duration, maxAttempts))
+	}
+}
+
+// loginLocked tells if the login of the user is locked and records the attempt, the callers answer it like wrong
+// credentials so a lockout does not tell that an account exists
+func (c *AppController) loginLocked(ctx *gin.Context, user *models.User) bool {
+	if user.LockedUntil <= time.Now().Unix() {
+		return false
+	}
+
+	c.securityEvent(ctx, user.UserId, eventLoginFailure, "account is locked")
+	return true
+}
+
+// loginSucceeded resets the failed attempts and records the login
+func (c *AppController) loginSucceeded(ctx *gin.Context, user models.User, method string) {
+	_, err := c.appRepo.RecordLogin(ctx.Request.Context(), user.UserId, time.Now().Unix())
+	if err != nil {
+		fmt.Println(errors.Wrap(err, "failed to update LastLogin"))
+	}
+
+	c.securityEvent(ctx, user.UserId, eventLoginSuccess, "method="+method)
+}
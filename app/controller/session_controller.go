@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/auth"
+	"gos/app/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// swagger:operation GET /api/secured/sessions GetSessions
+//
+// GetSessions gets the active sessions of the logged in user, the session of the current token is marked
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) GetSessions(ctx *gin.Context) {
+	claims := ctx.MustGet("claims")
+	claimsObj := claims.(*auth.Claims)
+
+	sessions, err := c.appRepo.GetSessions(ctx.Request.Context(), claimsObj.UserId, time.Now().Unix())
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to get sessions", err)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionId == claimsObj.SessionId
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully retrieved %d session/s", len(sessions)),
+		Data:    sessions,
+	})
+}
+
+// swagger:operation DELETE /api/secured/sessions/:sessionId RevokeSession
+//
+// RevokeSession revokes a session of the logged in user, the tokens issued to it are rejected at once
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: x-access-token
+//   in: header
+//   description: the access token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '404':
+//    description: session not found
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '401':
+//    description: unauthorized access
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) RevokeSession(ctx *gin.Context) {
+	sessionId, err := strconv.ParseInt(ctx.Param("sessionId"), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("session id is invalid")))
+		return
+	}
+
+	claims := ctx.MustGet("claims")
+	claimsObj := claims.(*auth.Claims)
+
+	result, err := c.appRepo.RevokeSession(ctx.Request.Context(), sessionId, claimsObj.UserId, time.Now().Unix())
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to revoke session", err)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, getErrorResponse("failed to revoke session", errors.New("session not found")))
+		return
+	}
+
+	c.securityEvent(ctx, claimsObj.UserId, eventTokenRevoked, fmt.Sprintf("session=%d", sessionId))
+
+	if sessionId == claimsObj.SessionId {
+		c.clearSessionCookies(ctx)
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: fmt.Sprintf("successfully revoked session with id %d", sessionId),
+	})
+}
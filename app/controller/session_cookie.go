@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"gos/app/auth"
+	"gos/app/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// loginOptions are what the client asked for at the login
+type loginOptions struct {
+	UseCookie  bool
+	DeviceName string
+	// Method is how the user signed in, it is recorded with the login
+	Method string
+}
+
+// respondWithAccessToken creates the session of a completed login and issues its access token, in the response
+// or in the HttpOnly session cookie with a csrf token when the cookie mode is asked
+func (c *AppController) respondWithAccessToken(ctx *gin.Context, user models.User, scopes []string, options loginOptions) {
+	userAgent := ctx.GetHeader("User-Agent")
+	deviceName := strings.TrimSpace(options.DeviceName)
+	if len(deviceName) == 0 {
+		deviceName = auth.DeviceName(userAgent)
+	}
+
+	if len(deviceName) > maxDeviceNameLength {
+		deviceName = deviceName[:maxDeviceNameLength]
+	}
+
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	now := time.Now().Unix()
+	result, err := c.appRepo.AddSession(ctx.Request.Context(), models.Session{
+		UserId:      user.UserId,
+		DeviceName:  deviceName,
+		UserAgent:   userAgent,
+		IP:          ctx.ClientIP(),
+		DateCreated: now,
+		LastSeen:    now,
+		ExpiresAt:   now + int64((auth.AccessTokenExpirationMinutes * time.Minute).Seconds()),
+	})
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to login", err)
+		return
+	}
+
+	sessionId, err := result.LastInsertId()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to login", err)
+		return
+	}
+
+	tokenString, expiresAt, err := c.auth.GenerateAccessToken(user, scopes, sessionId)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to login", err)
+		return
+	}
+
+	c.loginSucceeded(ctx, user, options.Method)
+
+	if !options.UseCookie {
+		ctx.JSON(http.StatusOK, &models.Response{
+			Message: "successfully logged in user",
+			Data: models.LoginResponse{
+				Token:     tokenString,
+				ExpiresAt: expiresAt,
+			},
+		})
+		return
+	}
+
+	csrfToken, err := c.auth.GenerateCSRFToken(tokenString)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to login", err)
+		return
+	}
+
+	maxAge := int(time.Until(time.Unix(expiresAt, 0)).Seconds())
+	c.setCookie(ctx, auth.SessionCookie, tokenString, maxAge, true)
+	c.setCookie(ctx, auth.CSRFCookie, csrfToken, maxAge, false)
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully logged in user",
+		Data: models.LoginResponse{
+			ExpiresAt: expiresAt,
+			CSRFToken: csrfToken,
+		},
+	})
+}
+
+// the longest device name and user agent stored with a session
+const (
+	maxDeviceNameLength = 100
+	maxUserAgentLength  = 500
+)
+
+// clearSessionCookies removes the session and csrf cookies
+func (c *AppController) clearSessionCookies(ctx *gin.Context) {
+	c.setCookie(ctx, auth.SessionCookie, "", -1, true)
+	c.setCookie(ctx, auth.CSRFCookie, "", -1, false)
+}
+
+func (c *AppController) setCookie(ctx *gin.Context, name string, value string, maxAge int, httpOnly bool) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   c.config.Cookie.Domain,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   c.config.Cookie.Secure,
+		SameSite: sameSiteMode(c.config.Cookie.SameSite),
+	})
+}
+
+func sameSiteMode(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
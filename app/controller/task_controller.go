@@ -58,7 +58,7 @@ func (c *AppController) GetTasks(ctx *gin.Context) {
 
 	err := ctx.BindQuery(&params)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to extract query params", err))
+		abortWithError(ctx, http.StatusBadRequest, "failed to extract query params", err)
 		return
 	}
 
@@ -71,9 +71,9 @@ func (c *AppController) GetTasks(ctx *gin.Context) {
 	claims := ctx.MustGet("claims")
 	claimsObj := claims.(*auth.Claims)
 
-	tasks, err := c.appRepo.GetAllTasks(ctx, params.LastId, claimsObj.UserId, params.Limit)
+	tasks, err := c.appRepo.GetAllTasks(ctx.Request.Context(), params.LastId, claimsObj.UserId, params.Limit)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to get tasks", err))
+		abortWithError(ctx, http.StatusInternalServerError, "failed to get tasks", err)
 		return
 	}
 
@@ -114,6 +114,10 @@ func (c *AppController) GetTasks(ctx *gin.Context) {
 //    description: invalid request
 //    schema:
 //     $ref: '#/definitions/Response'
+//  '404':
+//    description: task not found
+//    schema:
+//     $ref: '#/definitions/Response'
 //  '500':
 //    description: internal server error
 //    schema:
@@ -133,9 +137,9 @@ func (c *AppController) GetTask(ctx *gin.Context) {
 	claims := ctx.MustGet("claims")
 	claimsObj := claims.(*auth.Claims)
 
-	task, err := c.appRepo.GetTaskById(ctx, taskIdVal, claimsObj.UserId)
+	task, err := c.appRepo.GetTaskById(ctx.Request.Context(), taskIdVal, claimsObj.UserId)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to get task", err))
+		abortWithError(ctx, http.StatusInternalServerError, "failed to get task", err)
 		return
 	}
 
@@ -183,7 +187,7 @@ func (c *AppController) AddTask(ctx *gin.Context) {
 	claimsObj := claims.(*auth.Claims)
 	task := new(models.Task)
 	if err := ctx.BindJSON(task); err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", err))
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
 		return
 	}
 
@@ -196,9 +200,9 @@ func (c *AppController) AddTask(ctx *gin.Context) {
 		return
 	}
 
-	_, err := c.appRepo.AddTask(ctx, *task)
+	_, err := c.appRepo.AddTask(ctx.Request.Context(), *task)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to add task", err))
+		abortWithError(ctx, http.StatusInternalServerError, "failed to add task", err)
 		return
 	}
 
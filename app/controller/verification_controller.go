@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"gos/app/mailer"
+	"gos/app/models"
+	"net/http"
+	"time"
+)
+
+// resendVerificationMessage is returned whether the email exists or not, so accounts can't be enumerated
+const resendVerificationMessage = "if the email is registered and not verified, a verification link has been sent to it"
+
+// swagger:operation GET /api/auth/verify VerifyEmail
+//
+// VerifyEmail verifies the user's email using the token from the verification link
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: token
+//   in: query
+//   description: the verification token
+//   type: string
+// responses:
+//  '200':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) VerifyEmail(ctx *gin.Context) {
+	token := ctx.Query("token")
+	if len(token) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("query param token is required")))
+		return
+	}
+
+	claims, err := c.auth.ParseVerificationToken(token)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "failed to verify email", err)
+		return
+	}
+
+	user, err := c.appRepo.GetUserById(ctx.Request.Context(), claims.UserId)
+	if err != nil || user.Email != claims.Email {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("failed to verify email", errors.New("verification token is invalid")))
+		return
+	}
+
+	if user.EmailVerified {
+		ctx.JSON(http.StatusOK, &models.Response{
+			Message: "email is already verified",
+		})
+		return
+	}
+
+	user.EmailVerified = true
+	user.DateUpdated = time.Now().Unix()
+
+	_, err = c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to verify email", err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &models.Response{
+		Message: "successfully verified email",
+	})
+}
+
+// swagger:operation POST /api/auth/verify/resend ResendVerification
+//
+// ResendVerification sends the verification link again, it is throttled per user
+// ---
+// produces:
+// - application/json
+// parameters:
+// - name: body
+//   in: body
+//   description: the resend verification obj
+//   schema:
+//    $ref: '#/definitions/ResendVerificationRequest'
+// responses:
+//  '202':
+//    description: successful operation
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '400':
+//    description: invalid request
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '429':
+//    description: too many requests
+//    schema:
+//     $ref: '#/definitions/Response'
+//  '500':
+//    description: internal server error
+//    schema:
+//     $ref: '#/definitions/Response'
+func (c *AppController) ResendVerification(ctx *gin.Context) {
+	request := new(models.ResendVerificationRequest)
+	if err := ctx.BindJSON(request); err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if len(request.Email) == 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, getErrorResponse("invalid request", errors.New("field email is required")))
+		return
+	}
+
+	user, err := c.appRepo.GetUserByEmail(ctx.Request.Context(), request.Email)
+	if err != nil && !isNotFound(err) {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to resend verification", err)
+		return
+	}
+
+	if err != nil || user.EmailVerified {
+		ctx.JSON(http.StatusAccepted, &models.Response{
+			Message: resendVerificationMessage,
+		})
+		return
+	}
+
+	now := time.Now()
+	nextAllowed := time.Unix(user.DateVerificationSent, 0).Add(c.config.VerificationResendInterval)
+	if now.Before(nextAllowed) {
+		retryAfter := int64(nextAllowed.Sub(now).Seconds()) + 1
+		ctx.Header("Retry-After", fmt.Sprint(retryAfter))
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, getErrorResponse("failed to resend verification",
+			fmt.Errorf("a verification email was sent recently, retry in %d seconds", retryAfter)))
+		return
+	}
+
+	user.DateVerificationSent = now.Unix()
+	_, err = c.appRepo.UpdateUser(ctx.Request.Context(), *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to resend verification", err)
+		return
+	}
+
+	err = c.sendVerificationEmail(ctx, *user)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, "failed to resend verification", err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, &models.Response{
+		Message: resendVerificationMessage,
+	})
+}
+
+func (c *AppController) sendVerificationEmail(ctx context.Context, user models.User) error {
+	token, err := c.auth.GenerateVerificationToken(user, c.config.VerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return c.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to verify your email, it expires in %v.\n\n%s\n",
+			user.Name, c.config.VerificationTTL, tokenLink(c.config.VerificationURL, token)),
+	})
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// LogMailer writes emails to a writer (stdout or a file) instead of delivering them,
+// it is meant for local development and tests
+type LogMailer struct {
+	mu   sync.Mutex
+	out  io.Writer
+	sent []Message
+}
+
+// NewLogMailer creates a new log mailer writing to out
+func NewLogMailer(out io.Writer) *LogMailer {
+	return &LogMailer{
+		out: out,
+	}
+}
+
+// Send writes the message to the underlying writer
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.out, "----- email %s -----\nTo: %s\nSubject: %s\n\n%s\n-----\n",
+		time.Now().UTC().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to write email")
+	}
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns all the messages sent so far
+func (m *LogMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := make([]Message, len(m.sent))
+	copy(sent, m.sent)
+	return sent
+}
+
+var _ IMailer = (*LogMailer)(nil)
@@ -0,0 +1,17 @@
+package mailer
+
+import (
+	"context"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// IMailer is an interface for delivering emails
+type IMailer interface {
+	Send(ctx context.Context, msg Message) error
+}
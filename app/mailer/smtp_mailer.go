@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// headerReplacer strips line breaks so user input can't inject headers
+var headerReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+// SMTPConfig keeps the smtp server settings
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	From     string
+}
+
+// SMTPMailer delivers emails through an smtp server
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+// NewSMTPMailer creates a new smtp mailer
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{
+		config: config,
+	}
+}
+
+// Send sends the message, STARTTLS is used when the server supports it
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if len(m.config.User) > 0 {
+		auth = smtp.PlainAuth("", m.config.User, m.config.Password, m.config.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", m.config.Host, m.config.Port)
+	err := smtp.SendMail(addr, auth, m.config.From, []string{msg.To}, m.buildMessage(msg))
+	if err != nil {
+		return errors.Wrap(err, "failed to send email")
+	}
+
+	return nil
+}
+
+func (m *SMTPMailer) buildMessage(msg Message) []byte {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("From: %s\r\n", m.config.From))
+	b.WriteString(fmt.Sprintf("To: %s\r\n", headerReplacer.Replace(msg.To)))
+	b.WriteString(fmt.Sprintf("Subject: %s\r\n", headerReplacer.Replace(msg.Subject)))
+	b.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z)))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(msg.Body, "\n", "\r\n", -1))
+
+	return []byte(b.String())
+}
+
+var _ IMailer = (*SMTPMailer)(nil)
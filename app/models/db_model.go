@@ -3,14 +3,26 @@ package models
 // swagger:model User
 // User model
 type User struct {
-	UserId             int64  `json:"userId"`
-	Name               string `json:"name"`
-	Email              string `json:"email"`
-	Password           string `json:"password"`
-	LastLogin          int    `json:"lastLogin,omitempty"`
-	FailedLoginAttempt int    `json:"failedLoginAttempt,omitempty"`
-	DateCreated        int64  `json:"dateCreated,omitempty"`
-	DateUpdated        int64  `json:"dateUpdated,omitempty"`
+	UserId               int64  `json:"userId"`
+	Name                 string `json:"name"`
+	Email                string `json:"email"`
+	Password             string `json:"-"`
+	LastLogin            int    `json:"lastLogin,omitempty"`
+	FailedLoginAttempt   int    `json:"failedLoginAttempt,omitempty"`
+	DateCreated          int64  `json:"dateCreated,omitempty"`
+	DateUpdated          int64  `json:"dateUpdated,omitempty"`
+	TokenVersion         int    `json:"-"`
+	EmailVerified        bool   `json:"emailVerified"`
+	DateVerificationSent int64  `json:"-"`
+	TOTPSecret           string `json:"-"`
+	TOTPEnabled          bool   `json:"totpEnabled"`
+	TOTPLastStep         int64  `json:"-"`
+	Role                 string `json:"role"`
+	Disabled             bool   `json:"disabled"`
+	// DeletionScheduledAt is when the account is deleted, 0 when no deletion is pending
+	DeletionScheduledAt int64 `json:"deletionScheduledAt,omitempty"`
+	// LockedUntil is when the login lockout after too many failed attempts ends
+	LockedUntil int64 `json:"lockedUntil,omitempty"`
 }
 
 // swagger:model Task
@@ -25,3 +37,144 @@ type Task struct {
 	DueDate       int64  `json:"dueDate,omitempty"`
 	DateCompleted int64  `json:"dateCompleted,omitempty"`
 }
+
+// PasswordReset model, only the hash of the reset token is stored
+type PasswordReset struct {
+	ResetId     int64
+	UserId      int64
+	TokenHash   string
+	DateCreated int64
+	ExpiresAt   int64
+	DateUsed    int64
+}
+
+// RecoveryCode model, only the hash of the code is stored
+type RecoveryCode struct {
+	CodeId      int64
+	UserId      int64
+	CodeHash    string
+	DateCreated int64
+	DateUsed    int64
+}
+
+// swagger:model AccessToken
+// AccessToken model for personal access tokens, only the hash of the token is stored
+type AccessToken struct {
+	TokenId     int64    `json:"tokenId"`
+	UserId      int64    `json:"userId"`
+	Name        string   `json:"name"`
+	TokenHash   string   `json:"-"`
+	Scopes      []string `json:"scopes"`
+	DateCreated int64    `json:"dateCreated,omitempty"`
+	ExpiresAt   int64    `json:"expiresAt,omitempty"`
+	LastUsed    int64    `json:"lastUsed,omitempty"`
+	DateRevoked int64    `json:"dateRevoked,omitempty"`
+}
+
+// swagger:model OAuthClient
+// OAuthClient model, public clients have no secret and must use PKCE
+type OAuthClient struct {
+	ClientId         string   `json:"clientId"`
+	UserId           int64    `json:"userId"`
+	Name             string   `json:"name"`
+	ClientSecretHash string   `json:"-"`
+	RedirectURIs     []string `json:"redirectUris"`
+	Scopes           []string `json:"scopes"`
+	Confidential     bool     `json:"confidential"`
+	DateCreated      int64    `json:"dateCreated,omitempty"`
+}
+
+// OAuthCode model for the authorization codes, only the hash of the code is stored
+type OAuthCode struct {
+	CodeHash            string
+	ClientId            string
+	UserId              int64
+	RedirectURI         string
+	Scopes              []string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ExpiresAt           int64
+	DateUsed            int64
+}
+
+// swagger:model OAuthConsent
+// OAuthConsent model, the scopes a user granted to a client
+type OAuthConsent struct {
+	UserId      int64    `json:"userId"`
+	ClientId    string   `json:"clientId"`
+	Scopes      []string `json:"scopes"`
+	DateCreated int64    `json:"dateCreated,omitempty"`
+	DateUpdated int64    `json:"dateUpdated,omitempty"`
+}
+
+// UserIdentity model links a user to an account at an external OpenID Connect provider
+type UserIdentity struct {
+	IdentityId  int64
+	UserId      int64
+	Issuer      string
+	Subject     string
+	Email       string
+	DateCreated int64
+	LastLogin   int64
+}
+
+// swagger:model AuditEntry
+// AuditEntry model, an action of an admin
+type AuditEntry struct {
+	AuditId      int64  `json:"auditId"`
+	ActorUserId  int64  `json:"actorUserId"`
+	Action       string `json:"action"`
+	TargetUserId int64  `json:"targetUserId,omitempty"`
+	Details      string `json:"details,omitempty"`
+	IP           string `json:"ip,omitempty"`
+	DateCreated  int64  `json:"dateCreated"`
+}
+
+// swagger:model Session
+// Session model, a login on a device, the access tokens issued by the login are bound to it
+type Session struct {
+	SessionId   int64  `json:"sessionId"`
+	UserId      int64  `json:"userId"`
+	DeviceName  string `json:"deviceName"`
+	UserAgent   string `json:"userAgent"`
+	IP          string `json:"ip"`
+	DateCreated int64  `json:"dateCreated"`
+	LastSeen    int64  `json:"lastSeen"`
+	ExpiresAt   int64  `json:"expiresAt"`
+	DateRevoked int64  `json:"dateRevoked,omitempty"`
+	// Current marks the session of the token used for the request, it isn't stored
+	Current bool `json:"current,omitempty"`
+}
+
+// the states of an export job
+const (
+	ExportStatusPending = "pending"
+	ExportStatusDone    = "done"
+	ExportStatusFailed  = "failed"
+)
+
+// swagger:model ExportJob
+// ExportJob model, the assembling of a data export, the file can be downloaded until it expires
+type ExportJob struct {
+	JobId         int64  `json:"jobId"`
+	UserId        int64  `json:"userId"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	DateCreated   int64  `json:"dateCreated"`
+	DateCompleted int64  `json:"dateCompleted,omitempty"`
+	ExpiresAt     int64  `json:"expiresAt,omitempty"`
+	// DownloadURL is a short-lived link to the file when the job is done, it isn't stored
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
+
+// swagger:model SecurityEvent
+// SecurityEvent model, something that happened to the security of an account, the user is 0 when the email is unknown
+type SecurityEvent struct {
+	EventId     int64  `json:"eventId"`
+	UserId      int64  `json:"userId"`
+	Type        string `json:"type"`
+	Details     string `json:"details,omitempty"`
+	IP          string `json:"ip,omitempty"`
+	UserAgent   string `json:"userAgent,omitempty"`
+	DateCreated int64  `json:"dateCreated"`
+}
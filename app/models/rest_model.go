@@ -16,10 +16,14 @@ type Paged struct {
 }
 
 // swagger:model LoginResponse
-// LoginResponse model
+// LoginResponse model, when 2fa is enabled only the mfa token is set and the login has to be completed with it
 type LoginResponse struct {
-	Token     string `json:"token,omitempty"`
-	ExpiresAt int64  `json:"expiresAt,omitempty"`
+	Token       string `json:"token,omitempty"`
+	ExpiresAt   int64  `json:"expiresAt,omitempty"`
+	MFARequired bool   `json:"mfaRequired,omitempty"`
+	MFAToken    string `json:"mfaToken,omitempty"`
+	// CSRFToken is returned instead of the token in the cookie mode, it must be sent in the X-CSRF-Token header
+	CSRFToken string `json:"csrfToken,omitempty"`
 }
 
 // swagger:model LoginRequest
@@ -27,12 +31,197 @@ type LoginResponse struct {
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Scopes limits the access token, all the scopes are granted when empty
+	Scopes []string `json:"scopes,omitempty"`
+	// UseCookie sets the access token in an HttpOnly session cookie instead of returning it
+	UseCookie bool `json:"useCookie,omitempty"`
+	// DeviceName names the session, it is derived from the user agent when empty
+	DeviceName string `json:"deviceName,omitempty"`
 }
 
 // swagger:model RegisterRequest
 // RegisterRequest model
 type RegisterRequest struct {
-	Name    string `json:"name"`
+	Name     string `json:"name"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+// swagger:model ForgotPasswordRequest
+// ForgotPasswordRequest model
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// swagger:model ResetPasswordRequest
+// ResetPasswordRequest model
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// swagger:model ResendVerificationRequest
+// ResendVerificationRequest model
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// swagger:model LoginMFARequest
+// LoginMFARequest model, either the code or a recovery code is required
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+	// UseCookie sets the access token in an HttpOnly session cookie instead of returning it
+	UseCookie bool `json:"useCookie,omitempty"`
+	// DeviceName names the session, it is derived from the user agent when empty
+	DeviceName string `json:"deviceName,omitempty"`
+}
+
+// swagger:model EnrollTOTPResponse
+// EnrollTOTPResponse model
+type EnrollTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// swagger:model ConfirmTOTPRequest
+// ConfirmTOTPRequest model
+type ConfirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// swagger:model RecoveryCodesResponse
+// RecoveryCodesResponse model
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// swagger:model DisableTOTPRequest
+// DisableTOTPRequest model, either the code or a recovery code is required with the password
+type DisableTOTPRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// swagger:model CreateAccessTokenRequest
+// CreateAccessTokenRequest model
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expiresInDays"`
+}
+
+// swagger:model CreateAccessTokenResponse
+// CreateAccessTokenResponse model, the token is shown only once
+type CreateAccessTokenResponse struct {
+	Token       string       `json:"token"`
+	AccessToken *AccessToken `json:"accessToken"`
+}
+
+// swagger:model CreateOAuthClientRequest
+// CreateOAuthClientRequest model
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+	Confidential bool     `json:"confidential"`
+}
+
+// swagger:model CreateOAuthClientResponse
+// CreateOAuthClientResponse model, the secret is shown only once
+type CreateOAuthClientResponse struct {
+	ClientSecret string       `json:"clientSecret,omitempty"`
+	Client       *OAuthClient `json:"client"`
+}
+
+// swagger:model AuthorizeRequest
+// AuthorizeRequest model, sent by the consent page when the user approves or denies a client
+type AuthorizeRequest struct {
+	ClientId            string `json:"clientId" form:"client_id"`
+	RedirectURI         string `json:"redirectUri" form:"redirect_uri"`
+	ResponseType        string `json:"responseType" form:"response_type"`
+	Scope               string `json:"scope" form:"scope"`
+	State               string `json:"state" form:"state"`
+	CodeChallenge       string `json:"codeChallenge" form:"code_challenge"`
+	CodeChallengeMethod string `json:"codeChallengeMethod" form:"code_challenge_method"`
+	Approve             bool   `json:"approve" form:"-"`
+}
+
+// swagger:model AuthorizeResponse
+// AuthorizeResponse model
+type AuthorizeResponse struct {
+	Client          *OAuthClient `json:"client,omitempty"`
+	Scopes          []string     `json:"scopes,omitempty"`
+	AlreadyApproved bool         `json:"alreadyApproved,omitempty"`
+	RedirectTo      string       `json:"redirectTo,omitempty"`
+}
+
+// swagger:model OAuthTokenResponse
+// OAuthTokenResponse model as defined by RFC 6749
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// swagger:model OAuthErrorResponse
+// OAuthErrorResponse model as defined by RFC 6749
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// swagger:model IntrospectionResponse
+// IntrospectionResponse model as defined by RFC 7662
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+}
+
+// swagger:model UpdateRoleRequest
+// UpdateRoleRequest model, the role is user, admin or a custom one
+type UpdateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// swagger:model UpdateAccountRequest
+// UpdateAccountRequest model, only the fields sent are changed
+type UpdateAccountRequest struct {
+	Name *string `json:"name,omitempty"`
+}
+
+// swagger:model ChangePasswordRequest
+// ChangePasswordRequest model, an account without a current password (external login) may confirm with a 2fa code
+// or a recovery code instead
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+	Code            string `json:"code,omitempty"`
+	RecoveryCode    string `json:"recoveryCode,omitempty"`
+}
+
+// swagger:model ChangeEmailRequest
+// ChangeEmailRequest model, an account without a password may confirm with a 2fa code or a recovery code instead
+type ChangeEmailRequest struct {
+	Password     string `json:"password"`
+	Email        string `json:"email"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
+
+// swagger:model DeleteAccountRequest
+// DeleteAccountRequest model, an account without a password may confirm with a 2fa code or a recovery code instead
+type DeleteAccountRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recoveryCode,omitempty"`
+}
@@ -0,0 +1,210 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config keeps the settings of the relying party registered at the provider
+type Config struct {
+	// IssuerURL is the issuer of the provider, the discovery document is read from IssuerURL/.well-known/openid-configuration
+	IssuerURL    string
+	ClientId     string
+	ClientSecret string
+	// RedirectURL is the callback registered at the provider
+	RedirectURL string
+	Scopes      []string
+}
+
+// Metadata is the part of the discovery document used by the relying party
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// TokenResponse is the response of the token endpoint
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// IProvider is the interface of an OpenID Connect provider as seen by the relying party
+type IProvider interface {
+	AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error)
+	Exchange(ctx context.Context, code string, codeVerifier string) (*TokenResponse, error)
+	VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*IDTokenClaims, error)
+	Issuer() string
+}
+
+// discoveryTTL is how long the discovery document is cached
+const discoveryTTL = time.Hour
+
+// Provider talks to an OpenID Connect provider, the discovery document and the keys are fetched lazily and cached
+type Provider struct {
+	config     Config
+	httpClient *http.Client
+
+	mu           sync.Mutex
+	metadata     *Metadata
+	discoveredAt time.Time
+	keys         *keySet
+}
+
+// NewProvider returns a new provider for the config
+func NewProvider(config Config) *Provider {
+	return &Provider{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Issuer returns the configured issuer
+func (p *Provider) Issuer() string {
+	return p.config.IssuerURL
+}
+
+// Discover returns the discovery document of the provider
+func (p *Provider) Discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil && time.Since(p.discoveredAt) < discoveryTTL {
+		return p.metadata, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	metadata := new(Metadata)
+	if err := p.getJSON(ctx, wellKnown, metadata); err != nil {
+		return nil, errors.Wrap(err, "failed to discover the provider")
+	}
+
+	// the issuer must be the one configured, otherwise the discovery document can't be trusted (OIDC discovery 4.3)
+	if metadata.Issuer != p.config.IssuerURL {
+		return nil, fmt.Errorf("the provider issuer [%s] doesn't match the configured issuer [%s]", metadata.Issuer, p.config.IssuerURL)
+	}
+
+	if len(metadata.AuthorizationEndpoint) == 0 || len(metadata.TokenEndpoint) == 0 || len(metadata.JWKSURI) == 0 {
+		return nil, errors.New("the discovery document is missing an endpoint")
+	}
+
+	p.metadata = metadata
+	p.discoveredAt = time.Now()
+	if p.keys == nil || p.keys.uri != metadata.JWKSURI {
+		p.keys = newKeySet(metadata.JWKSURI, p.getJSON)
+	}
+
+	return metadata, nil
+}
+
+// AuthCodeURL returns the url the user agent is sent to, the code challenge uses S256
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "authorization endpoint is invalid")
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientId)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// Exchange redeems the authorization code at the token endpoint
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string) (*TokenResponse, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientId), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to call the token endpoint")
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the token response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	token := new(TokenResponse)
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the token response")
+	}
+
+	if len(token.IDToken) == 0 {
+		return nil, errors.New("the token response has no id_token")
+	}
+
+	return token, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", rawURL, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+var _ IProvider = (*Provider)(nil)
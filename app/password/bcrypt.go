@@ -0,0 +1,54 @@
+package password
+
+import (
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// BcryptScheme hashes with bcrypt, the hash is the usual $2a$<cost>$<salt+hash> string
+type BcryptScheme struct {
+	cost int
+}
+
+// NewBcryptScheme returns a bcrypt scheme with the cost
+func NewBcryptScheme(cost int) (*BcryptScheme, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	return &BcryptScheme{cost: cost}, nil
+}
+
+// Hash hashes the password
+func (s *BcryptScheme) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// Verify checks the password
+func (s *BcryptScheme) Verify(encoded string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Identifies tells if the hash is a bcrypt one
+func (s *BcryptScheme) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// Outdated tells if the hash was made with another cost
+func (s *BcryptScheme) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != s.cost
+}
+
+var _ IScheme = (*BcryptScheme)(nil)
@@ -0,0 +1,58 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PrefixLength is the number of hex characters of the SHA-1 hash naming the prefix files
+const PrefixLength = 5
+
+// IsBreached looks the password up in the local breached password list, k-anonymity style:
+// the list is split into files named by the first 5 hex characters of the SHA-1 hash (e.g. 21BD1.txt)
+// holding the remaining 35 characters and the count as `SUFFIX:COUNT` lines, the format of the
+// Pwned Passwords range files. Only the file of the prefix is read, a missing file means not breached.
+func IsBreached(dir string, password string, minCount int) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:PrefixLength], hash[PrefixLength:]
+
+	file, err := os.Open(filepath.Join(dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open the breached password list")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) < len(suffix) || !strings.EqualFold(line[:len(suffix)], suffix) {
+			continue
+		}
+
+		count := 1
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			if n, err := strconv.Atoi(line[i+1:]); err == nil {
+				count = n
+			}
+		}
+
+		return count >= minCount, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return false, errors.Wrap(err, "failed to read the breached password list")
+	}
+
+	return false, nil
+}
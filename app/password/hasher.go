@@ -0,0 +1,97 @@
+package password
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// the supported hashing algorithms
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// ErrUnknownHash is returned for a stored hash no scheme recognizes, e.g. the empty password of an external login account
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// IScheme is a hashing algorithm with its params, the hashes are self-describing so they carry the algorithm and the params
+type IScheme interface {
+	Hash(password string) (string, error)
+	Verify(encoded string, password string) (bool, error)
+	// Identifies tells if the hash was made by the algorithm of the scheme
+	Identifies(encoded string) bool
+	// Outdated tells if the hash was made with other params than the ones of the scheme
+	Outdated(encoded string) bool
+}
+
+// IHasher hashes new passwords with the current scheme and verifies the ones hashed by any known scheme
+type IHasher interface {
+	Hash(password string) (string, error)
+	// Verify checks the password, rehash is true when it matched a hash that should be replaced with a new one
+	Verify(encoded string, password string) (ok bool, rehash bool, err error)
+}
+
+// HasherConfig keeps the algorithm new passwords are hashed with and the params of each algorithm
+type HasherConfig struct {
+	// Algorithm is either bcrypt or argon2id
+	Algorithm  string
+	BcryptCost int
+	Argon2id   Argon2idParams
+}
+
+// Hasher is the default IHasher, it knows bcrypt and argon2id
+type Hasher struct {
+	current IScheme
+	schemes []IScheme
+}
+
+// NewHasher returns a hasher for the config, the hashes of the other algorithm are still verified and rehashed on login
+func NewHasher(config HasherConfig) (*Hasher, error) {
+	bcryptScheme, err := NewBcryptScheme(config.BcryptCost)
+	if err != nil {
+		return nil, err
+	}
+
+	argon2idScheme, err := NewArgon2idScheme(config.Argon2id)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := &Hasher{schemes: []IScheme{bcryptScheme, argon2idScheme}}
+	switch config.Algorithm {
+	case AlgorithmBcrypt:
+		hasher.current = bcryptScheme
+	case AlgorithmArgon2id:
+		hasher.current = argon2idScheme
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm [%s]", config.Algorithm)
+	}
+
+	return hasher, nil
+}
+
+// Hash hashes the password with the current scheme
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// Verify checks the password with the scheme that made the hash, a hash made by another algorithm or with outdated
+// params asks for a rehash
+func (h *Hasher) Verify(encoded string, password string) (bool, bool, error) {
+	for _, scheme := range h.schemes {
+		if !scheme.Identifies(encoded) {
+			continue
+		}
+
+		ok, err := scheme.Verify(encoded, password)
+		if err != nil || !ok {
+			return false, false, err
+		}
+
+		return true, scheme != h.current || scheme.Outdated(encoded), nil
+	}
+
+	return false, false, ErrUnknownHash
+}
+
+var _ IHasher = (*Hasher)(nil)
@@ -0,0 +1,181 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// the character classes a policy can require
+const (
+	ClassLower  = "lower"
+	ClassUpper  = "upper"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+// classNames are used in the violation messages
+var classNames = map[string]string{
+	ClassLower:  "a lowercase letter",
+	ClassUpper:  "an uppercase letter",
+	ClassDigit:  "a digit",
+	ClassSymbol: "a symbol",
+}
+
+// the rules reported in the violations
+const (
+	RuleRequired  = "required"
+	RuleMinLength = "min_length"
+	RuleMaxBytes  = "max_bytes"
+	RuleClass     = "character_class"
+	RuleEmail     = "contains_email"
+	RuleBreached  = "breached"
+)
+
+// Policy keeps the rules a new password must follow
+type Policy struct {
+	// MinLength is counted in characters
+	MinLength int
+	// MaxBytes is counted in bytes, bcrypt ignores everything after 72 bytes
+	MaxBytes int
+	// Classes are the required character classes: lower, upper, digit and symbol
+	Classes []string
+	// BreachedDir keeps the breached password hash prefix files, the check is skipped when empty
+	BreachedDir string
+	// BreachedMinCount is how many times a password must have been seen in breaches to be rejected
+	BreachedMinCount int
+}
+
+// Violation is a rule the password broke
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyError lists every rule the password broke
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+
+	return "password doesn't meet the policy: " + strings.Join(messages, ", ")
+}
+
+// Validate checks the password against every rule and returns a *PolicyError listing all the broken ones,
+// the email is the one of the account the password is for
+func (p Policy) Validate(password string, email string) error {
+	if len(password) == 0 {
+		return &PolicyError{Violations: []Violation{{Rule: RuleRequired, Message: "password is required"}}}
+	}
+
+	violations := make([]Violation, 0)
+
+	if length := len([]rune(password)); length < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("password must have at least %d characters", p.MinLength),
+		})
+	}
+
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, Violation{
+			Rule:    RuleMaxBytes,
+			Message: fmt.Sprintf("password must have at most %d bytes", p.MaxBytes),
+		})
+	}
+
+	for _, class := range p.Classes {
+		if !hasClass(password, class) {
+			violations = append(violations, Violation{
+				Rule:    RuleClass,
+				Message: fmt.Sprintf("password must contain %s", classNames[class]),
+			})
+		}
+	}
+
+	if containsEmail(password, email) {
+		violations = append(violations, Violation{
+			Rule:    RuleEmail,
+			Message: "password must not contain the email",
+		})
+	}
+
+	if len(p.BreachedDir) > 0 {
+		breached, err := IsBreached(p.BreachedDir, password, p.BreachedMinCount)
+		if err != nil {
+			// a broken list must not block the users, the other rules still apply
+			fmt.Println(err)
+		} else if breached {
+			violations = append(violations, Violation{
+				Rule:    RuleBreached,
+				Message: "password appears in a data breach, choose another one",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// ValidateClasses checks that all the classes are known
+func ValidateClasses(classes []string) error {
+	for _, class := range classes {
+		if _, ok := classNames[class]; !ok {
+			return fmt.Errorf("unknown character class [%s]", class)
+		}
+	}
+
+	return nil
+}
+
+func hasClass(password string, class string) bool {
+	for _, r := range password {
+		switch class {
+		case ClassLower:
+			if unicode.IsLower(r) {
+				return true
+			}
+		case ClassUpper:
+			if unicode.IsUpper(r) {
+				return true
+			}
+		case ClassDigit:
+			if unicode.IsDigit(r) {
+				return true
+			}
+		case ClassSymbol:
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// containsEmail checks for the whole email and for its local part when it isn't too short to matter
+func containsEmail(password string, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) == 0 {
+		return false
+	}
+
+	if strings.Contains(password, email) {
+		return true
+	}
+
+	if at := strings.LastIndex(email, "@"); at >= 3 {
+		return strings.Contains(password, email[:at])
+	}
+
+	return false
+}
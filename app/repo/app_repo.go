@@ -2,134 +2,1582 @@ package repo
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"gos/app/models"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type IAppRepo interface {
 	AddUser(ctx context.Context, user models.User) (sql.Result, error)
 	UpdateUser(ctx context.Context, user models.User) (sql.Result, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserById(ctx context.Context, userId int64) (*models.User, error)
+	UseTOTPStep(ctx context.Context, userId int64, step int64) (sql.Result, error)
+	IncrementFailedLoginAttempt(ctx context.Context, userId int64) (sql.Result, error)
+	LockLogin(ctx context.Context, userId int64, maxAttempts int, lockedUntil int64) (sql.Result, error)
+	RecordLogin(ctx context.Context, userId int64, lastLogin int64) (sql.Result, error)
+	RehashPassword(ctx context.Context, userId int64, oldHash string, newHash string) (sql.Result, error)
 
 	GetAllTasks(ctx context.Context, lastTaskId int64, userId int64, limit int) ([]models.Task, error)
 	GetTaskById(ctx context.Context, taskId int64, userId int64) (*models.Task, error)
 	AddTask(ctx context.Context, task models.Task) (sql.Result, error)
 
+	AddPasswordReset(ctx context.Context, reset models.PasswordReset) (sql.Result, error)
+	GetPasswordResetByTokenHash(ctx context.Context, tokenHash string) (*models.PasswordReset, error)
+	UsePasswordReset(ctx context.Context, resetId int64, dateUsed int64) (sql.Result, error)
+	InvalidatePasswordResets(ctx context.Context, userId int64, dateUsed int64) (sql.Result, error)
+
+	AddRecoveryCode(ctx context.Context, code models.RecoveryCode) (sql.Result, error)
+	UseRecoveryCode(ctx context.Context, userId int64, codeHash string, dateUsed int64) (sql.Result, error)
+	DeleteRecoveryCodes(ctx context.Context, userId int64) (sql.Result, error)
+
+	AddAccessToken(ctx context.Context, token models.AccessToken) (sql.Result, error)
+	GetAccessTokenByTokenHash(ctx context.Context, tokenHash string) (*models.AccessToken, error)
+	GetAccessTokens(ctx context.Context, userId int64) ([]models.AccessToken, error)
+	RevokeAccessToken(ctx context.Context, tokenId int64, userId int64, dateRevoked int64) (sql.Result, error)
+	RevokeUserAccessTokens(ctx context.Context, userId int64, dateRevoked int64) (sql.Result, error)
+	UpdateAccessTokenLastUsed(ctx context.Context, tokenId int64, lastUsed int64) (sql.Result, error)
+
+	AddOAuthClient(ctx context.Context, client models.OAuthClient) (sql.Result, error)
+	GetOAuthClientById(ctx context.Context, clientId string) (*models.OAuthClient, error)
+	GetOAuthClients(ctx context.Context, userId int64) ([]models.OAuthClient, error)
+	AddOAuthCode(ctx context.Context, code models.OAuthCode) (sql.Result, error)
+	GetOAuthCodeByCodeHash(ctx context.Context, codeHash string) (*models.OAuthCode, error)
+	UseOAuthCode(ctx context.Context, codeHash string, dateUsed int64) (sql.Result, error)
+	GetOAuthConsent(ctx context.Context, userId int64, clientId string) (*models.OAuthConsent, error)
+	SaveOAuthConsent(ctx context.Context, consent models.OAuthConsent) (sql.Result, error)
+	AddRevokedToken(ctx context.Context, tokenId string, expiresAt int64, dateRevoked int64) (sql.Result, error)
+	IsTokenRevoked(ctx context.Context, tokenId string) (bool, error)
+
+	AddUserIdentity(ctx context.Context, identity models.UserIdentity) (sql.Result, error)
+	GetUserIdentity(ctx context.Context, issuer string, subject string) (*models.UserIdentity, error)
+	UpdateUserIdentityLastLogin(ctx context.Context, identityId int64, lastLogin int64) (sql.Result, error)
+
+	SearchUsers(ctx context.Context, query string, lastUserId int64, limit int) ([]models.User, error)
+	AddAuditEntry(ctx context.Context, entry models.AuditEntry) (sql.Result, error)
+	GetAuditEntries(ctx context.Context, targetUserId int64, lastAuditId int64, limit int) ([]models.AuditEntry, error)
+
+	AddSession(ctx context.Context, session models.Session) (sql.Result, error)
+	GetSessionById(ctx context.Context, sessionId int64) (*models.Session, error)
+	GetSessions(ctx context.Context, userId int64, now int64) ([]models.Session, error)
+	UpdateSessionLastSeen(ctx context.Context, sessionId int64, lastSeen int64) (sql.Result, error)
+	RevokeSession(ctx context.Context, sessionId int64, userId int64, dateRevoked int64) (sql.Result, error)
+	RevokeUserSessions(ctx context.Context, userId int64, dateRevoked int64) (sql.Result, error)
+
+	GetUsersDueForDeletion(ctx context.Context, now int64, limit int) ([]models.User, error)
+	DeleteUser(ctx context.Context, userId int64, anonymizeTasks bool) error
+
+	GetTasksByUser(ctx context.Context, userId int64, lastTaskId int64, limit int) ([]models.Task, error)
+	GetUserSessions(ctx context.Context, userId int64) ([]models.Session, error)
+	GetUserIdentities(ctx context.Context, userId int64) ([]models.UserIdentity, error)
+	AddExportJob(ctx context.Context, job models.ExportJob) (sql.Result, error)
+	GetExportJob(ctx context.Context, jobId int64, userId int64) (*models.ExportJob, error)
+	GetExportJobs(ctx context.Context, userId int64) ([]models.ExportJob, error)
+	GetStaleExportJobs(ctx context.Context, now int64, pendingBefore int64, failedBefore int64, limit int) ([]models.ExportJob, error)
+	UpdateExportJob(ctx context.Context, job models.ExportJob) (sql.Result, error)
+	DeleteExportJob(ctx context.Context, jobId int64) (sql.Result, error)
+
+	AddSecurityEvent(ctx context.Context, event models.SecurityEvent) (sql.Result, error)
+	GetSecurityEvents(ctx context.Context, userId int64, eventType string, lastEventId int64, limit int) ([]models.SecurityEvent, error)
+	DeleteSecurityEventsBefore(ctx context.Context, before int64, limit int) (sql.Result, error)
+
+	WithTx(ctx context.Context, fn func(repo IAppRepo) error) error
+
 	Close() error
 }
 
 type AppRepo struct {
-	con               *sqlx.DB
-	createUserStm     *sql.Stmt
-	updateUserStm     *sql.Stmt
-	getUserByEmailStm *sql.Stmt
+	con     *sqlx.DB
+	dialect sqlDialect
+	// tx is set on the repo given to the function of WithTx, its statements run in the transaction
+	tx *repoTx
+	// replicas serve some of the reads when they are configured
+	replicas *replicaSet
+
+	createUserStm                  *sql.Stmt
+	updateUserStm                  *sql.Stmt
+	getUserByEmailStm              *sql.Stmt
+	getUserByIdStm                 *sql.Stmt
+	useTOTPStepStm                 *sql.Stmt
+	incrementFailedLoginAttemptStm *sql.Stmt
+	lockLoginStm                   *sql.Stmt
+	recordLoginStm                 *sql.Stmt
+	rehashPasswordStm              *sql.Stmt
 
 	getAllTaskStm  *sql.Stmt
 	getTaskByIdStm *sql.Stmt
 	addTaskStm     *sql.Stmt
+
+	addPasswordResetStm            *sql.Stmt
+	getPasswordResetByTokenHashStm *sql.Stmt
+	usePasswordResetStm            *sql.Stmt
+	invalidatePasswordResetsStm    *sql.Stmt
+
+	addRecoveryCodeStm     *sql.Stmt
+	useRecoveryCodeStm     *sql.Stmt
+	deleteRecoveryCodesStm *sql.Stmt
+
+	addAccessTokenStm            *sql.Stmt
+	getAccessTokenByTokenHashStm *sql.Stmt
+	getAccessTokensStm           *sql.Stmt
+	revokeAccessTokenStm         *sql.Stmt
+	revokeUserAccessTokensStm    *sql.Stmt
+	updateAccessTokenLastUsedStm *sql.Stmt
+
+	addOAuthClientStm         *sql.Stmt
+	getOAuthClientByIdStm     *sql.Stmt
+	getOAuthClientsStm        *sql.Stmt
+	addOAuthCodeStm           *sql.Stmt
+	getOAuthCodeByCodeHashStm *sql.Stmt
+	useOAuthCodeStm           *sql.Stmt
+	getOAuthConsentStm        *sql.Stmt
+	saveOAuthConsentStm       *sql.Stmt
+	addRevokedTokenStm        *sql.Stmt
+	isTokenRevokedStm         *sql.Stmt
+
+	addUserIdentityStm             *sql.Stmt
+	getUserIdentityStm             *sql.Stmt
+	updateUserIdentityLastLoginStm *sql.Stmt
+
+	searchUsersStm     *sql.Stmt
+	addAuditEntryStm   *sql.Stmt
+	getAuditEntriesStm *sql.Stmt
+
+	addSessionStm            *sql.Stmt
+	getSessionByIdStm        *sql.Stmt
+	getSessionsStm           *sql.Stmt
+	updateSessionLastSeenStm *sql.Stmt
+	revokeSessionStm         *sql.Stmt
+	revokeUserSessionsStm    *sql.Stmt
+
+	getUsersDueForDeletionStm   *sql.Stmt
+	deleteUserTasksStm          *sql.Stmt
+	anonymizeUserTasksStm       *sql.Stmt
+	deleteUserOAuthCodesStm     *sql.Stmt
+	deleteUserOAuthConsentsStm  *sql.Stmt
+	deleteUserOAuthClientsStm   *sql.Stmt
+	deleteUserAccessTokensStm   *sql.Stmt
+	deleteUserPasswordResetsStm *sql.Stmt
+	deleteUserIdentitiesStm     *sql.Stmt
+	deleteUserSessionsStm       *sql.Stmt
+	deleteUserStm               *sql.Stmt
+
+	getTasksByUserStm       *sql.Stmt
+	getUserSessionsStm      *sql.Stmt
+	getUserIdentitiesStm    *sql.Stmt
+	addExportJobStm         *sql.Stmt
+	getExportJobStm         *sql.Stmt
+	getExportJobsStm        *sql.Stmt
+	getStaleExportJobsStm   *sql.Stmt
+	updateExportJobStm      *sql.Stmt
+	deleteExportJobStm      *sql.Stmt
+	deleteUserExportJobsStm *sql.Stmt
+
+	addSecurityEventStm           *sql.Stmt
+	getSecurityEventsStm          *sql.Stmt
+	deleteSecurityEventsBeforeStm *sql.Stmt
+	deleteUserSecurityEventsStm   *sql.Stmt
+}
+
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type DbConfig struct {
+	// Driver selects the implementation of the repo: mysql, postgres, sqlite or memory, the scheme of the DSN wins when it is set
+	Driver string
+	// DSN is the data source name prefixed by the scheme of the driver, like sqlite:///var/lib/gos/gos.db,
+	// the host, port, name, user and password are used when it is empty
+	DSN string
+	// BusyTimeout is how long sqlite waits for the lock of another writer before failing
+	BusyTimeout time.Duration
+	// AutoMigrate applies the pending migrations of the schema on start
+	AutoMigrate  bool
+	Host         string `required:"true"`
+	Port         int    `required:"true"`
+	DatabaseName string `required:"true"`
+	User         string `required:"true"`
+	Password     string `required:"true"`
+
+	// MaxOpenConns caps the connections to mysql or postgres, 0 is unlimited
+	MaxOpenConns int
+	// MaxIdleConns is how many idle connections are kept, 0 keeps the default of database/sql
+	MaxIdleConns int
+	// ConnMaxLifetime closes the connections older than it, 0 keeps them forever
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime closes the connections idle for longer than it, 0 keeps them forever
+	ConnMaxIdleTime time.Duration
+	// ConnectRetries is how many times a database which isn't ready yet is retried on start
+	ConnectRetries int
+	// ConnectBackoff is the first wait between the retries, it doubles up to maxConnectBackoff
+	ConnectBackoff time.Duration
+
+	// TLS is the TLS mode of the host settings: true, false or skip-verify, empty keeps the default of the driver
+	TLS string
+	// TLSCA is the PEM file of the CA of the server certificate, it verifies the server against it alone
+	TLSCA string
+	// Charset is set with SET NAMES on every mysql connection, it replaces the collation by the default one of the charset
+	Charset string
+	// Collation is the collation of the mysql connections
+	Collation string
+	// ParseTime scans the mysql DATE and DATETIME columns as time.Time, the repo itself stores unix seconds
+	ParseTime bool
+
+	// Replicas are the DSNs of the read replicas, of the driver of the primary, serving the task reads and the user
+	// lookups by email. The other settings apply to them too.
+	Replicas []string
+	// ReplicaCheckInterval is how often the replicas are pinged, a replica down serves no reads until it is back.
+	// Zero disables the pings, a read tries a replica down again after a delay instead
+	ReplicaCheckInterval time.Duration
+	// ReadYourWritesWindow is how long the reads of a user go to the primary after they wrote, it should be longer
+	// than the replication lag
+	ReadYourWritesWindow time.Duration
+}
+
+const createUserStatement = `insert into GOS_USER (name, email, password, last_login, failed_login_attempt, date_created, date_updated, token_version, email_verified, date_verification_sent, totp_secret, totp_enabled, totp_last_step, role, disabled, deletion_scheduled_at, locked_until) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+const updateUserStatement = `update GOS_USER set name = ?, email =?, password = ?, last_login = ?, failed_login_attempt = ? , date_created = ?, date_updated = ?, token_version = ?, email_verified = ?, date_verification_sent = ?, totp_secret = ?, totp_enabled = ?, totp_last_step = ?, role = ?, disabled = ?, deletion_scheduled_at = ?, locked_until = ? where user_id = ?`
+const getUserByEmailStatement = `select user_id, name, email, password, last_login, failed_login_attempt, date_created, date_updated, token_version, email_verified, date_verification_sent, totp_secret, totp_enabled, totp_last_step, role, disabled, deletion_scheduled_at, locked_until from GOS_USER where email = ?`
+const getUserByIdStatement = `select user_id, name, email, password, last_login, failed_login_attempt, date_created, date_updated, token_version, email_verified, date_verification_sent, totp_secret, totp_enabled, totp_last_step, role, disabled, deletion_scheduled_at, locked_until from GOS_USER where user_id = ?`
+const useTOTPStepStatement = `update GOS_USER set totp_last_step = ? where user_id = ? and totp_last_step < ?`
+const incrementFailedLoginAttemptStatement = `update GOS_USER set failed_login_attempt = failed_login_attempt + 1 where user_id = ?`
+const lockLoginStatement = `update GOS_USER set failed_login_attempt = 0, locked_until = ? where user_id = ? and failed_login_attempt >= ?`
+const recordLoginStatement = `update GOS_USER set last_login = ?, failed_login_attempt = 0, locked_until = 0 where user_id = ?`
+const rehashPasswordStatement = `update GOS_USER set password = ? where user_id = ? and password = ?`
+
+const insertTaskStatement = `insert into GOS_TASK (user_id, title, description, date_created, date_updated, due_date, date_complete) VALUES (?, ?, ?, ?, ?, ?, ?)`
+const getTasksStatement = `select task_id, user_id, title, description, date_created, date_updated, due_date, date_complete from GOS_TASK where task_id > ? and user_id = ? order by task_id desc limit ?`
+const getTaskByIdStatement = `select task_id, user_id, title, description, date_created, date_updated, due_date, date_complete from GOS_TASK where task_id = ? and user_id = ?`
+
+const insertPasswordResetStatement = `insert into GOS_PASSWORD_RESET (user_id, token_hash, date_created, expires_at, date_used) VALUES (?, ?, ?, ?, ?)`
+const getPasswordResetByTokenHashStatement = `select reset_id, user_id, token_hash, date_created, expires_at, date_used from GOS_PASSWORD_RESET where token_hash = ?`
+const usePasswordResetStatement = `update GOS_PASSWORD_RESET set date_used = ? where reset_id = ? and date_used = 0`
+const invalidatePasswordResetsStatement = `update GOS_PASSWORD_RESET set date_used = ? where user_id = ? and date_used = 0`
+
+const insertRecoveryCodeStatement = `insert into GOS_RECOVERY_CODE (user_id, code_hash, date_created, date_used) VALUES (?, ?, ?, ?)`
+const useRecoveryCodeStatement = `update GOS_RECOVERY_CODE set date_used = ? where user_id = ? and code_hash = ? and date_used = 0`
+const deleteRecoveryCodesStatement = `delete from GOS_RECOVERY_CODE where user_id = ?`
+
+const insertAccessTokenStatement = `insert into GOS_ACCESS_TOKEN (user_id, name, token_hash, scopes, date_created, expires_at, last_used, date_revoked) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+const getAccessTokenByTokenHashStatement = `select token_id, user_id, name, token_hash, scopes, date_created, expires_at, last_used, date_revoked from GOS_ACCESS_TOKEN where token_hash = ?`
+const getAccessTokensStatement = `select token_id, user_id, name, token_hash, scopes, date_created, expires_at, last_used, date_revoked from GOS_ACCESS_TOKEN where user_id = ? and date_revoked = 0 order by token_id desc`
+const revokeAccessTokenStatement = `update GOS_ACCESS_TOKEN set date_revoked = ? where token_id = ? and user_id = ? and date_revoked = 0`
+const revokeUserAccessTokensStatement = `update GOS_ACCESS_TOKEN set date_revoked = ? where user_id = ? and date_revoked = 0`
+const updateAccessTokenLastUsedStatement = `update GOS_ACCESS_TOKEN set last_used = ? where token_id = ?`
+
+const insertOAuthClientStatement = `insert into GOS_OAUTH_CLIENT (client_id, user_id, name, client_secret_hash, redirect_uris, scopes, confidential, date_created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+const getOAuthClientByIdStatement = `select client_id, user_id, name, client_secret_hash, redirect_uris, scopes, confidential, date_created from GOS_OAUTH_CLIENT where client_id = ?`
+const getOAuthClientsStatement = `select client_id, user_id, name, client_secret_hash, redirect_uris, scopes, confidential, date_created from GOS_OAUTH_CLIENT where user_id = ? order by date_created desc`
+const insertOAuthCodeStatement = `insert into GOS_OAUTH_CODE (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, code_challenge_method, expires_at, date_used) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+const getOAuthCodeByCodeHashStatement = `select code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, code_challenge_method, expires_at, date_used from GOS_OAUTH_CODE where code_hash = ?`
+const useOAuthCodeStatement = `update GOS_OAUTH_CODE set date_used = ? where code_hash = ? and date_used = 0`
+const getOAuthConsentStatement = `select user_id, client_id, scopes, date_created, date_updated from GOS_OAUTH_CONSENT where user_id = ? and client_id = ?`
+const saveOAuthConsentStatement = `insert into GOS_OAUTH_CONSENT (user_id, client_id, scopes, date_created, date_updated) VALUES (?, ?, ?, ?, ?) on duplicate key update scopes = values(scopes), date_updated = values(date_updated)`
+const insertRevokedTokenStatement = `insert ignore into GOS_REVOKED_TOKEN (token_id, expires_at, date_revoked) VALUES (?, ?, ?)`
+const isTokenRevokedStatement = `select count(*) from GOS_REVOKED_TOKEN where token_id = ?`
+
+const insertUserIdentityStatement = `insert into GOS_USER_IDENTITY (user_id, issuer, subject, email, date_created, last_login) VALUES (?, ?, ?, ?, ?, ?)`
+const getUserIdentityStatement = `select identity_id, user_id, issuer, subject, email, date_created, last_login from GOS_USER_IDENTITY where issuer = ? and subject = ?`
+const updateUserIdentityLastLoginStatement = `update GOS_USER_IDENTITY set last_login = ? where identity_id = ?`
+
+const searchUsersStatement = `select user_id, name, email, password, last_login, failed_login_attempt, date_created, date_updated, token_version, email_verified, date_verification_sent, totp_secret, totp_enabled, totp_last_step, role, disabled, deletion_scheduled_at, locked_until from GOS_USER where user_id > ? and (? = '' or email like ? or name like ?) order by user_id limit ?`
+const insertAuditEntryStatement = `insert into GOS_AUDIT_LOG (actor_user_id, action, target_user_id, details, ip, date_created) VALUES (?, ?, ?, ?, ?, ?)`
+const getAuditEntriesStatement = `select audit_id, actor_user_id, action, target_user_id, details, ip, date_created from GOS_AUDIT_LOG where audit_id > ? and (? = 0 or target_user_id = ?) order by audit_id limit ?`
+
+const insertSessionStatement = `insert into GOS_SESSION (user_id, device_name, user_agent, ip, date_created, last_seen, expires_at, date_revoked) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+const getSessionByIdStatement = `select session_id, user_id, device_name, user_agent, ip, date_created, last_seen, expires_at, date_revoked from GOS_SESSION where session_id = ?`
+const getSessionsStatement = `select session_id, user_id, device_name, user_agent, ip, date_created, last_seen, expires_at, date_revoked from GOS_SESSION where user_id = ? and date_revoked = 0 and expires_at > ? order by last_seen desc`
+const updateSessionLastSeenStatement = `update GOS_SESSION set last_seen = ? where session_id = ?`
+const revokeSessionStatement = `update GOS_SESSION set date_revoked = ? where session_id = ? and user_id = ? and date_revoked = 0`
+const revokeUserSessionsStatement = `update GOS_SESSION set date_revoked = ? where user_id = ? and date_revoked = 0`
+
+const getUsersDueForDeletionStatement = `select user_id, name, email, password, last_login, failed_login_attempt, date_created, date_updated, token_version, email_verified, date_verification_sent, totp_secret, totp_enabled, totp_last_step, role, disabled, deletion_scheduled_at, locked_until from GOS_USER where deletion_scheduled_at > 0 and deletion_scheduled_at <= ? order by user_id limit ?`
+const deleteUserTasksStatement = `delete from GOS_TASK where user_id = ?`
+const anonymizeUserTasksStatement = `update GOS_TASK set user_id = null where user_id = ?`
+const deleteUserOAuthCodesStatement = `delete from GOS_OAUTH_CODE where user_id = ? or client_id in (select client_id from GOS_OAUTH_CLIENT where user_id = ?)`
+const deleteUserOAuthConsentsStatement = `delete from GOS_OAUTH_CONSENT where user_id = ? or client_id in (select client_id from GOS_OAUTH_CLIENT where user_id = ?)`
+const deleteUserOAuthClientsStatement = `delete from GOS_OAUTH_CLIENT where user_id = ?`
+const deleteUserAccessTokensStatement = `delete from GOS_ACCESS_TOKEN where user_id = ?`
+const deleteUserPasswordResetsStatement = `delete from GOS_PASSWORD_RESET where user_id = ?`
+const deleteUserIdentitiesStatement = `delete from GOS_USER_IDENTITY where user_id = ?`
+const deleteUserSessionsStatement = `delete from GOS_SESSION where user_id = ?`
+const deleteUserStatement = `delete from GOS_USER where user_id = ?`
+
+const getTasksByUserStatement = `select task_id, user_id, title, description, date_created, date_updated, due_date, date_complete from GOS_TASK where user_id = ? and task_id > ? order by task_id limit ?`
+const getUserSessionsStatement = `select session_id, user_id, device_name, user_agent, ip, date_created, last_seen, expires_at, date_revoked from GOS_SESSION where user_id = ? order by session_id`
+const getUserIdentitiesStatement = `select identity_id, user_id, issuer, subject, email, date_created, last_login from GOS_USER_IDENTITY where user_id = ? order by identity_id`
+const insertExportJobStatement = `insert into GOS_EXPORT_JOB (user_id, status, error, date_created, date_completed, expires_at) VALUES (?, ?, ?, ?, ?, ?)`
+const getExportJobStatement = `select job_id, user_id, status, error, date_created, date_completed, expires_at from GOS_EXPORT_JOB where job_id = ? and user_id = ?`
+const getExportJobsStatement = `select job_id, user_id, status, error, date_created, date_completed, expires_at from GOS_EXPORT_JOB where user_id = ? order by job_id desc`
+const getStaleExportJobsStatement = `select job_id, user_id, status, error, date_created, date_completed, expires_at from GOS_EXPORT_JOB where (status = 'done' and expires_at <= ?) or (status = 'pending' and date_created <= ?) or (status = 'failed' and date_created <= ?) order by job_id limit ?`
+const updateExportJobStatement = `update GOS_EXPORT_JOB set status = ?, error = ?, date_completed = ?, expires_at = ? where job_id = ?`
+const deleteExportJobStatement = `delete from GOS_EXPORT_JOB where job_id = ?`
+const deleteUserExportJobsStatement = `delete from GOS_EXPORT_JOB where user_id = ?`
+
+const insertSecurityEventStatement = `insert into GOS_SECURITY_EVENT (user_id, type, details, ip, user_agent, date_created) VALUES (?, ?, ?, ?, ?, ?)`
+const getSecurityEventsStatement = `select event_id, user_id, type, details, ip, user_agent, date_created from GOS_SECURITY_EVENT where event_id > ? and (? = 0 or user_id = ?) and (? = '' or type = ?) order by event_id limit ?`
+const deleteSecurityEventsBeforeStatement = `delete from GOS_SECURITY_EVENT where date_created < ? limit ?`
+const deleteUserSecurityEventsStatement = `delete from GOS_SECURITY_EVENT where user_id = ?`
+
+func connectMySQL(dbConfig DbConfig) (*sqlx.DB, error) {
+	dataSourceName, err := dataStoreName(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return connect("mysql", dataSourceName, dbConfig, mysqlDialect)
+}
+
+// mysqlDialect runs the statements as they are
+var mysqlDialect = sqlDialect{
+	rewrite: func(statement string) string {
+		return statement
+	},
+	isDuplicate: func(err error) bool {
+		mysqlErr, ok := err.(*mysql.MySQLError)
+		return ok && mysqlErr.Number == 1062
+	},
+	isForeignKey: func(err error) bool {
+		mysqlErr, ok := err.(*mysql.MySQLError)
+		return ok && mysqlErr.Number == 1452
+	},
+	isUnavailable: func(err error) bool {
+		// 1040 is too many connections, 1053 a server shutting down
+		mysqlErr, ok := err.(*mysql.MySQLError)
+		return err == mysql.ErrInvalidConn || ok && (mysqlErr.Number == 1040 || mysqlErr.Number == 1053)
+	},
+	isRetryable: func(err error) bool {
+		// 1213 is a deadlock, 1205 a lock wait timeout
+		mysqlErr, ok := err.(*mysql.MySQLError)
+		return ok && (mysqlErr.Number == 1213 || mysqlErr.Number == 1205)
+	},
+}
+
+// sqlDialect tells the differences of a database from mysql to the repo
+type sqlDialect struct {
+	// rewrite returns the statement the database understands for a mysql one
+	rewrite func(statement string) string
+	// returningIds is set when the inserts return the id of the row instead of the last insert id of the result
+	returningIds bool
+	// isDuplicate reports whether err is the error of a duplicate primary or unique key
+	isDuplicate func(err error) bool
+	// isForeignKey reports whether err is the error of a reference to a row which doesn't exist
+	isForeignKey func(err error) bool
+	// isUnavailable reports whether err tells the database can't serve the call right now, besides the broken connections
+	isUnavailable func(err error) bool
+	// isRetryable reports whether err is a deadlock or a serialization failure, the transaction may succeed when run again
+	isRetryable func(err error) bool
+}
+
+// insertResult is the result of an insert returning the id of its row
+type insertResult struct {
+	id int64
 }
 
-type RowScanner interface {
-	Scan(dest ...interface{}) error
+func (r insertResult) LastInsertId() (int64, error) {
+	return r.id, nil
+}
+
+func (r insertResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+// insert runs an insert into a table with an auto increment id, the result has the id of the row on every database
+func (r *AppRepo) insert(ctx context.Context, stm *sql.Stmt, args ...interface{}) (sql.Result, error) {
+	if !r.dialect.returningIds {
+		return r.exec(ctx, stm, args...)
+	}
+
+	var id int64
+	if err := r.stmt(ctx, stm).QueryRowContext(ctx, args...).Scan(&id); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return insertResult{id: id}, nil
+}
+
+// exec runs a statement, its error is classified by dbError
+func (r *AppRepo) exec(ctx context.Context, stm *sql.Stmt, args ...interface{}) (sql.Result, error) {
+	result, err := r.stmt(ctx, stm).ExecContext(ctx, args...)
+	return result, r.dbError(ctx, err)
+}
+
+// prepareAppRepo prepares the statements on the connection, the dialect rewrites the statements the database doesn't understand
+func prepareAppRepo(con *sqlx.DB, dialect sqlDialect) (appRepo *AppRepo, err error) {
+	// the statements prepared before a failure are closed, the connection is left to the caller
+	var prepared []*sql.Stmt
+	defer func() {
+		if err != nil {
+			for _, stm := range prepared {
+				stm.Close()
+			}
+		}
+	}()
+
+	prepare := func(statement string) (*sql.Stmt, error) {
+		stm, err := con.Prepare(dialect.rewrite(statement))
+		if err == nil {
+			prepared = append(prepared, stm)
+		}
+
+		return stm, err
+	}
+
+	createUserStm, err := prepare(createUserStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	updateUserStm, err := prepare(updateUserStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getUserByEmailStm, err := prepare(getUserByEmailStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getUserByIdStm, err := prepare(getUserByIdStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	useTOTPStepStm, err := prepare(useTOTPStepStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	incrementFailedLoginAttemptStm, err := prepare(incrementFailedLoginAttemptStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	lockLoginStm, err := prepare(lockLoginStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	recordLoginStm, err := prepare(recordLoginStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	rehashPasswordStm, err := prepare(rehashPasswordStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getAllTaskStm, err := prepare(getTasksStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getTaskByIdStm, err := prepare(getTaskByIdStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addTaskStm, err := prepare(insertTaskStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addPasswordResetStm, err := prepare(insertPasswordResetStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getPasswordResetByTokenHashStm, err := prepare(getPasswordResetByTokenHashStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	usePasswordResetStm, err := prepare(usePasswordResetStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	invalidatePasswordResetsStm, err := prepare(invalidatePasswordResetsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addRecoveryCodeStm, err := prepare(insertRecoveryCodeStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	useRecoveryCodeStm, err := prepare(useRecoveryCodeStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteRecoveryCodesStm, err := prepare(deleteRecoveryCodesStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addAccessTokenStm, err := prepare(insertAccessTokenStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getAccessTokenByTokenHashStm, err := prepare(getAccessTokenByTokenHashStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getAccessTokensStm, err := prepare(getAccessTokensStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	revokeAccessTokenStm, err := prepare(revokeAccessTokenStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	revokeUserAccessTokensStm, err := prepare(revokeUserAccessTokensStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	updateAccessTokenLastUsedStm, err := prepare(updateAccessTokenLastUsedStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addOAuthClientStm, err := prepare(insertOAuthClientStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getOAuthClientByIdStm, err := prepare(getOAuthClientByIdStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getOAuthClientsStm, err := prepare(getOAuthClientsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addOAuthCodeStm, err := prepare(insertOAuthCodeStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getOAuthCodeByCodeHashStm, err := prepare(getOAuthCodeByCodeHashStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	useOAuthCodeStm, err := prepare(useOAuthCodeStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getOAuthConsentStm, err := prepare(getOAuthConsentStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	saveOAuthConsentStm, err := prepare(saveOAuthConsentStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addRevokedTokenStm, err := prepare(insertRevokedTokenStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	isTokenRevokedStm, err := prepare(isTokenRevokedStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addUserIdentityStm, err := prepare(insertUserIdentityStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getUserIdentityStm, err := prepare(getUserIdentityStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	updateUserIdentityLastLoginStm, err := prepare(updateUserIdentityLastLoginStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	searchUsersStm, err := prepare(searchUsersStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addAuditEntryStm, err := prepare(insertAuditEntryStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getAuditEntriesStm, err := prepare(getAuditEntriesStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addSessionStm, err := prepare(insertSessionStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getSessionByIdStm, err := prepare(getSessionByIdStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getSessionsStm, err := prepare(getSessionsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	updateSessionLastSeenStm, err := prepare(updateSessionLastSeenStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	revokeSessionStm, err := prepare(revokeSessionStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	revokeUserSessionsStm, err := prepare(revokeUserSessionsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getUsersDueForDeletionStm, err := prepare(getUsersDueForDeletionStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserTasksStm, err := prepare(deleteUserTasksStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	anonymizeUserTasksStm, err := prepare(anonymizeUserTasksStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserOAuthCodesStm, err := prepare(deleteUserOAuthCodesStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserOAuthConsentsStm, err := prepare(deleteUserOAuthConsentsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserOAuthClientsStm, err := prepare(deleteUserOAuthClientsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserAccessTokensStm, err := prepare(deleteUserAccessTokensStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserPasswordResetsStm, err := prepare(deleteUserPasswordResetsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserIdentitiesStm, err := prepare(deleteUserIdentitiesStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserSessionsStm, err := prepare(deleteUserSessionsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserStm, err := prepare(deleteUserStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getTasksByUserStm, err := prepare(getTasksByUserStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getUserSessionsStm, err := prepare(getUserSessionsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getUserIdentitiesStm, err := prepare(getUserIdentitiesStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addExportJobStm, err := prepare(insertExportJobStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getExportJobStm, err := prepare(getExportJobStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getExportJobsStm, err := prepare(getExportJobsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getStaleExportJobsStm, err := prepare(getStaleExportJobsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	updateExportJobStm, err := prepare(updateExportJobStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteExportJobStm, err := prepare(deleteExportJobStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserExportJobsStm, err := prepare(deleteUserExportJobsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	addSecurityEventStm, err := prepare(insertSecurityEventStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	getSecurityEventsStm, err := prepare(getSecurityEventsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteSecurityEventsBeforeStm, err := prepare(deleteSecurityEventsBeforeStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	deleteUserSecurityEventsStm, err := prepare(deleteUserSecurityEventsStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppRepo{
+		con:                            con,
+		dialect:                        dialect,
+		createUserStm:                  createUserStm,
+		updateUserStm:                  updateUserStm,
+		getUserByEmailStm:              getUserByEmailStm,
+		getUserByIdStm:                 getUserByIdStm,
+		useTOTPStepStm:                 useTOTPStepStm,
+		incrementFailedLoginAttemptStm: incrementFailedLoginAttemptStm,
+		lockLoginStm:                   lockLoginStm,
+		recordLoginStm:                 recordLoginStm,
+		rehashPasswordStm:              rehashPasswordStm,
+		getAllTaskStm:                  getAllTaskStm,
+		getTaskByIdStm:                 getTaskByIdStm,
+		addTaskStm:                     addTaskStm,
+
+		addPasswordResetStm:            addPasswordResetStm,
+		getPasswordResetByTokenHashStm: getPasswordResetByTokenHashStm,
+		usePasswordResetStm:            usePasswordResetStm,
+		invalidatePasswordResetsStm:    invalidatePasswordResetsStm,
+
+		addRecoveryCodeStm:     addRecoveryCodeStm,
+		useRecoveryCodeStm:     useRecoveryCodeStm,
+		deleteRecoveryCodesStm: deleteRecoveryCodesStm,
+
+		addAccessTokenStm:            addAccessTokenStm,
+		getAccessTokenByTokenHashStm: getAccessTokenByTokenHashStm,
+		getAccessTokensStm:           getAccessTokensStm,
+		revokeAccessTokenStm:         revokeAccessTokenStm,
+		revokeUserAccessTokensStm:    revokeUserAccessTokensStm,
+		updateAccessTokenLastUsedStm: updateAccessTokenLastUsedStm,
+
+		addOAuthClientStm:         addOAuthClientStm,
+		getOAuthClientByIdStm:     getOAuthClientByIdStm,
+		getOAuthClientsStm:        getOAuthClientsStm,
+		addOAuthCodeStm:           addOAuthCodeStm,
+		getOAuthCodeByCodeHashStm: getOAuthCodeByCodeHashStm,
+		useOAuthCodeStm:           useOAuthCodeStm,
+		getOAuthConsentStm:        getOAuthConsentStm,
+		saveOAuthConsentStm:       saveOAuthConsentStm,
+		addRevokedTokenStm:        addRevokedTokenStm,
+		isTokenRevokedStm:         isTokenRevokedStm,
+
+		addUserIdentityStm:             addUserIdentityStm,
+		getUserIdentityStm:             getUserIdentityStm,
+		updateUserIdentityLastLoginStm: updateUserIdentityLastLoginStm,
+
+		searchUsersStm:     searchUsersStm,
+		addAuditEntryStm:   addAuditEntryStm,
+		getAuditEntriesStm: getAuditEntriesStm,
+
+		addSessionStm:            addSessionStm,
+		getSessionByIdStm:        getSessionByIdStm,
+		getSessionsStm:           getSessionsStm,
+		updateSessionLastSeenStm: updateSessionLastSeenStm,
+		revokeSessionStm:         revokeSessionStm,
+		revokeUserSessionsStm:    revokeUserSessionsStm,
+
+		getUsersDueForDeletionStm:   getUsersDueForDeletionStm,
+		deleteUserTasksStm:          deleteUserTasksStm,
+		anonymizeUserTasksStm:       anonymizeUserTasksStm,
+		deleteUserOAuthCodesStm:     deleteUserOAuthCodesStm,
+		deleteUserOAuthConsentsStm:  deleteUserOAuthConsentsStm,
+		deleteUserOAuthClientsStm:   deleteUserOAuthClientsStm,
+		deleteUserAccessTokensStm:   deleteUserAccessTokensStm,
+		deleteUserPasswordResetsStm: deleteUserPasswordResetsStm,
+		deleteUserIdentitiesStm:     deleteUserIdentitiesStm,
+		deleteUserSessionsStm:       deleteUserSessionsStm,
+		deleteUserStm:               deleteUserStm,
+
+		getTasksByUserStm:       getTasksByUserStm,
+		getUserSessionsStm:      getUserSessionsStm,
+		getUserIdentitiesStm:    getUserIdentitiesStm,
+		addExportJobStm:         addExportJobStm,
+		getExportJobStm:         getExportJobStm,
+		getExportJobsStm:        getExportJobsStm,
+		getStaleExportJobsStm:   getStaleExportJobsStm,
+		updateExportJobStm:      updateExportJobStm,
+		deleteExportJobStm:      deleteExportJobStm,
+		deleteUserExportJobsStm: deleteUserExportJobsStm,
+
+		addSecurityEventStm:           addSecurityEventStm,
+		getSecurityEventsStm:          getSecurityEventsStm,
+		deleteSecurityEventsBeforeStm: deleteSecurityEventsBeforeStm,
+		deleteUserSecurityEventsStm:   deleteUserSecurityEventsStm,
+	}, nil
+}
+
+func (r *AppRepo) AddUser(ctx context.Context, user models.User) (sql.Result, error) {
+	r.replicas.pin(emailKey(user.Email))
+	return r.insert(ctx, r.createUserStm, user.Name, user.Email, user.Password, user.LastLogin, user.FailedLoginAttempt, user.DateCreated, user.DateUpdated, user.TokenVersion, user.EmailVerified, user.DateVerificationSent,
+		user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, user.Role, user.Disabled, user.DeletionScheduledAt, user.LockedUntil)
+}
+
+func (r *AppRepo) UpdateUser(ctx context.Context, user models.User) (sql.Result, error) {
+	r.replicas.pin(accountKey(user.UserId), emailKey(user.Email))
+	return r.exec(ctx, r.updateUserStm, user.Name, user.Email, user.Password, user.LastLogin, user.FailedLoginAttempt, user.DateCreated, user.DateUpdated, user.TokenVersion, user.EmailVerified, user.DateVerificationSent,
+		user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, user.Role, user.Disabled, user.DeletionScheduledAt, user.LockedUntil, user.UserId)
+}
+
+// GetUserByEmail may be served by a replica, unless the email or the user found was written within the pin window:
+// a user whose email just changed is still found by the old one on a replica, the primary is read again then
+func (r *AppRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if replica := r.reader(ctx, emailKey(email)); replica != nil {
+		user, err := replica.repo.GetUserByEmail(ctx, email)
+		if !r.replicas.fellBack(replica, err) && (err != nil || !r.replicas.isPinned(accountKey(user.UserId))) {
+			return user, err
+		}
+	}
+
+	row := r.stmt(ctx, r.getUserByEmailStm).QueryRowContext(ctx, email)
+
+	user, err := scanRowUser(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("user")
+	case nil:
+		return user, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+func (r *AppRepo) GetUserById(ctx context.Context, userId int64) (*models.User, error) {
+	row := r.stmt(ctx, r.getUserByIdStm).QueryRowContext(ctx, userId)
+
+	user, err := scanRowUser(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("user")
+	case nil:
+		return user, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+// UseTOTPStep records the step of a used totp code, no rows are affected when the same or a later step was used,
+// so a code is accepted once even by concurrent logins
+func (r *AppRepo) UseTOTPStep(ctx context.Context, userId int64, step int64) (sql.Result, error) {
+	r.replicas.pin(accountKey(userId))
+	return r.exec(ctx, r.useTOTPStepStm, step, userId, step)
+}
+
+// IncrementFailedLoginAttempt counts a failed login in the row itself, so concurrent attempts are not lost
+func (r *AppRepo) IncrementFailedLoginAttempt(ctx context.Context, userId int64) (sql.Result, error) {
+	r.replicas.pin(accountKey(userId))
+	return r.exec(ctx, r.incrementFailedLoginAttemptStm, userId)
+}
+
+// LockLogin locks the login until lockedUntil and resets the count when the stored count reached maxAttempts,
+// no rows are affected otherwise, so of concurrent attempts only one locks
+func (r *AppRepo) LockLogin(ctx context.Context, userId int64, maxAttempts int, lockedUntil int64) (sql.Result, error) {
+	r.replicas.pin(accountKey(userId))
+	return r.exec(ctx, r.lockLoginStm, lockedUntil, userId, maxAttempts)
+}
+
+// RecordLogin sets the last login and clears the failed attempts and the lockout
+func (r *AppRepo) RecordLogin(ctx context.Context, userId int64, lastLogin int64) (sql.Result, error) {
+	r.replicas.pin(accountKey(userId))
+	return r.exec(ctx, r.recordLoginStm, lastLogin, userId)
+}
+
+// RehashPassword replaces the password hash while it is still oldHash, no rows are affected when the password
+// was changed meanwhile
+func (r *AppRepo) RehashPassword(ctx context.Context, userId int64, oldHash string, newHash string) (sql.Result, error) {
+	r.replicas.pin(accountKey(userId))
+	return r.exec(ctx, r.rehashPasswordStm, newHash, userId, oldHash)
+}
+
+// GetAllTasks may be served by a replica
+func (r *AppRepo) GetAllTasks(ctx context.Context, lastTaskId int64, userId int64, limit int) ([]models.Task, error) {
+	if replica := r.reader(ctx, userKey(userId)); replica != nil {
+		tasks, err := replica.repo.GetAllTasks(ctx, lastTaskId, userId, limit)
+		if !r.replicas.fellBack(replica, err) {
+			return tasks, err
+		}
+	}
+
+	rows, err := r.stmt(ctx, r.getAllTaskStm).QueryContext(ctx, lastTaskId, userId, limit)
+
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	tasks := make([]models.Task, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		task, err := scanRowTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		tasks = append(tasks, *task)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return tasks, nil
+}
+
+// GetTaskById may be served by a replica
+func (r *AppRepo) GetTaskById(ctx context.Context, taskId int64, userId int64) (*models.Task, error) {
+	if replica := r.reader(ctx, userKey(userId)); replica != nil {
+		task, err := replica.repo.GetTaskById(ctx, taskId, userId)
+		if !r.replicas.fellBack(replica, err) {
+			return task, err
+		}
+	}
+
+	row := r.stmt(ctx, r.getTaskByIdStm).QueryRowContext(ctx, taskId, userId)
+
+	task, err := scanRowTask(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("task")
+	case nil:
+		return task, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+func (r *AppRepo) AddTask(ctx context.Context, task models.Task) (sql.Result, error) {
+	r.replicas.pin(userKey(task.UserId))
+	return r.insert(ctx, r.addTaskStm, task.UserId, task.Title, task.Description, task.DateCreated, task.DateUpdated, task.DueDate, task.DateCompleted)
+}
+
+func (r *AppRepo) AddPasswordReset(ctx context.Context, reset models.PasswordReset) (sql.Result, error) {
+	return r.insert(ctx, r.addPasswordResetStm, reset.UserId, reset.TokenHash, reset.DateCreated, reset.ExpiresAt, reset.DateUsed)
+}
+
+func (r *AppRepo) GetPasswordResetByTokenHash(ctx context.Context, tokenHash string) (*models.PasswordReset, error) {
+	row := r.stmt(ctx, r.getPasswordResetByTokenHashStm).QueryRowContext(ctx, tokenHash)
+
+	reset, err := scanRowPasswordReset(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("password reset")
+	case nil:
+		return reset, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+// UsePasswordReset marks a reset as used, no rows are affected if it was already used
+func (r *AppRepo) UsePasswordReset(ctx context.Context, resetId int64, dateUsed int64) (sql.Result, error) {
+	return r.exec(ctx, r.usePasswordResetStm, dateUsed, resetId)
+}
+
+// InvalidatePasswordResets marks all the unused resets of a user as used
+func (r *AppRepo) InvalidatePasswordResets(ctx context.Context, userId int64, dateUsed int64) (sql.Result, error) {
+	return r.exec(ctx, r.invalidatePasswordResetsStm, dateUsed, userId)
+}
+
+func (r *AppRepo) AddRecoveryCode(ctx context.Context, code models.RecoveryCode) (sql.Result, error) {
+	return r.insert(ctx, r.addRecoveryCodeStm, code.UserId, code.CodeHash, code.DateCreated, code.DateUsed)
+}
+
+// UseRecoveryCode marks a recovery code as used, no rows are affected if it doesn't exist or was already used
+func (r *AppRepo) UseRecoveryCode(ctx context.Context, userId int64, codeHash string, dateUsed int64) (sql.Result, error) {
+	return r.exec(ctx, r.useRecoveryCodeStm, dateUsed, userId, codeHash)
+}
+
+func (r *AppRepo) DeleteRecoveryCodes(ctx context.Context, userId int64) (sql.Result, error) {
+	return r.exec(ctx, r.deleteRecoveryCodesStm, userId)
+}
+
+func (r *AppRepo) AddAccessToken(ctx context.Context, token models.AccessToken) (sql.Result, error) {
+	return r.insert(ctx, r.addAccessTokenStm, token.UserId, token.Name, token.TokenHash, strings.Join(token.Scopes, " "), token.DateCreated,
+		token.ExpiresAt, token.LastUsed, token.DateRevoked)
+}
+
+func (r *AppRepo) GetAccessTokenByTokenHash(ctx context.Context, tokenHash string) (*models.AccessToken, error) {
+	row := r.stmt(ctx, r.getAccessTokenByTokenHashStm).QueryRowContext(ctx, tokenHash)
+
+	token, err := scanRowAccessToken(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("access token")
+	case nil:
+		return token, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+// GetAccessTokens gets all the access tokens of a user which are not revoked
+func (r *AppRepo) GetAccessTokens(ctx context.Context, userId int64) ([]models.AccessToken, error) {
+	rows, err := r.stmt(ctx, r.getAccessTokensStm).QueryContext(ctx, userId)
+
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	tokens := make([]models.AccessToken, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		token, err := scanRowAccessToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		tokens = append(tokens, *token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return tokens, nil
+}
+
+// RevokeAccessToken revokes a token of the user, no rows are affected if it doesn't exist or was already revoked
+func (r *AppRepo) RevokeAccessToken(ctx context.Context, tokenId int64, userId int64, dateRevoked int64) (sql.Result, error) {
+	return r.exec(ctx, r.revokeAccessTokenStm, dateRevoked, tokenId, userId)
+}
+
+// RevokeUserAccessTokens revokes all the tokens of the user which weren't revoked yet
+func (r *AppRepo) RevokeUserAccessTokens(ctx context.Context, userId int64, dateRevoked int64) (sql.Result, error) {
+	return r.exec(ctx, r.revokeUserAccessTokensStm, dateRevoked, userId)
+}
+
+func (r *AppRepo) UpdateAccessTokenLastUsed(ctx context.Context, tokenId int64, lastUsed int64) (sql.Result, error) {
+	return r.exec(ctx, r.updateAccessTokenLastUsedStm, lastUsed, tokenId)
+}
+
+func (r *AppRepo) AddOAuthClient(ctx context.Context, client models.OAuthClient) (sql.Result, error) {
+	return r.exec(ctx, r.addOAuthClientStm, client.ClientId, client.UserId, client.Name, client.ClientSecretHash, strings.Join(client.RedirectURIs, " "),
+		strings.Join(client.Scopes, " "), client.Confidential, client.DateCreated)
+}
+
+func (r *AppRepo) GetOAuthClientById(ctx context.Context, clientId string) (*models.OAuthClient, error) {
+	row := r.stmt(ctx, r.getOAuthClientByIdStm).QueryRowContext(ctx, clientId)
+
+	client, err := scanRowOAuthClient(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("oauth client")
+	case nil:
+		return client, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+func (r *AppRepo) GetOAuthClients(ctx context.Context, userId int64) ([]models.OAuthClient, error) {
+	rows, err := r.stmt(ctx, r.getOAuthClientsStm).QueryContext(ctx, userId)
+
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	clients := make([]models.OAuthClient, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		client, err := scanRowOAuthClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		clients = append(clients, *client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return clients, nil
+}
+
+func (r *AppRepo) AddOAuthCode(ctx context.Context, code models.OAuthCode) (sql.Result, error) {
+	return r.exec(ctx, r.addOAuthCodeStm, code.CodeHash, code.ClientId, code.UserId, code.RedirectURI, strings.Join(code.Scopes, " "),
+		code.CodeChallenge, code.CodeChallengeMethod, code.ExpiresAt, code.DateUsed)
+}
+
+func (r *AppRepo) GetOAuthCodeByCodeHash(ctx context.Context, codeHash string) (*models.OAuthCode, error) {
+	row := r.stmt(ctx, r.getOAuthCodeByCodeHashStm).QueryRowContext(ctx, codeHash)
+
+	code, err := scanRowOAuthCode(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("oauth code")
+	case nil:
+		return code, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+// UseOAuthCode marks a code as used, no rows are affected if it was already used
+func (r *AppRepo) UseOAuthCode(ctx context.Context, codeHash string, dateUsed int64) (sql.Result, error) {
+	return r.exec(ctx, r.useOAuthCodeStm, dateUsed, codeHash)
+}
+
+func (r *AppRepo) GetOAuthConsent(ctx context.Context, userId int64, clientId string) (*models.OAuthConsent, error) {
+	row := r.stmt(ctx, r.getOAuthConsentStm).QueryRowContext(ctx, userId, clientId)
+
+	consent, err := scanRowOAuthConsent(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("oauth consent")
+	case nil:
+		return consent, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+// SaveOAuthConsent inserts the consent or replaces the scopes of the existing one
+func (r *AppRepo) SaveOAuthConsent(ctx context.Context, consent models.OAuthConsent) (sql.Result, error) {
+	return r.exec(ctx, r.saveOAuthConsentStm, consent.UserId, consent.ClientId, strings.Join(consent.Scopes, " "), consent.DateCreated, consent.DateUpdated)
+}
+
+func (r *AppRepo) AddRevokedToken(ctx context.Context, tokenId string, expiresAt int64, dateRevoked int64) (sql.Result, error) {
+	return r.exec(ctx, r.addRevokedTokenStm, tokenId, expiresAt, dateRevoked)
+}
+
+func (r *AppRepo) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	var count int
+	err := r.stmt(ctx, r.isTokenRevokedStm).QueryRowContext(ctx, tokenId).Scan(&count)
+	if err != nil {
+		return false, r.dbError(ctx, err)
+	}
+
+	return count > 0, nil
+}
+
+func (r *AppRepo) AddUserIdentity(ctx context.Context, identity models.UserIdentity) (sql.Result, error) {
+	return r.insert(ctx, r.addUserIdentityStm, identity.UserId, identity.Issuer, identity.Subject, identity.Email, identity.DateCreated, identity.LastLogin)
+}
+
+func (r *AppRepo) GetUserIdentity(ctx context.Context, issuer string, subject string) (*models.UserIdentity, error) {
+	row := r.stmt(ctx, r.getUserIdentityStm).QueryRowContext(ctx, issuer, subject)
+
+	identity, err := scanRowUserIdentity(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("user identity")
+	case nil:
+		return identity, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+func (r *AppRepo) UpdateUserIdentityLastLogin(ctx context.Context, identityId int64, lastLogin int64) (sql.Result, error) {
+	return r.exec(ctx, r.updateUserIdentityLastLoginStm, lastLogin, identityId)
+}
+
+// SearchUsers pages through the users whose email or name contains the query, all the users when the query is empty
+func (r *AppRepo) SearchUsers(ctx context.Context, query string, lastUserId int64, limit int) ([]models.User, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	rows, err := r.stmt(ctx, r.searchUsersStm).QueryContext(ctx, lastUserId, query, pattern, pattern, limit)
+
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	users := make([]models.User, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		user, err := scanRowUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return users, nil
+}
+
+func (r *AppRepo) AddAuditEntry(ctx context.Context, entry models.AuditEntry) (sql.Result, error) {
+	return r.insert(ctx, r.addAuditEntryStm, entry.ActorUserId, entry.Action, entry.TargetUserId, entry.Details, entry.IP, entry.DateCreated)
+}
+
+// GetAuditEntries pages through the audit log, only the entries about the target user when it isn't 0
+func (r *AppRepo) GetAuditEntries(ctx context.Context, targetUserId int64, lastAuditId int64, limit int) ([]models.AuditEntry, error) {
+	rows, err := r.stmt(ctx, r.getAuditEntriesStm).QueryContext(ctx, lastAuditId, targetUserId, targetUserId, limit)
+
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	entries := make([]models.AuditEntry, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		entry, err := scanRowAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		entries = append(entries, *entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return entries, nil
+}
+
+func (r *AppRepo) AddSession(ctx context.Context, session models.Session) (sql.Result, error) {
+	return r.insert(ctx, r.addSessionStm, session.UserId, session.DeviceName, session.UserAgent, session.IP, session.DateCreated, session.LastSeen, session.ExpiresAt, session.DateRevoked)
+}
+
+func (r *AppRepo) GetSessionById(ctx context.Context, sessionId int64) (*models.Session, error) {
+	row := r.stmt(ctx, r.getSessionByIdStm).QueryRowContext(ctx, sessionId)
+
+	session, err := scanRowSession(row)
+	switch err {
+	case sql.ErrNoRows:
+		return nil, notFound("session")
+	case nil:
+		return session, nil
+	default:
+		return nil, r.dbError(ctx, err)
+	}
+}
+
+// GetSessions returns the sessions of the user which are neither revoked nor expired, the most recently seen first
+func (r *AppRepo) GetSessions(ctx context.Context, userId int64, now int64) ([]models.Session, error) {
+	rows, err := r.stmt(ctx, r.getSessionsStm).QueryContext(ctx, userId, now)
+
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	sessions := make([]models.Session, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		session, err := scanRowSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return sessions, nil
+}
+
+func (r *AppRepo) UpdateSessionLastSeen(ctx context.Context, sessionId int64, lastSeen int64) (sql.Result, error) {
+	return r.exec(ctx, r.updateSessionLastSeenStm, lastSeen, sessionId)
+}
+
+// RevokeSession revokes a session of the user, no rows are affected if it doesn't exist or was already revoked
+func (r *AppRepo) RevokeSession(ctx context.Context, sessionId int64, userId int64, dateRevoked int64) (sql.Result, error) {
+	return r.exec(ctx, r.revokeSessionStm, dateRevoked, sessionId, userId)
+}
+
+// RevokeUserSessions revokes all the sessions of the user which weren't revoked yet
+func (r *AppRepo) RevokeUserSessions(ctx context.Context, userId int64, dateRevoked int64) (sql.Result, error) {
+	return r.exec(ctx, r.revokeUserSessionsStm, dateRevoked, userId)
+}
+
+func (r *AppRepo) GetUsersDueForDeletion(ctx context.Context, now int64, limit int) ([]models.User, error) {
+	rows, err := r.stmt(ctx, r.getUsersDueForDeletionStm).QueryContext(ctx, now, limit)
+
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	users := make([]models.User, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		user, err := scanRowUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return users, nil
+}
+
+// DeleteUser deletes the user with everything that references it in one transaction,
+// the tasks are either deleted or kept without an owner
+func (r *AppRepo) DeleteUser(ctx context.Context, userId int64, anonymizeTasks bool) error {
+	r.replicas.pin(userKey(userId), accountKey(userId))
+
+	tasksStm := r.deleteUserTasksStm
+	if anonymizeTasks {
+		tasksStm = r.anonymizeUserTasksStm
+	}
+
+	// the order follows the foreign keys, the user row goes last
+	steps := []struct {
+		stm  *sql.Stmt
+		args []interface{}
+	}{
+		{tasksStm, []interface{}{userId}},
+		{r.deleteUserOAuthCodesStm, []interface{}{userId, userId}},
+		{r.deleteUserOAuthConsentsStm, []interface{}{userId, userId}},
+		{r.deleteUserOAuthClientsStm, []interface{}{userId}},
+		{r.deleteUserAccessTokensStm, []interface{}{userId}},
+		{r.deleteRecoveryCodesStm, []interface{}{userId}},
+		{r.deleteUserPasswordResetsStm, []interface{}{userId}},
+		{r.deleteUserIdentitiesStm, []interface{}{userId}},
+		{r.deleteUserSessionsStm, []interface{}{userId}},
+		{r.deleteUserExportJobsStm, []interface{}{userId}},
+		{r.deleteUserSecurityEventsStm, []interface{}{userId}},
+		{r.deleteUserStm, []interface{}{userId}},
+	}
+	return r.WithTx(ctx, func(repo IAppRepo) error {
+		tx := repo.(*AppRepo)
+		for _, step := range steps {
+			if _, err := tx.stmt(ctx, step.stm).ExecContext(ctx, step.args...); err != nil {
+				return r.dbError(ctx, fmt.Errorf("mysql: could not delete user: %w", err))
+			}
+		}
+
+		return nil
+	})
 }
 
-type DbConfig struct {
-	Host         string `required:"true"`
-	Port         int    `required:"true"`
-	DatabaseName string `required:"true"`
-	User         string `required:"true"`
-	Password     string `required:"true"`
-}
+// GetTasksByUser returns the tasks of the user oldest first, paging by the last task id walks all of them
+func (r *AppRepo) GetTasksByUser(ctx context.Context, userId int64, lastTaskId int64, limit int) ([]models.Task, error) {
+	rows, err := r.stmt(ctx, r.getTasksByUserStm).QueryContext(ctx, userId, lastTaskId, limit)
 
-const createUserStatement = `insert into GOS_USER (name, email, password, last_login, failed_login_attempt, date_created, date_updated) VALUES (?, ?, ?, ?, ?, ?, ?)`
-const updateUserStatement = `update GOS_USER set name = ?, email =?, password = ?, last_login = ?, failed_login_attempt = ? , date_created = ?, date_updated = ? where user_id = ?`
-const getUserByEmailStatement = `select user_id, name, email, password, last_login, failed_login_attempt, date_created, date_updated from GOS_USER where email = ?`
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
 
-const insertTaskStatement = `insert into GOS_TASK (user_id, title, description, date_created, date_updated, due_date, date_complete) VALUES (?, ?, ?, ?, ?, ?, ?)`
-const getTasksStatement = `select task_id, user_id, title, description, date_created, date_updated, due_date, date_complete from GOS_TASK where task_id > ? and user_id = ? order by task_id desc limit ?`
-const getTaskByIdStatement = `select task_id, user_id, title, description, date_created, date_updated, due_date, date_complete from GOS_TASK where task_id = ? and user_id = ?`
+	tasks := make([]models.Task, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
 
-func NewAppRepo(dbConfig DbConfig) (*AppRepo, error) {
-	name := dataStoreName(dbConfig)
-	con, err := sqlx.Connect("mysql", name)
-	if err != nil {
-		return nil, err
+	for rows.Next() {
+		task, err := scanRowTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		tasks = append(tasks, *task)
 	}
 
-	createUserStm, err := con.Prepare(createUserStatement)
-	if err != nil {
-		return nil, err
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
 	}
 
-	updateUserStm, err := con.Prepare(updateUserStatement)
+	return tasks, nil
+}
+
+// GetUserSessions returns all the sessions of the user, revoked and expired ones included
+func (r *AppRepo) GetUserSessions(ctx context.Context, userId int64) ([]models.Session, error) {
+	rows, err := r.stmt(ctx, r.getUserSessionsStm).QueryContext(ctx, userId)
+
 	if err != nil {
-		return nil, err
+		return nil, r.dbError(ctx, err)
 	}
 
-	getUserByEmailStm, err := con.Prepare(getUserByEmailStatement)
-	if err != nil {
-		return nil, err
+	sessions := make([]models.Session, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		session, err := scanRowSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		sessions = append(sessions, *session)
 	}
 
-	getAllTaskStm, err := con.Prepare(getTasksStatement)
-	if err != nil {
-		return nil, err
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
 	}
 
-	getTaskByIdStm, err := con.Prepare(getTaskByIdStatement)
+	return sessions, nil
+}
+
+func (r *AppRepo) GetUserIdentities(ctx context.Context, userId int64) ([]models.UserIdentity, error) {
+	rows, err := r.stmt(ctx, r.getUserIdentitiesStm).QueryContext(ctx, userId)
+
 	if err != nil {
-		return nil, err
+		return nil, r.dbError(ctx, err)
 	}
 
-	addTaskStm, err := con.Prepare(insertTaskStatement)
-	if err != nil {
-		return nil, err
+	identities := make([]models.UserIdentity, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		identity, err := scanRowUserIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		identities = append(identities, *identity)
 	}
 
-	return &AppRepo{
-		con:               con,
-		createUserStm:     createUserStm,
-		updateUserStm:     updateUserStm,
-		getUserByEmailStm: getUserByEmailStm,
-		getAllTaskStm:     getAllTaskStm,
-		getTaskByIdStm:    getTaskByIdStm,
-		addTaskStm:        addTaskStm,
-	}, nil
-}
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
 
-func (r *AppRepo) AddUser(ctx context.Context, user models.User) (sql.Result, error) {
-	return r.createUserStm.Exec(user.Name, user.Email, user.Password, user.LastLogin, user.FailedLoginAttempt, user.DateUpdated, user.DateUpdated)
+	return identities, nil
 }
 
-func (r *AppRepo) UpdateUser(ctx context.Context, user models.User) (sql.Result, error) {
-	return r.updateUserStm.Exec(user.Name, user.Email, user.Password, user.LastLogin, user.FailedLoginAttempt, user.DateUpdated, user.DateUpdated, user.UserId)
+func (r *AppRepo) AddExportJob(ctx context.Context, job models.ExportJob) (sql.Result, error) {
+	return r.insert(ctx, r.addExportJobStm, job.UserId, job.Status, job.Error, job.DateCreated, job.DateCompleted, job.ExpiresAt)
 }
 
-func (r *AppRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	row := r.getUserByEmailStm.QueryRow(email)
+func (r *AppRepo) GetExportJob(ctx context.Context, jobId int64, userId int64) (*models.ExportJob, error) {
+	row := r.stmt(ctx, r.getExportJobStm).QueryRowContext(ctx, jobId, userId)
 
-	user, err := scanRowUser(row)
+	job, err := scanRowExportJob(row)
 	switch err {
 	case sql.ErrNoRows:
-		return nil, errors.New("user not found")
+		return nil, notFound("export job")
 	case nil:
-		return user, nil
+		return job, nil
 	default:
-		return nil, err
+		return nil, r.dbError(ctx, err)
 	}
 }
 
-func (r *AppRepo) GetAllTasks(ctx context.Context, lastTaskId int64, userId int64, limit int) ([]models.Task, error) {
-	rows, err := r.getAllTaskStm.Query(lastTaskId, userId, limit)
+func (r *AppRepo) GetExportJobs(ctx context.Context, userId int64) ([]models.ExportJob, error) {
+	rows, err := r.stmt(ctx, r.getExportJobsStm).QueryContext(ctx, userId)
 
 	if err != nil {
-		return nil, err
+		return nil, r.dbError(ctx, err)
 	}
 
-	tasks := make([]models.Task, 0)
+	jobs := make([]models.ExportJob, 0)
 	defer func() {
 		err := rows.Close()
 		if err != nil {
@@ -138,35 +1586,105 @@ func (r *AppRepo) GetAllTasks(ctx context.Context, lastTaskId int64, userId int6
 	}()
 
 	for rows.Next() {
-		task, err := scanRowTask(rows)
+		job, err := scanRowExportJob(rows)
 		if err != nil {
 			return nil, fmt.Errorf("mysql: could not read row: %v", err)
 		}
-		tasks = append(tasks, *task)
+		jobs = append(jobs, *job)
 	}
 
-	return tasks, nil
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return jobs, nil
 }
 
-func (r *AppRepo) GetTaskById(ctx context.Context, taskId int64, userId int64) (*models.Task, error) {
-	row := r.getTaskByIdStm.QueryRow(taskId, userId)
+// GetStaleExportJobs returns the jobs whose file expired, the ones pending since before pendingBefore
+// and the failed ones older than failedBefore
+func (r *AppRepo) GetStaleExportJobs(ctx context.Context, now int64, pendingBefore int64, failedBefore int64, limit int) ([]models.ExportJob, error) {
+	rows, err := r.stmt(ctx, r.getStaleExportJobsStm).QueryContext(ctx, now, pendingBefore, failedBefore, limit)
 
-	task, err := scanRowTask(row)
-	switch err {
-	case sql.ErrNoRows:
-		return nil, errors.New("task not found")
-	case nil:
-		return task, nil
-	default:
-		return nil, err
+	if err != nil {
+		return nil, r.dbError(ctx, err)
 	}
+
+	jobs := make([]models.ExportJob, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		job, err := scanRowExportJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		jobs = append(jobs, *job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return jobs, nil
 }
 
-func (r *AppRepo) AddTask(ctx context.Context, task models.Task) (sql.Result, error) {
-	return r.addTaskStm.Exec(task.UserId, task.Title, task.Description, task.DateCreated, task.DateUpdated, task.DueDate, task.DateCompleted)
+func (r *AppRepo) UpdateExportJob(ctx context.Context, job models.ExportJob) (sql.Result, error) {
+	return r.exec(ctx, r.updateExportJobStm, job.Status, job.Error, job.DateCompleted, job.ExpiresAt, job.JobId)
+}
+
+func (r *AppRepo) DeleteExportJob(ctx context.Context, jobId int64) (sql.Result, error) {
+	return r.exec(ctx, r.deleteExportJobStm, jobId)
+}
+
+func (r *AppRepo) AddSecurityEvent(ctx context.Context, event models.SecurityEvent) (sql.Result, error) {
+	return r.insert(ctx, r.addSecurityEventStm, event.UserId, event.Type, event.Details, event.IP, event.UserAgent, event.DateCreated)
+}
+
+// GetSecurityEvents returns the events oldest first, of all the users when the user is 0 and of all the types when the type is empty
+func (r *AppRepo) GetSecurityEvents(ctx context.Context, userId int64, eventType string, lastEventId int64, limit int) ([]models.SecurityEvent, error) {
+	rows, err := r.stmt(ctx, r.getSecurityEventsStm).QueryContext(ctx, lastEventId, userId, userId, eventType, eventType, limit)
+
+	if err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	events := make([]models.SecurityEvent, 0)
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	for rows.Next() {
+		event, err := scanRowSecurityEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+		}
+		events = append(events, *event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, r.dbError(ctx, err)
+	}
+
+	return events, nil
+}
+
+// DeleteSecurityEventsBefore deletes at most limit events older than before, so a purge doesn't lock the table for long
+func (r *AppRepo) DeleteSecurityEventsBefore(ctx context.Context, before int64, limit int) (sql.Result, error) {
+	return r.exec(ctx, r.deleteSecurityEventsBeforeStm, before, limit)
 }
 
 func (r *AppRepo) Close() error {
+	if r.replicas != nil {
+		return errors.Join(r.replicas.close(), r.con.Close())
+	}
+
 	return r.con.Close()
 }
 
@@ -180,20 +1698,41 @@ func scanRowUser(s RowScanner) (*models.User, error) {
 		failedLoginAttempt int
 		dateCreated        int64
 		dateUpdated        int64
+		tokenVersion       int
+		emailVerified      bool
+		verificationSent   int64
+		totpSecret         string
+		totpEnabled        bool
+		totpLastStep       int64
+		role               string
+		disabled           bool
+		deletionScheduled  int64
+		lockedUntil        int64
 	)
-	if err := s.Scan(&userId, &name, &email, &password, &lastLogin, &failedLoginAttempt, &dateCreated, &dateUpdated); err != nil {
+	if err := s.Scan(&userId, &name, &email, &password, &lastLogin, &failedLoginAttempt, &dateCreated, &dateUpdated, &tokenVersion,
+		&emailVerified, &verificationSent, &totpSecret, &totpEnabled, &totpLastStep, &role, &disabled, &deletionScheduled, &lockedUntil); err != nil {
 		return nil, err
 	}
 
 	return &models.User{
-		UserId:             userId,
-		Name:               email,
-		Email:              email,
-		Password:           password,
-		LastLogin:          lastLogin,
-		FailedLoginAttempt: failedLoginAttempt,
-		DateCreated:        dateCreated,
-		DateUpdated:        dateUpdated,
+		UserId:               userId,
+		Name:                 name,
+		Email:                email,
+		Password:             password,
+		LastLogin:            lastLogin,
+		FailedLoginAttempt:   failedLoginAttempt,
+		DateCreated:          dateCreated,
+		DateUpdated:          dateUpdated,
+		TokenVersion:         tokenVersion,
+		EmailVerified:        emailVerified,
+		DateVerificationSent: verificationSent,
+		TOTPSecret:           totpSecret,
+		TOTPEnabled:          totpEnabled,
+		TOTPLastStep:         totpLastStep,
+		Role:                 role,
+		Disabled:             disabled,
+		DeletionScheduledAt:  deletionScheduled,
+		LockedUntil:          lockedUntil,
 	}, nil
 }
 
@@ -224,8 +1763,334 @@ func scanRowTask(s RowScanner) (*models.Task, error) {
 	}, nil
 }
 
-func dataStoreName(dbConfig DbConfig) string {
-	return fmt.Sprintf("%s:%s@(%s:%v)/%s", dbConfig.User, dbConfig.Password, dbConfig.Host, dbConfig.Port, dbConfig.DatabaseName)
+func scanRowPasswordReset(s RowScanner) (*models.PasswordReset, error) {
+	var (
+		resetId     int64
+		userId      int64
+		tokenHash   string
+		dateCreated int64
+		expiresAt   int64
+		dateUsed    int64
+	)
+	if err := s.Scan(&resetId, &userId, &tokenHash, &dateCreated, &expiresAt, &dateUsed); err != nil {
+		return nil, err
+	}
+
+	return &models.PasswordReset{
+		ResetId:     resetId,
+		UserId:      userId,
+		TokenHash:   tokenHash,
+		DateCreated: dateCreated,
+		ExpiresAt:   expiresAt,
+		DateUsed:    dateUsed,
+	}, nil
+}
+
+func scanRowAccessToken(s RowScanner) (*models.AccessToken, error) {
+	var (
+		tokenId     int64
+		userId      int64
+		name        string
+		tokenHash   string
+		scopes      string
+		dateCreated int64
+		expiresAt   int64
+		lastUsed    int64
+		dateRevoked int64
+	)
+	if err := s.Scan(&tokenId, &userId, &name, &tokenHash, &scopes, &dateCreated, &expiresAt, &lastUsed, &dateRevoked); err != nil {
+		return nil, err
+	}
+
+	return &models.AccessToken{
+		TokenId:     tokenId,
+		UserId:      userId,
+		Name:        name,
+		TokenHash:   tokenHash,
+		Scopes:      strings.Fields(scopes),
+		DateCreated: dateCreated,
+		ExpiresAt:   expiresAt,
+		LastUsed:    lastUsed,
+		DateRevoked: dateRevoked,
+	}, nil
+}
+
+func scanRowOAuthClient(s RowScanner) (*models.OAuthClient, error) {
+	var (
+		clientId         string
+		userId           int64
+		name             string
+		clientSecretHash string
+		redirectURIs     string
+		scopes           string
+		confidential     bool
+		dateCreated      int64
+	)
+	if err := s.Scan(&clientId, &userId, &name, &clientSecretHash, &redirectURIs, &scopes, &confidential, &dateCreated); err != nil {
+		return nil, err
+	}
+
+	return &models.OAuthClient{
+		ClientId:         clientId,
+		UserId:           userId,
+		Name:             name,
+		ClientSecretHash: clientSecretHash,
+		RedirectURIs:     strings.Fields(redirectURIs),
+		Scopes:           strings.Fields(scopes),
+		Confidential:     confidential,
+		DateCreated:      dateCreated,
+	}, nil
+}
+
+func scanRowOAuthCode(s RowScanner) (*models.OAuthCode, error) {
+	var (
+		codeHash            string
+		clientId            string
+		userId              int64
+		redirectURI         string
+		scopes              string
+		codeChallenge       string
+		codeChallengeMethod string
+		expiresAt           int64
+		dateUsed            int64
+	)
+	if err := s.Scan(&codeHash, &clientId, &userId, &redirectURI, &scopes, &codeChallenge, &codeChallengeMethod, &expiresAt, &dateUsed); err != nil {
+		return nil, err
+	}
+
+	return &models.OAuthCode{
+		CodeHash:            codeHash,
+		ClientId:            clientId,
+		UserId:              userId,
+		RedirectURI:         redirectURI,
+		Scopes:              strings.Fields(scopes),
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: codeChallengeMethod,
+		ExpiresAt:           expiresAt,
+		DateUsed:            dateUsed,
+	}, nil
+}
+
+func scanRowOAuthConsent(s RowScanner) (*models.OAuthConsent, error) {
+	var (
+		userId      int64
+		clientId    string
+		scopes      string
+		dateCreated int64
+		dateUpdated int64
+	)
+	if err := s.Scan(&userId, &clientId, &scopes, &dateCreated, &dateUpdated); err != nil {
+		return nil, err
+	}
+
+	return &models.OAuthConsent{
+		UserId:      userId,
+		ClientId:    clientId,
+		Scopes:      strings.Fields(scopes),
+		DateCreated: dateCreated,
+		DateUpdated: dateUpdated,
+	}, nil
+}
+
+func scanRowUserIdentity(s RowScanner) (*models.UserIdentity, error) {
+	var (
+		identityId  int64
+		userId      int64
+		issuer      string
+		subject     string
+		email       string
+		dateCreated int64
+		lastLogin   int64
+	)
+	if err := s.Scan(&identityId, &userId, &issuer, &subject, &email, &dateCreated, &lastLogin); err != nil {
+		return nil, err
+	}
+
+	return &models.UserIdentity{
+		IdentityId:  identityId,
+		UserId:      userId,
+		Issuer:      issuer,
+		Subject:     subject,
+		Email:       email,
+		DateCreated: dateCreated,
+		LastLogin:   lastLogin,
+	}, nil
+}
+
+func scanRowAuditEntry(s RowScanner) (*models.AuditEntry, error) {
+	var (
+		auditId      int64
+		actorUserId  int64
+		action       string
+		targetUserId int64
+		details      string
+		ip           string
+		dateCreated  int64
+	)
+	if err := s.Scan(&auditId, &actorUserId, &action, &targetUserId, &details, &ip, &dateCreated); err != nil {
+		return nil, err
+	}
+
+	return &models.AuditEntry{
+		AuditId:      auditId,
+		ActorUserId:  actorUserId,
+		Action:       action,
+		TargetUserId: targetUserId,
+		Details:      details,
+		IP:           ip,
+		DateCreated:  dateCreated,
+	}, nil
+}
+
+// likeEscaper escapes the wildcards of a like pattern, backslash is the default escape character of mysql
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func scanRowSession(s RowScanner) (*models.Session, error) {
+	var (
+		sessionId   int64
+		userId      int64
+		deviceName  string
+		userAgent   string
+		ip          string
+		dateCreated int64
+		lastSeen    int64
+		expiresAt   int64
+		dateRevoked int64
+	)
+	if err := s.Scan(&sessionId, &userId, &deviceName, &userAgent, &ip, &dateCreated, &lastSeen, &expiresAt, &dateRevoked); err != nil {
+		return nil, err
+	}
+
+	return &models.Session{
+		SessionId:   sessionId,
+		UserId:      userId,
+		DeviceName:  deviceName,
+		UserAgent:   userAgent,
+		IP:          ip,
+		DateCreated: dateCreated,
+		LastSeen:    lastSeen,
+		ExpiresAt:   expiresAt,
+		DateRevoked: dateRevoked,
+	}, nil
+}
+
+func scanRowExportJob(s RowScanner) (*models.ExportJob, error) {
+	var (
+		jobId         int64
+		userId        int64
+		status        string
+		jobError      string
+		dateCreated   int64
+		dateCompleted int64
+		expiresAt     int64
+	)
+	if err := s.Scan(&jobId, &userId, &status, &jobError, &dateCreated, &dateCompleted, &expiresAt); err != nil {
+		return nil, err
+	}
+
+	return &models.ExportJob{
+		JobId:         jobId,
+		UserId:        userId,
+		Status:        status,
+		Error:         jobError,
+		DateCreated:   dateCreated,
+		DateCompleted: dateCompleted,
+		ExpiresAt:     expiresAt,
+	}, nil
+}
+
+func scanRowSecurityEvent(s RowScanner) (*models.SecurityEvent, error) {
+	var (
+		eventId     int64
+		userId      int64
+		eventType   string
+		details     string
+		ip          string
+		userAgent   string
+		dateCreated int64
+	)
+	if err := s.Scan(&eventId, &userId, &eventType, &details, &ip, &userAgent, &dateCreated); err != nil {
+		return nil, err
+	}
+
+	return &models.SecurityEvent{
+		EventId:     eventId,
+		UserId:      userId,
+		Type:        eventType,
+		Details:     details,
+		IP:          ip,
+		UserAgent:   userAgent,
+		DateCreated: dateCreated,
+	}, nil
+}
+
+// dataStoreName returns the mysql DSN, or builds it from the host settings with the TLS, charset, collation and
+// parseTime options. The CA of TLSCA is registered either way, a DSN refers to it with tls=gos.
+func dataStoreName(dbConfig DbConfig) (string, error) {
+	tlsConfig, err := mysqlTLSConfig(dbConfig)
+	if err != nil {
+		return "", err
+	}
+
+	if _, name := splitDSN(dbConfig.DSN); len(name) > 0 {
+		return name, nil
+	}
+
+	cfg := mysql.NewConfig()
+	cfg.User = dbConfig.User
+	cfg.Passwd = dbConfig.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(dbConfig.Host, strconv.Itoa(dbConfig.Port))
+	cfg.DBName = dbConfig.DatabaseName
+	cfg.TLSConfig = tlsConfig
+	cfg.ParseTime = dbConfig.ParseTime
+	if len(dbConfig.Collation) > 0 {
+		cfg.Collation = dbConfig.Collation
+	}
+
+	if len(dbConfig.Charset) > 0 {
+		cfg.Params = map[string]string{"charset": dbConfig.Charset}
+	}
+
+	return cfg.FormatDSN(), nil
+}
+
+// mysqlTLSConfigName is the name the TLS config with the CA of TLSCA is registered with in the mysql driver
+const mysqlTLSConfigName = "gos"
+
+// mysqlTLSConfig returns the name of the TLS config of the mysql driver for the TLS settings,
+// the one with the CA of TLSCA is registered first
+func mysqlTLSConfig(dbConfig DbConfig) (string, error) {
+	switch dbConfig.TLS {
+	case "", "true", "false", "skip-verify":
+	default:
+		return "", fmt.Errorf("unknown db TLS mode [%s]", dbConfig.TLS)
+	}
+
+	if len(dbConfig.TLSCA) == 0 {
+		return dbConfig.TLS, nil
+	}
+
+	if dbConfig.TLS == "false" || dbConfig.TLS == "skip-verify" {
+		return "", fmt.Errorf("the db TLS mode [%s] doesn't verify the server, the CA [%s] would be unused", dbConfig.TLS, dbConfig.TLSCA)
+	}
+
+	pem, err := os.ReadFile(dbConfig.TLSCA)
+	if err != nil {
+		return "", fmt.Errorf("could not read the db CA: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return "", fmt.Errorf("the db CA [%s] has no PEM certificate", dbConfig.TLSCA)
+	}
+
+	err = mysql.RegisterTLSConfig(mysqlTLSConfigName, &tls.Config{RootCAs: roots})
+	if err != nil {
+		return "", err
+	}
+
+	return mysqlTLSConfigName, nil
 }
 
 var _ = (*AppRepo)(nil)
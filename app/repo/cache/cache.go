@@ -0,0 +1,392 @@
+// Package cache decorates the repo with a cache of the tasks and of the ids of the users by email, kept in a Backend.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gos/app/models"
+	"gos/app/repo"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keyPrefix namespaces the keys of the cache, a shared backend may hold other entries
+const keyPrefix = "gos:"
+
+// Backend keeps the entries of the cache, an in-process one like LRU or a distributed one shared by the instances.
+// Its errors make the cache fall back to the repo, they are counted but never returned by the repo.
+type Backend interface {
+	// Get returns the value of the key, false when it is missing or expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores the value of the key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the keys, the missing ones are ignored
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Stats counts the lookups of the cache since the start
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Loads is how many misses were loaded from the repo, the concurrent misses of a key share a load
+	Loads int64 `json:"loads"`
+	// Errors is how many calls of the backend failed
+	Errors        int64 `json:"errors"`
+	Invalidations int64 `json:"invalidations"`
+}
+
+// Repo caches the tasks of the repo it decorates and the ids of the users by email, the other calls go to the repo.
+// The users themselves are not cached: they authorize every request, where a stale role or token version of another
+// instance must not be used, and hold the password hash and the totp secret, which must not reach a shared backend.
+// The writes made through it invalidate the entries they change, after the commit within a WithTx. The reads within
+// a transaction, given to the function of WithTx or carried by a context of repo.ContextWithTx, skip the cache.
+// The writes made elsewhere, like by another instance with an in-process backend, are seen once the entries expire.
+type Repo struct {
+	repo.IAppRepo
+	*state
+
+	// pending collects the keys written within a WithTx, they are invalidated after the commit, nil outside of one
+	pending *[]string
+}
+
+// state is shared by a Repo and the ones it gives to the functions of WithTx
+type state struct {
+	backend Backend
+	ttl     time.Duration
+	flight  *flight
+
+	// mu orders the stores of the loads after the invalidations: a load whose keys were invalidated while it ran
+	// would store a stale value, the epoch bumped by every invalidation tells it not to
+	mu    sync.RWMutex
+	epoch uint64
+
+	hits, misses, loads, errors, invalidations int64
+}
+
+// New decorates appRepo with a cache kept in backend, the entries expire after ttl
+func New(appRepo repo.IAppRepo, backend Backend, ttl time.Duration) *Repo {
+	return &Repo{
+		IAppRepo: appRepo,
+		state: &state{
+			backend: backend,
+			ttl:     ttl,
+			flight:  newFlight(),
+		},
+	}
+}
+
+// Unwrap returns the decorated repo, repo.ContextWithTx uses it
+func (c *Repo) Unwrap() repo.IAppRepo {
+	return c.IAppRepo
+}
+
+// Stats returns the counts of the lookups
+func (c *Repo) Stats() Stats {
+	return Stats{
+		Hits:          atomic.LoadInt64(&c.hits),
+		Misses:        atomic.LoadInt64(&c.misses),
+		Loads:         atomic.LoadInt64(&c.loads),
+		Errors:        atomic.LoadInt64(&c.errors),
+		Invalidations: atomic.LoadInt64(&c.invalidations),
+	}
+}
+
+// GetUserByEmail finds the id of the user by the email in the cache, then the user by the id in the repo. The email
+// of the user is checked again since it may have changed since the id was stored, the entries need no invalidation.
+func (c *Repo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if c.bypass(ctx) {
+		return c.IAppRepo.GetUserByEmail(ctx, email)
+	}
+
+	// the user loaded on a miss is returned as it is, the callers sharing the load read it by the id
+	var loaded *models.User
+	key := emailKey(email)
+	data, err := c.fetch(ctx, key, func(epoch uint64) ([]byte, error) {
+		user, err := c.IAppRepo.GetUserByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+
+		loaded = user
+		data := []byte(strconv.FormatInt(user.UserId, 10))
+		c.store(ctx, epoch, key, data)
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if loaded != nil {
+		return loaded, nil
+	}
+
+	userId, err := strconv.ParseInt(string(data), 10, 64)
+	if err == nil {
+		user, err := c.IAppRepo.GetUserById(ctx, userId)
+		if err == nil && strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+
+		if err != nil && !errors.Is(err, repo.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	c.invalidate(ctx, key)
+	return c.IAppRepo.GetUserByEmail(ctx, email)
+}
+
+// GetAllTasks caches the pages of the tasks under the task generation of the user, a new task starts a new one
+func (c *Repo) GetAllTasks(ctx context.Context, lastTaskId int64, userId int64, limit int) ([]models.Task, error) {
+	generation, ok := c.taskGeneration(ctx, userId)
+	if !ok {
+		return c.IAppRepo.GetAllTasks(ctx, lastTaskId, userId, limit)
+	}
+
+	var tasks []models.Task
+	key := fmt.Sprintf("%stasks:%d:%s:%d:%d", keyPrefix, userId, generation, lastTaskId, limit)
+	err := c.cached(ctx, key, &tasks, func() (interface{}, error) {
+		return c.IAppRepo.GetAllTasks(ctx, lastTaskId, userId, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if tasks == nil {
+		tasks = make([]models.Task, 0)
+	}
+
+	return tasks, nil
+}
+
+func (c *Repo) GetTaskById(ctx context.Context, taskId int64, userId int64) (*models.Task, error) {
+	generation, ok := c.taskGeneration(ctx, userId)
+	if !ok {
+		return c.IAppRepo.GetTaskById(ctx, taskId, userId)
+	}
+
+	task := &models.Task{}
+	key := fmt.Sprintf("%stask:%d:%s:%d", keyPrefix, userId, generation, taskId)
+	err := c.cached(ctx, key, task, func() (interface{}, error) {
+		return c.IAppRepo.GetTaskById(ctx, taskId, userId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+func (c *Repo) AddTask(ctx context.Context, task models.Task) (sql.Result, error) {
+	result, err := c.IAppRepo.AddTask(ctx, task)
+	c.invalidate(ctx, taskGenerationKey(task.UserId))
+	return result, err
+}
+
+func (c *Repo) DeleteUser(ctx context.Context, userId int64, anonymizeTasks bool) error {
+	err := c.IAppRepo.DeleteUser(ctx, userId, anonymizeTasks)
+	c.invalidate(ctx, taskGenerationKey(userId))
+	return err
+}
+
+// WithTx gives fn a Repo bound to the transaction, the keys it writes are invalidated once the transaction commits
+func (c *Repo) WithTx(ctx context.Context, fn func(repo repo.IAppRepo) error) error {
+	if c.pending != nil {
+		return c.IAppRepo.WithTx(ctx, func(tx repo.IAppRepo) error {
+			return fn(&Repo{IAppRepo: tx, state: c.state, pending: c.pending})
+		})
+	}
+
+	// the keys of the failed attempts are kept, invalidating a key too many is harmless
+	var pending []string
+	err := c.IAppRepo.WithTx(ctx, func(tx repo.IAppRepo) error {
+		return fn(&Repo{IAppRepo: tx, state: c.state, pending: &pending})
+	})
+	if err == nil {
+		c.invalidate(ctx, pending...)
+	}
+
+	return err
+}
+
+// bypass tells whether the reads skip the cache, within a transaction they must see its own writes
+func (c *Repo) bypass(ctx context.Context) bool {
+	return c.pending != nil || repo.InTx(ctx)
+}
+
+// cached decodes the entry of key into value, on a miss the value returned by load is stored and decoded instead.
+// An entry which doesn't decode, like one stored by an older version through a shared backend, is loaded again.
+func (c *Repo) cached(ctx context.Context, key string, value interface{}, load func() (interface{}, error)) error {
+	loadEncoded := func() ([]byte, error) {
+		loaded, err := load()
+		if err != nil {
+			return nil, err
+		}
+
+		return encode(loaded)
+	}
+
+	data, err := c.fetch(ctx, key, func(epoch uint64) ([]byte, error) {
+		data, err := loadEncoded()
+		if err == nil {
+			c.store(ctx, epoch, key, data)
+		}
+
+		return data, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := decode(data, value); err == nil {
+		return nil
+	}
+
+	atomic.AddInt64(&c.errors, 1)
+	c.invalidate(ctx, key)
+
+	data, err = loadEncoded()
+	if err != nil {
+		return err
+	}
+
+	return decode(data, value)
+}
+
+// fetch returns the entry of key, a miss runs load with the epoch it started at, the concurrent misses of the key
+// share its result. A load canceled by the context of another caller is run again with the caller's own.
+func (c *Repo) fetch(ctx context.Context, key string, load func(epoch uint64) ([]byte, error)) ([]byte, error) {
+	data, ok, err := c.backend.Get(ctx, key)
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+	} else if ok {
+		atomic.AddInt64(&c.hits, 1)
+		return data, nil
+	}
+
+	atomic.AddInt64(&c.misses, 1)
+
+	loadOnce := func() ([]byte, error) {
+		atomic.AddInt64(&c.loads, 1)
+		return load(c.currentEpoch())
+	}
+
+	data, err, shared := c.flight.do(key, loadOnce)
+	if shared && errors.Is(err, repo.ErrCanceled) && ctx.Err() == nil {
+		return loadOnce()
+	}
+
+	return data, err
+}
+
+// store sets the entry of key unless an invalidation happened since epoch, the value may predate it.
+// It isn't bound to the request, a canceled request still stores what it loaded.
+func (c *Repo) store(ctx context.Context, epoch uint64, key string, data []byte) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.epoch != epoch {
+		return
+	}
+
+	if err := c.backend.Set(context.WithoutCancel(ctx), key, data, c.ttl); err != nil {
+		atomic.AddInt64(&c.errors, 1)
+	}
+}
+
+// invalidate deletes the keys, within a WithTx they are deleted after the commit.
+// It isn't bound to the request, a canceled request may still have written.
+func (c *Repo) invalidate(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	if c.pending != nil {
+		*c.pending = append(*c.pending, keys...)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	atomic.AddInt64(&c.invalidations, int64(len(keys)))
+	if err := c.backend.Delete(context.WithoutCancel(ctx), keys...); err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		fmt.Printf("cache: could not invalidate %v, they are stale until they expire: %v\n", keys, err)
+	}
+}
+
+func (c *Repo) currentEpoch() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.epoch
+}
+
+// taskGeneration returns the task generation of the user, the keys of its tasks include it so a new task invalidates
+// them all at once. A missing one is started, false means the tasks aren't cached for now.
+func (c *Repo) taskGeneration(ctx context.Context, userId int64) (string, bool) {
+	if c.bypass(ctx) {
+		return "", false
+	}
+
+	key := taskGenerationKey(userId)
+	data, ok, err := c.backend.Get(ctx, key)
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		return "", false
+	}
+
+	if ok {
+		return string(data), true
+	}
+
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := c.backend.Set(context.WithoutCancel(ctx), key, []byte(generation), c.ttl); err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		return "", false
+	}
+
+	return generation, true
+}
+
+// encode serializes a value of the cache, gob keeps the fields the json of the models leaves out
+func encode(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, fmt.Errorf("cache: could not encode: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, value interface{}) error {
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(value); err != nil {
+		return fmt.Errorf("cache: could not decode: %v", err)
+	}
+
+	return nil
+}
+
+// emailKey maps the email to the id of the user, the lookups ignore the case. The email is hashed, a shared backend
+// doesn't keep the addresses of the users.
+func emailKey(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return keyPrefix + "user-email:" + hex.EncodeToString(sum[:])
+}
+
+func taskGenerationKey(userId int64) string {
+	return keyPrefix + "task-gen:" + strconv.FormatInt(userId, 10)
+}
+
+var _ repo.IAppRepo = (*Repo)(nil)
@@ -0,0 +1,489 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"gos/app/models"
+	"gos/app/repo"
+	"gos/app/repo/cache"
+	"gos/app/repo/conformance"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCacheConformance runs the checks through the cache, it must not change what the repo answers
+func TestCacheConformance(t *testing.T) {
+	conformance.RunConformance(t, func() repo.IAppRepo {
+		return cache.New(repo.NewMemoryRepo(), cache.NewLRU(1000), time.Minute)
+	})
+}
+
+// countingRepo counts the reads reaching the memory repo, the task reads wait for gate when it is set
+type countingRepo struct {
+	repo.IAppRepo
+
+	mu    sync.Mutex
+	calls map[string]int
+	gate  chan struct{}
+	// started gets a value when a task read waits for gate
+	started chan struct{}
+}
+
+func newCountingRepo() *countingRepo {
+	return &countingRepo{IAppRepo: repo.NewMemoryRepo(), calls: make(map[string]int), started: make(chan struct{}, 100)}
+}
+
+func (r *countingRepo) count(method string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.calls[method]
+}
+
+func (r *countingRepo) called(method string) chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls[method]++
+	return r.gate
+}
+
+func (r *countingRepo) GetTaskById(ctx context.Context, taskId int64, userId int64) (*models.Task, error) {
+	if gate := r.called("GetTaskById"); gate != nil {
+		r.started <- struct{}{}
+		<-gate
+	}
+
+	return r.IAppRepo.GetTaskById(ctx, taskId, userId)
+}
+
+func (r *countingRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.called("GetUserByEmail")
+	return r.IAppRepo.GetUserByEmail(ctx, email)
+}
+
+func (r *countingRepo) GetUserById(ctx context.Context, userId int64) (*models.User, error) {
+	r.called("GetUserById")
+	return r.IAppRepo.GetUserById(ctx, userId)
+}
+
+// countingBackend records the keys set and deleted in the LRU, all its calls fail with fail set
+type countingBackend struct {
+	*cache.LRU
+
+	mu      sync.Mutex
+	sets    []string
+	deletes []string
+	fail    bool
+}
+
+func newCountingBackend() *countingBackend {
+	return &countingBackend{LRU: cache.NewLRU(100)}
+}
+
+func (b *countingBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if b.failing() {
+		return nil, false, errors.New("backend down")
+	}
+
+	return b.LRU.Get(ctx, key)
+}
+
+func (b *countingBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if b.failing() {
+		return errors.New("backend down")
+	}
+
+	b.mu.Lock()
+	b.sets = append(b.sets, key)
+	b.mu.Unlock()
+	return b.LRU.Set(ctx, key, value, ttl)
+}
+
+func (b *countingBackend) Delete(ctx context.Context, keys ...string) error {
+	if b.failing() {
+		return errors.New("backend down")
+	}
+
+	b.mu.Lock()
+	b.deletes = append(b.deletes, keys...)
+	b.mu.Unlock()
+	return b.LRU.Delete(ctx, keys...)
+}
+
+func (b *countingBackend) failing() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.fail
+}
+
+// taskSets counts the task entries stored, the task generations aside
+func (b *countingBackend) taskSets() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	count := 0
+	for _, key := range b.sets {
+		if strings.HasPrefix(key, "gos:task:") {
+			count++
+		}
+	}
+
+	return count
+}
+
+// newTask adds a user with a task to the repo behind the cache
+func newTask(t *testing.T, appRepo repo.IAppRepo) models.Task {
+	t.Helper()
+	ctx := context.Background()
+
+	result, err := appRepo.AddUser(ctx, models.User{Name: "cache", Email: "cache@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userId, _ := result.LastInsertId()
+	task := models.Task{UserId: userId, Title: "cached"}
+	result, err = appRepo.AddTask(ctx, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task.TaskId, _ = result.LastInsertId()
+	return task
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	ctx := context.Background()
+	appRepo := newCountingRepo()
+	task := newTask(t, appRepo)
+	c := cache.New(appRepo, newCountingBackend(), time.Minute)
+
+	for i := 0; i < 3; i++ {
+		got, err := c.GetTaskById(ctx, task.TaskId, task.UserId)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Title != task.Title {
+			t.Errorf("GetTaskById title = %s, want %s", got.Title, task.Title)
+		}
+	}
+
+	if calls := appRepo.count("GetTaskById"); calls != 1 {
+		t.Errorf("repo GetTaskById calls = %d, want 1", calls)
+	}
+
+	want := cache.Stats{Hits: 2, Misses: 1, Loads: 1}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+
+	// a new task starts a new generation, the task is loaded again
+	if _, err := c.AddTask(ctx, models.Task{UserId: task.UserId, Title: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.GetTaskById(ctx, task.TaskId, task.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := appRepo.count("GetTaskById"); calls != 2 {
+		t.Errorf("repo GetTaskById calls after AddTask = %d, want 2", calls)
+	}
+
+	want = cache.Stats{Hits: 2, Misses: 2, Loads: 2, Invalidations: 1}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("Stats after AddTask = %+v, want %+v", stats, want)
+	}
+}
+
+func TestCacheSharesConcurrentLoads(t *testing.T) {
+	ctx := context.Background()
+	appRepo := newCountingRepo()
+	task := newTask(t, appRepo)
+	c := cache.New(appRepo, newCountingBackend(), time.Minute)
+
+	// the first read starts the generation, the task entry itself stays missing
+	if _, err := c.GetAllTasks(ctx, 0, task.UserId, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	appRepo.gate = make(chan struct{})
+	const readers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.GetTaskById(ctx, task.TaskId, task.UserId)
+			errs <- err
+		}()
+	}
+
+	<-appRepo.started
+	for c.Stats().Misses < readers+1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the readers counted as missing are about to wait for the running load
+	time.Sleep(20 * time.Millisecond)
+	close(appRepo.gate)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls := appRepo.count("GetTaskById"); calls != 1 {
+		t.Errorf("repo GetTaskById calls = %d, want 1", calls)
+	}
+
+	if loads := c.Stats().Loads; loads != 2 {
+		t.Errorf("Stats loads = %d, want 2", loads)
+	}
+}
+
+func TestCacheDropsLoadsOlderThanAnInvalidation(t *testing.T) {
+	ctx := context.Background()
+	appRepo := newCountingRepo()
+	task := newTask(t, appRepo)
+	backend := newCountingBackend()
+	c := cache.New(appRepo, backend, time.Minute)
+
+	appRepo.gate = make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := c.GetTaskById(ctx, task.TaskId, task.UserId)
+		done <- err
+	}()
+
+	// the task is written while it is loaded, what was loaded may be stale and isn't stored
+	<-appRepo.started
+	if _, err := c.AddTask(ctx, models.Task{UserId: task.UserId, Title: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(appRepo.gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if sets := backend.taskSets(); sets != 0 {
+		t.Errorf("task entries stored = %d, want 0", sets)
+	}
+
+	if _, err := c.GetTaskById(ctx, task.TaskId, task.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	if sets := backend.taskSets(); sets != 1 {
+		t.Errorf("task entries stored after another read = %d, want 1", sets)
+	}
+}
+
+func TestCacheInvalidatesAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	appRepo := newCountingRepo()
+	task := newTask(t, appRepo)
+	backend := newCountingBackend()
+	c := cache.New(appRepo, backend, time.Minute)
+
+	if _, err := c.GetTaskById(ctx, task.TaskId, task.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	err := c.WithTx(ctx, func(tx repo.IAppRepo) error {
+		if _, err := tx.AddTask(ctx, models.Task{UserId: task.UserId, Title: "committed"}); err != nil {
+			return err
+		}
+
+		if invalidations := c.Stats().Invalidations; invalidations != 0 {
+			t.Errorf("Stats invalidations before the commit = %d, want 0", invalidations)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if invalidations := c.Stats().Invalidations; invalidations != 1 {
+		t.Errorf("Stats invalidations after the commit = %d, want 1", invalidations)
+	}
+
+	if _, err := c.GetTaskById(ctx, task.TaskId, task.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := appRepo.count("GetTaskById"); calls != 2 {
+		t.Errorf("repo GetTaskById calls after the commit = %d, want 2", calls)
+	}
+}
+
+func TestCacheKeepsEntriesAfterRollback(t *testing.T) {
+	ctx := context.Background()
+	appRepo := newCountingRepo()
+	task := newTask(t, appRepo)
+	backend := newCountingBackend()
+	c := cache.New(appRepo, backend, time.Minute)
+
+	if _, err := c.GetTaskById(ctx, task.TaskId, task.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	rollback := errors.New("rollback")
+	err := c.WithTx(ctx, func(tx repo.IAppRepo) error {
+		if _, err := tx.AddTask(ctx, models.Task{UserId: task.UserId, Title: "rolled back"}); err != nil {
+			return err
+		}
+
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("WithTx = %v, want %v", err, rollback)
+	}
+
+	if len(backend.deletes) != 0 || c.Stats().Invalidations != 0 {
+		t.Errorf("keys deleted after the rollback = %v, want none", backend.deletes)
+	}
+
+	if _, err := c.GetTaskById(ctx, task.TaskId, task.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := appRepo.count("GetTaskById"); calls != 1 {
+		t.Errorf("repo GetTaskById calls after the rollback = %d, want 1", calls)
+	}
+}
+
+func TestCacheFallsBackWhenTheBackendFails(t *testing.T) {
+	ctx := context.Background()
+	appRepo := newCountingRepo()
+	task := newTask(t, appRepo)
+	backend := newCountingBackend()
+	backend.fail = true
+	c := cache.New(appRepo, backend, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetTaskById(ctx, task.TaskId, task.UserId); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls := appRepo.count("GetTaskById"); calls != 2 {
+		t.Errorf("repo GetTaskById calls = %d, want 2", calls)
+	}
+
+	if errs := c.Stats().Errors; errs != 2 {
+		t.Errorf("Stats errors = %d, want 2", errs)
+	}
+}
+
+func TestCacheUserIdByEmail(t *testing.T) {
+	ctx := context.Background()
+	appRepo := newCountingRepo()
+	task := newTask(t, appRepo)
+	backend := newCountingBackend()
+	c := cache.New(appRepo, backend, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		user, err := c.GetUserByEmail(ctx, "CACHE@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if user.UserId != task.UserId {
+			t.Errorf("GetUserByEmail id = %d, want %d", user.UserId, task.UserId)
+		}
+	}
+
+	if calls := appRepo.count("GetUserByEmail"); calls != 1 {
+		t.Errorf("repo GetUserByEmail calls = %d, want 1", calls)
+	}
+
+	if calls := appRepo.count("GetUserById"); calls != 2 {
+		t.Errorf("repo GetUserById calls = %d, want 2", calls)
+	}
+
+	for _, key := range backend.sets {
+		if strings.Contains(key, "cache@example.com") {
+			t.Errorf("the key %s holds the email", key)
+		}
+	}
+
+	// the email changes behind the cache, the stored id no longer matches it
+	user, err := appRepo.GetUserById(ctx, task.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Email = "changed@example.com"
+	if _, err := appRepo.UpdateUser(ctx, *user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.GetUserByEmail(ctx, "cache@example.com"); !errors.Is(err, repo.ErrNotFound) {
+		t.Errorf("GetUserByEmail of the old email = %v, want %v", err, repo.ErrNotFound)
+	}
+
+	if invalidations := c.Stats().Invalidations; invalidations != 1 {
+		t.Errorf("Stats invalidations = %d, want 1", invalidations)
+	}
+}
+
+func TestLRUEvictsTheLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	lru := cache.NewLRU(2)
+
+	for _, key := range []string{"a", "b"} {
+		if err := lru.Set(ctx, key, []byte(key), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// reading a makes b the least recently used
+	if _, ok, _ := lru.Get(ctx, "a"); !ok {
+		t.Fatal("Get of a missed")
+	}
+
+	if err := lru.Set(ctx, "c", []byte("c"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := lru.Get(ctx, key); ok != want {
+			t.Errorf("Get of %s found = %t, want %t", key, ok, want)
+		}
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	ctx := context.Background()
+	lru := cache.NewLRU(10)
+
+	if err := lru.Set(ctx, "short", []byte("short"), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lru.Set(ctx, "long", []byte("long"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok, _ := lru.Get(ctx, "short"); ok {
+		t.Error("Get of an expired entry found it")
+	}
+
+	if value, ok, _ := lru.Get(ctx, "long"); !ok || string(value) != "long" {
+		t.Errorf("Get of long = %q, %t, want long, true", value, ok)
+	}
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"sync"
+)
+
+// flight runs one load of a key at a time, the callers arriving during a load wait for it and share its result,
+// so an expired hot entry sends a single query to the database instead of a stampede
+type flight struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a running load, done is closed once its result is set
+type flightCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+func newFlight() *flight {
+	return &flight{calls: make(map[string]*flightCall)}
+}
+
+// do runs load for the key unless a load of it is running, whose result is returned instead with shared set
+func (f *flight) do(key string, load func() ([]byte, error)) (value []byte, err error, shared bool) {
+	f.mu.Lock()
+	if call, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		<-call.done
+		return call.value, call.err, true
+	}
+
+	call := &flightCall{done: make(chan struct{})}
+	f.calls[key] = call
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.value, call.err = load()
+	return call.value, call.err, false
+}
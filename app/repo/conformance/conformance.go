@@ -0,0 +1,902 @@
+// Package conformance checks that an implementation of repo.IAppRepo behaves like the mysql repo:
+// the ordering and the paging of the lists, the kinds of the errors, the auto increment ids, the unique and foreign keys,
+// the user scoping, the affected rows of the conditional updates and the transactions with their savepoints.
+// How many rows an update without a change affects differs between the databases, nothing relies on it.
+//
+// Every implementation must pass it, the tests of the repo call RunConformance for each of them. The checks only read
+// and write rows they create, with a random suffix, and delete their users at the end, still they are meant for a
+// scratch database.
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gos/app/models"
+	"gos/app/repo"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// suite runs the checks against a repo and reports the failures to the test of the running group
+type suite struct {
+	ctx    context.Context
+	t      *testing.T
+	r      repo.IAppRepo
+	suffix string
+}
+
+// RunConformance runs all the checks against the repo returned by newRepo, a subtest for each group of them.
+// The groups share the users created by the first one, none runs when they couldn't be created.
+func RunConformance(t *testing.T, newRepo func() repo.IAppRepo) {
+	random := make([]byte, 6)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &suite{ctx: context.Background(), t: t, r: newRepo(), suffix: hex.EncodeToString(random)}
+
+	var userA, userB int64
+	s.run("Users", func() { userA, userB = s.checkUsers() })
+	if userA == 0 || userB == 0 {
+		t.FailNow()
+	}
+
+	s.run("Tasks", func() { s.checkTasks(userA, userB) })
+	s.run("PasswordResets", func() { s.checkPasswordResets(userA) })
+	s.run("RecoveryCodes", func() { s.checkRecoveryCodes(userA, userB) })
+	s.run("AccessTokens", func() { s.checkAccessTokens(userA, userB) })
+	s.run("OAuth", func() { s.checkOAuth(userA) })
+	s.run("Identities", func() { s.checkIdentities(userA) })
+	s.run("AuditLog", func() { s.checkAuditLog(userA, userB) })
+	s.run("Sessions", func() { s.checkSessions(userA, userB) })
+	s.run("ExportJobs", func() { s.checkExportJobs(userA, userB) })
+	s.run("SecurityEvents", func() { s.checkSecurityEvents(userA, userB) })
+	s.run("Tx", func() { s.checkTx(userB) })
+	s.run("DeleteUser", func() { s.checkDeleteUser(userA, userB) })
+}
+
+// run runs a group of checks as a subtest, they report to it
+func (s *suite) run(name string, checks func()) {
+	parent := s.t
+	parent.Run(name, func(t *testing.T) {
+		s.t = t
+		defer func() { s.t = parent }()
+
+		checks()
+	})
+}
+
+func (s *suite) failf(format string, args ...interface{}) {
+	s.t.Helper()
+	s.t.Errorf(format, args...)
+}
+
+// ok fails when err isn't nil, false is returned so the dependent checks can be skipped
+func (s *suite) ok(what string, err error) bool {
+	s.t.Helper()
+
+	if err != nil {
+		s.failf("%s: unexpected error: %v", what, err)
+		return false
+	}
+
+	return true
+}
+
+func (s *suite) insertId(what string, result sql.Result, err error) int64 {
+	s.t.Helper()
+
+	if !s.ok(what, err) {
+		return 0
+	}
+
+	id, err := result.LastInsertId()
+	if !s.ok(what, err) {
+		return 0
+	}
+
+	if id <= 0 {
+		s.failf("%s: expected an auto increment id, got %d", what, id)
+	}
+
+	return id
+}
+
+func (s *suite) affected(what string, result sql.Result, err error, want int64) {
+	s.t.Helper()
+
+	if !s.ok(what, err) {
+		return
+	}
+
+	got, err := result.RowsAffected()
+	if !s.ok(what, err) {
+		return
+	}
+
+	if got != want {
+		s.failf("%s: expected %d affected row/s, got %d", what, want, got)
+	}
+}
+
+// foreignKey fails unless err wraps repo.ErrForeignKey, a validation error
+func (s *suite) foreignKey(what string, err error) {
+	s.t.Helper()
+
+	if !errors.Is(err, repo.ErrForeignKey) || !errors.Is(err, repo.ErrValidation) {
+		s.failf("%s: expected a foreign key error, got [%v]", what, err)
+	}
+}
+
+// duplicate fails unless err wraps repo.ErrDuplicate, a conflict
+func (s *suite) duplicate(what string, err error) {
+	s.t.Helper()
+
+	if !errors.Is(err, repo.ErrDuplicate) || !errors.Is(err, repo.ErrConflict) {
+		s.failf("%s: expected a duplicate entry error, got [%v]", what, err)
+	}
+}
+
+// notFound fails unless err wraps repo.ErrNotFound with the message
+func (s *suite) notFound(what string, err error, message string) {
+	s.t.Helper()
+
+	if err == nil {
+		s.failf("%s: expected [%s]", what, message)
+	} else if err.Error() != message || !errors.Is(err, repo.ErrNotFound) {
+		s.failf("%s: expected [%s], got [%v]", what, message, err)
+	}
+}
+
+func (s *suite) equal(what string, got interface{}, want interface{}) {
+	s.t.Helper()
+
+	if !reflect.DeepEqual(got, want) {
+		s.failf("%s: expected %+v, got %+v", what, want, got)
+	}
+}
+
+func (s *suite) checkUsers() (int64, int64) {
+	userA := models.User{
+		Name:         "Conformance " + s.suffix + " A",
+		Email:        "conformance-" + s.suffix + "-a@example.com",
+		Password:     "hash",
+		DateCreated:  100,
+		DateUpdated:  100,
+		Role:         "user",
+		TokenVersion: 1,
+		TOTPSecret:   "secret",
+	}
+	result, err := s.r.AddUser(s.ctx, userA)
+	userA.UserId = s.insertId("AddUser", result, err)
+
+	userB := models.User{
+		Name:        "Conformance " + s.suffix + " B",
+		Email:       "conformance-" + s.suffix + "-b@example.com",
+		DateCreated: 100,
+		Role:        "admin",
+	}
+	result, err = s.r.AddUser(s.ctx, userB)
+	userB.UserId = s.insertId("AddUser", result, err)
+
+	if userA.UserId == 0 || userB.UserId == 0 {
+		return 0, 0
+	}
+
+	if userB.UserId <= userA.UserId {
+		s.failf("AddUser: expected increasing ids, got %d then %d", userA.UserId, userB.UserId)
+	}
+
+	user, err := s.r.GetUserById(s.ctx, userA.UserId)
+	if s.ok("GetUserById", err) {
+		s.equal("GetUserById", *user, userA)
+	}
+
+	_, err = s.r.GetUserById(s.ctx, 0)
+	s.notFound("GetUserById of an unknown user", err, "user not found")
+
+	user, err = s.r.GetUserByEmail(s.ctx, strings.ToUpper(userA.Email))
+	if s.ok("GetUserByEmail is case insensitive", err) {
+		s.equal("GetUserByEmail is case insensitive", user.UserId, userA.UserId)
+	}
+
+	_, err = s.r.GetUserByEmail(s.ctx, "unknown-"+userA.Email)
+	s.notFound("GetUserByEmail of an unknown email", err, "user not found")
+
+	_, err = s.r.AddUser(s.ctx, models.User{Name: "Conformance " + s.suffix + " C", Email: strings.ToUpper(userA.Email), Role: "user"})
+	s.duplicate("AddUser with a taken email", err)
+
+	taken := userB
+	taken.Email = strings.ToUpper(userA.Email)
+	_, err = s.r.UpdateUser(s.ctx, taken)
+	s.duplicate("UpdateUser with a taken email", err)
+
+	user, err = s.r.GetUserById(s.ctx, userB.UserId)
+	if s.ok("GetUserById after UpdateUser with a taken email", err) {
+		s.equal("GetUserById after UpdateUser with a taken email", *user, userB)
+	}
+
+	userA.Name += " changed"
+	result, err = s.r.UpdateUser(s.ctx, userA)
+	s.affected("UpdateUser", result, err, 1)
+
+	user, err = s.r.GetUserById(s.ctx, userA.UserId)
+	if s.ok("GetUserById after UpdateUser", err) {
+		s.equal("GetUserById after UpdateUser", *user, userA)
+	}
+
+	result, err = s.r.UseTOTPStep(s.ctx, userA.UserId, 10)
+	s.affected("UseTOTPStep", result, err, 1)
+
+	result, err = s.r.UseTOTPStep(s.ctx, userA.UserId, 10)
+	s.affected("UseTOTPStep of a used step", result, err, 0)
+
+	result, err = s.r.UseTOTPStep(s.ctx, userA.UserId, 9)
+	s.affected("UseTOTPStep of an earlier step", result, err, 0)
+
+	user, err = s.r.GetUserById(s.ctx, userA.UserId)
+	if s.ok("GetUserById after UseTOTPStep", err) {
+		s.equal("GetUserById after UseTOTPStep", user.TOTPLastStep, int64(10))
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err = s.r.IncrementFailedLoginAttempt(s.ctx, userA.UserId)
+		s.affected("IncrementFailedLoginAttempt", result, err, 1)
+	}
+
+	result, err = s.r.LockLogin(s.ctx, userA.UserId, 3, 2000000000)
+	s.affected("LockLogin below the limit", result, err, 0)
+
+	result, err = s.r.IncrementFailedLoginAttempt(s.ctx, userA.UserId)
+	s.affected("IncrementFailedLoginAttempt", result, err, 1)
+
+	result, err = s.r.LockLogin(s.ctx, userA.UserId, 3, 2000000000)
+	s.affected("LockLogin at the limit", result, err, 1)
+
+	user, err = s.r.GetUserById(s.ctx, userA.UserId)
+	if s.ok("GetUserById after LockLogin", err) {
+		s.equal("FailedLoginAttempt after LockLogin", user.FailedLoginAttempt, 0)
+		s.equal("LockedUntil after LockLogin", user.LockedUntil, int64(2000000000))
+	}
+
+	result, err = s.r.IncrementFailedLoginAttempt(s.ctx, userA.UserId)
+	s.affected("IncrementFailedLoginAttempt", result, err, 1)
+
+	result, err = s.r.RecordLogin(s.ctx, userA.UserId, 1700000300)
+	s.affected("RecordLogin", result, err, 1)
+
+	user, err = s.r.GetUserById(s.ctx, userA.UserId)
+	if s.ok("GetUserById after RecordLogin", err) {
+		s.equal("LastLogin after RecordLogin", user.LastLogin, 1700000300)
+		s.equal("FailedLoginAttempt after RecordLogin", user.FailedLoginAttempt, 0)
+		s.equal("LockedUntil after RecordLogin", user.LockedUntil, int64(0))
+	}
+
+	result, err = s.r.RehashPassword(s.ctx, userA.UserId, "not the hash", "new hash")
+	s.affected("RehashPassword of a changed password", result, err, 0)
+
+	result, err = s.r.RehashPassword(s.ctx, userA.UserId, userA.Password, "new hash")
+	s.affected("RehashPassword", result, err, 1)
+
+	user, err = s.r.GetUserById(s.ctx, userA.UserId)
+	if s.ok("GetUserById after RehashPassword", err) {
+		s.equal("Password after RehashPassword", user.Password, "new hash")
+	}
+
+	users, err := s.r.SearchUsers(s.ctx, strings.ToUpper(s.suffix), 0, 10)
+	if s.ok("SearchUsers", err) {
+		s.equal("SearchUsers", userIds(users), []int64{userA.UserId, userB.UserId})
+	}
+
+	users, err = s.r.SearchUsers(s.ctx, s.suffix, userA.UserId, 10)
+	if s.ok("SearchUsers after the last id", err) {
+		s.equal("SearchUsers after the last id", userIds(users), []int64{userB.UserId})
+	}
+
+	users, err = s.r.SearchUsers(s.ctx, s.suffix, 0, 1)
+	if s.ok("SearchUsers with a limit", err) {
+		s.equal("SearchUsers with a limit", userIds(users), []int64{userA.UserId})
+	}
+
+	users, err = s.r.SearchUsers(s.ctx, s.suffix+"%", 0, 10)
+	if s.ok("SearchUsers with a wildcard", err) {
+		s.equal("SearchUsers matches the wildcards literally", len(users), 0)
+	}
+
+	userB.DeletionScheduledAt = 1000
+	result, err = s.r.UpdateUser(s.ctx, userB)
+	s.affected("UpdateUser deletion scheduled", result, err, 1)
+
+	users, err = s.r.GetUsersDueForDeletion(s.ctx, 999, 1000)
+	if s.ok("GetUsersDueForDeletion", err) && containsUser(users, userB.UserId) {
+		s.failf("GetUsersDueForDeletion: user %d isn't due yet", userB.UserId)
+	}
+
+	users, err = s.r.GetUsersDueForDeletion(s.ctx, 1000, 1000)
+	if s.ok("GetUsersDueForDeletion", err) && (!containsUser(users, userB.UserId) || containsUser(users, userA.UserId)) {
+		s.failf("GetUsersDueForDeletion: expected user %d and not user %d", userB.UserId, userA.UserId)
+	}
+
+	return userA.UserId, userB.UserId
+}
+
+func (s *suite) checkTasks(userA int64, userB int64) {
+	taskIds := make([]int64, 3)
+	for i := range taskIds {
+		result, err := s.r.AddTask(s.ctx, models.Task{UserId: userA, Title: fmt.Sprintf("task %d", i), DateCreated: 100})
+		taskIds[i] = s.insertId("AddTask", result, err)
+	}
+
+	_, err := s.r.AddTask(s.ctx, models.Task{UserId: 0, Title: "no owner"})
+	s.foreignKey("AddTask of an unknown user", err)
+
+	tasks, err := s.r.GetAllTasks(s.ctx, 0, userA, 10)
+	if s.ok("GetAllTasks", err) {
+		s.equal("GetAllTasks is newest first", taskIdsOf(tasks), []int64{taskIds[2], taskIds[1], taskIds[0]})
+	}
+
+	tasks, err = s.r.GetAllTasks(s.ctx, taskIds[0], userA, 1)
+	if s.ok("GetAllTasks after the last id", err) {
+		s.equal("GetAllTasks after the last id", taskIdsOf(tasks), []int64{taskIds[2]})
+	}
+
+	tasks, err = s.r.GetAllTasks(s.ctx, 0, userB, 10)
+	if s.ok("GetAllTasks of another user", err) {
+		s.equal("GetAllTasks of another user", len(tasks), 0)
+	}
+
+	task, err := s.r.GetTaskById(s.ctx, taskIds[1], userA)
+	if s.ok("GetTaskById", err) {
+		s.equal("GetTaskById", *task, models.Task{TaskId: taskIds[1], UserId: userA, Title: "task 1", DateCreated: 100})
+	}
+
+	_, err = s.r.GetTaskById(s.ctx, taskIds[1], userB)
+	s.notFound("GetTaskById of another user", err, "task not found")
+
+	tasks, err = s.r.GetTasksByUser(s.ctx, userA, 0, 2)
+	if s.ok("GetTasksByUser", err) {
+		s.equal("GetTasksByUser is oldest first", taskIdsOf(tasks), []int64{taskIds[0], taskIds[1]})
+	}
+
+	tasks, err = s.r.GetTasksByUser(s.ctx, userA, taskIds[1], 2)
+	if s.ok("GetTasksByUser after the last id", err) {
+		s.equal("GetTasksByUser after the last id", taskIdsOf(tasks), []int64{taskIds[2]})
+	}
+}
+
+func (s *suite) checkPasswordResets(userA int64) {
+	reset := models.PasswordReset{UserId: userA, TokenHash: "reset-" + s.suffix, DateCreated: 100, ExpiresAt: 200}
+	result, err := s.r.AddPasswordReset(s.ctx, reset)
+	reset.ResetId = s.insertId("AddPasswordReset", result, err)
+
+	_, err = s.r.AddPasswordReset(s.ctx, reset)
+	s.duplicate("AddPasswordReset with a duplicate token hash", err)
+
+	got, err := s.r.GetPasswordResetByTokenHash(s.ctx, reset.TokenHash)
+	if s.ok("GetPasswordResetByTokenHash", err) {
+		s.equal("GetPasswordResetByTokenHash", *got, reset)
+	}
+
+	_, err = s.r.GetPasswordResetByTokenHash(s.ctx, "unknown-"+reset.TokenHash)
+	s.notFound("GetPasswordResetByTokenHash of an unknown hash", err, "password reset not found")
+
+	result, err = s.r.UsePasswordReset(s.ctx, reset.ResetId, 150)
+	s.affected("UsePasswordReset", result, err, 1)
+
+	result, err = s.r.UsePasswordReset(s.ctx, reset.ResetId, 160)
+	s.affected("UsePasswordReset of a used reset", result, err, 0)
+
+	result, err = s.r.AddPasswordReset(s.ctx, models.PasswordReset{UserId: userA, TokenHash: "reset-2-" + s.suffix, DateCreated: 100, ExpiresAt: 200})
+	s.insertId("AddPasswordReset", result, err)
+
+	result, err = s.r.InvalidatePasswordResets(s.ctx, userA, 170)
+	s.affected("InvalidatePasswordResets only marks the unused resets", result, err, 1)
+}
+
+func (s *suite) checkRecoveryCodes(userA int64, userB int64) {
+	for i := 0; i < 2; i++ {
+		result, err := s.r.AddRecoveryCode(s.ctx, models.RecoveryCode{UserId: userA, CodeHash: fmt.Sprintf("code-%d", i), DateCreated: 100})
+		s.insertId("AddRecoveryCode", result, err)
+	}
+
+	result, err := s.r.UseRecoveryCode(s.ctx, userB, "code-0", 150)
+	s.affected("UseRecoveryCode of another user", result, err, 0)
+
+	result, err = s.r.UseRecoveryCode(s.ctx, userA, "code-0", 150)
+	s.affected("UseRecoveryCode", result, err, 1)
+
+	result, err = s.r.UseRecoveryCode(s.ctx, userA, "code-0", 160)
+	s.affected("UseRecoveryCode of a used code", result, err, 0)
+
+	result, err = s.r.DeleteRecoveryCodes(s.ctx, userA)
+	s.affected("DeleteRecoveryCodes", result, err, 2)
+}
+
+func (s *suite) checkAccessTokens(userA int64, userB int64) {
+	tokens := []models.AccessToken{
+		{UserId: userA, Name: "first", TokenHash: "token-1-" + s.suffix, Scopes: []string{"tasks:read", "tasks:write"}, DateCreated: 100, ExpiresAt: 200},
+		{UserId: userA, Name: "second", TokenHash: "token-2-" + s.suffix, Scopes: []string{"tasks:read"}, DateCreated: 100},
+	}
+	for i := range tokens {
+		result, err := s.r.AddAccessToken(s.ctx, tokens[i])
+		tokens[i].TokenId = s.insertId("AddAccessToken", result, err)
+	}
+
+	_, err := s.r.AddAccessToken(s.ctx, tokens[0])
+	s.duplicate("AddAccessToken with a duplicate token hash", err)
+
+	token, err := s.r.GetAccessTokenByTokenHash(s.ctx, tokens[0].TokenHash)
+	if s.ok("GetAccessTokenByTokenHash", err) {
+		s.equal("GetAccessTokenByTokenHash", *token, tokens[0])
+	}
+
+	_, err = s.r.GetAccessTokenByTokenHash(s.ctx, "unknown-"+s.suffix)
+	s.notFound("GetAccessTokenByTokenHash of an unknown hash", err, "access token not found")
+
+	list, err := s.r.GetAccessTokens(s.ctx, userA)
+	if s.ok("GetAccessTokens", err) {
+		s.equal("GetAccessTokens is newest first", list, []models.AccessToken{tokens[1], tokens[0]})
+	}
+
+	result, err := s.r.RevokeAccessToken(s.ctx, tokens[0].TokenId, userB, 150)
+	s.affected("RevokeAccessToken of another user", result, err, 0)
+
+	result, err = s.r.RevokeAccessToken(s.ctx, tokens[0].TokenId, userA, 150)
+	s.affected("RevokeAccessToken", result, err, 1)
+
+	result, err = s.r.RevokeAccessToken(s.ctx, tokens[0].TokenId, userA, 160)
+	s.affected("RevokeAccessToken of a revoked token", result, err, 0)
+
+	list, err = s.r.GetAccessTokens(s.ctx, userA)
+	if s.ok("GetAccessTokens after RevokeAccessToken", err) {
+		s.equal("GetAccessTokens leaves out the revoked tokens", list, []models.AccessToken{tokens[1]})
+	}
+
+	result, err = s.r.UpdateAccessTokenLastUsed(s.ctx, tokens[1].TokenId, 170)
+	s.affected("UpdateAccessTokenLastUsed", result, err, 1)
+
+	result, err = s.r.RevokeUserAccessTokens(s.ctx, userB, 180)
+	s.affected("RevokeUserAccessTokens of another user", result, err, 0)
+
+	result, err = s.r.RevokeUserAccessTokens(s.ctx, userA, 180)
+	s.affected("RevokeUserAccessTokens", result, err, 1)
+
+	list, err = s.r.GetAccessTokens(s.ctx, userA)
+	if s.ok("GetAccessTokens after RevokeUserAccessTokens", err) && len(list) != 0 {
+		s.failf("GetAccessTokens after RevokeUserAccessTokens: expected no tokens, got %d", len(list))
+	}
+}
+
+func (s *suite) checkOAuth(userA int64) {
+	clients := []models.OAuthClient{
+		{ClientId: "client-1-" + s.suffix, UserId: userA, Name: "first", RedirectURIs: []string{"https://a.example.com/cb", "https://b.example.com/cb"},
+			Scopes: []string{"tasks:read"}, DateCreated: 100},
+		{ClientId: "client-2-" + s.suffix, UserId: userA, Name: "second", ClientSecretHash: "secret", RedirectURIs: []string{"https://c.example.com/cb"},
+			Scopes: []string{"tasks:read", "tasks:write"}, Confidential: true, DateCreated: 200},
+	}
+	for _, client := range clients {
+		_, err := s.r.AddOAuthClient(s.ctx, client)
+		s.ok("AddOAuthClient", err)
+	}
+
+	_, err := s.r.AddOAuthClient(s.ctx, clients[0])
+	s.duplicate("AddOAuthClient with a duplicate client id", err)
+
+	client, err := s.r.GetOAuthClientById(s.ctx, clients[0].ClientId)
+	if s.ok("GetOAuthClientById", err) {
+		s.equal("GetOAuthClientById", *client, clients[0])
+	}
+
+	_, err = s.r.GetOAuthClientById(s.ctx, "unknown-"+s.suffix)
+	s.notFound("GetOAuthClientById of an unknown client", err, "oauth client not found")
+
+	list, err := s.r.GetOAuthClients(s.ctx, userA)
+	if s.ok("GetOAuthClients", err) {
+		s.equal("GetOAuthClients is newest first", list, []models.OAuthClient{clients[1], clients[0]})
+	}
+
+	code := models.OAuthCode{CodeHash: "code-" + s.suffix, ClientId: clients[0].ClientId, UserId: userA, RedirectURI: clients[0].RedirectURIs[0],
+		Scopes: []string{"tasks:read"}, CodeChallenge: "challenge", CodeChallengeMethod: "S256", ExpiresAt: 200}
+	_, err = s.r.AddOAuthCode(s.ctx, code)
+	s.ok("AddOAuthCode", err)
+
+	_, err = s.r.AddOAuthCode(s.ctx, code)
+	s.duplicate("AddOAuthCode with a duplicate code hash", err)
+
+	got, err := s.r.GetOAuthCodeByCodeHash(s.ctx, code.CodeHash)
+	if s.ok("GetOAuthCodeByCodeHash", err) {
+		s.equal("GetOAuthCodeByCodeHash", *got, code)
+	}
+
+	_, err = s.r.GetOAuthCodeByCodeHash(s.ctx, "unknown-"+s.suffix)
+	s.notFound("GetOAuthCodeByCodeHash of an unknown hash", err, "oauth code not found")
+
+	result, err := s.r.UseOAuthCode(s.ctx, code.CodeHash, 150)
+	s.affected("UseOAuthCode", result, err, 1)
+
+	result, err = s.r.UseOAuthCode(s.ctx, code.CodeHash, 160)
+	s.affected("UseOAuthCode of a used code", result, err, 0)
+
+	_, err = s.r.GetOAuthConsent(s.ctx, userA, clients[0].ClientId)
+	s.notFound("GetOAuthConsent before SaveOAuthConsent", err, "oauth consent not found")
+
+	consent := models.OAuthConsent{UserId: userA, ClientId: clients[0].ClientId, Scopes: []string{"tasks:read"}, DateCreated: 100, DateUpdated: 100}
+	result, err = s.r.SaveOAuthConsent(s.ctx, consent)
+	s.affected("SaveOAuthConsent inserts", result, err, 1)
+
+	consent.Scopes = []string{"tasks:read", "tasks:write"}
+	consent.DateUpdated = 150
+	_, err = s.r.SaveOAuthConsent(s.ctx, models.OAuthConsent{UserId: userA, ClientId: clients[0].ClientId, Scopes: consent.Scopes, DateCreated: 150, DateUpdated: 150})
+	s.ok("SaveOAuthConsent updates", err)
+
+	gotConsent, err := s.r.GetOAuthConsent(s.ctx, userA, clients[0].ClientId)
+	if s.ok("GetOAuthConsent", err) {
+		s.equal("GetOAuthConsent keeps the creation date", *gotConsent, consent)
+	}
+
+	tokenId := "jti-" + s.suffix
+	revoked, err := s.r.IsTokenRevoked(s.ctx, tokenId)
+	if s.ok("IsTokenRevoked", err) && revoked {
+		s.failf("IsTokenRevoked: token %s isn't revoked yet", tokenId)
+	}
+
+	result, err = s.r.AddRevokedToken(s.ctx, tokenId, 200, 100)
+	s.affected("AddRevokedToken", result, err, 1)
+
+	result, err = s.r.AddRevokedToken(s.ctx, tokenId, 200, 100)
+	s.affected("AddRevokedToken of a revoked token", result, err, 0)
+
+	revoked, err = s.r.IsTokenRevoked(s.ctx, tokenId)
+	if s.ok("IsTokenRevoked", err) && !revoked {
+		s.failf("IsTokenRevoked: token %s is revoked", tokenId)
+	}
+}
+
+func (s *suite) checkIdentities(userA int64) {
+	identity := models.UserIdentity{UserId: userA, Issuer: "https://issuer.example.com", Subject: "subject-" + s.suffix, Email: "a@example.com", DateCreated: 100, LastLogin: 100}
+	result, err := s.r.AddUserIdentity(s.ctx, identity)
+	identity.IdentityId = s.insertId("AddUserIdentity", result, err)
+
+	_, err = s.r.AddUserIdentity(s.ctx, identity)
+	s.duplicate("AddUserIdentity with a duplicate issuer and subject", err)
+
+	result, err = s.r.UpdateUserIdentityLastLogin(s.ctx, identity.IdentityId, 150)
+	s.affected("UpdateUserIdentityLastLogin", result, err, 1)
+	identity.LastLogin = 150
+
+	got, err := s.r.GetUserIdentity(s.ctx, identity.Issuer, identity.Subject)
+	if s.ok("GetUserIdentity", err) {
+		s.equal("GetUserIdentity", *got, identity)
+	}
+
+	_, err = s.r.GetUserIdentity(s.ctx, identity.Issuer, "unknown-"+s.suffix)
+	s.notFound("GetUserIdentity of an unknown subject", err, "user identity not found")
+
+	identities, err := s.r.GetUserIdentities(s.ctx, userA)
+	if s.ok("GetUserIdentities", err) {
+		s.equal("GetUserIdentities", identities, []models.UserIdentity{identity})
+	}
+}
+
+func (s *suite) checkAuditLog(userA int64, userB int64) {
+	auditIds := make([]int64, 3)
+	for i, target := range []int64{userA, userB, userA} {
+		result, err := s.r.AddAuditEntry(s.ctx, models.AuditEntry{ActorUserId: userB, Action: "conformance", TargetUserId: target, DateCreated: 100})
+		auditIds[i] = s.insertId("AddAuditEntry", result, err)
+	}
+
+	entries, err := s.r.GetAuditEntries(s.ctx, userA, 0, 10)
+	if s.ok("GetAuditEntries", err) {
+		s.equal("GetAuditEntries is oldest first", auditIdsOf(entries), []int64{auditIds[0], auditIds[2]})
+	}
+
+	entries, err = s.r.GetAuditEntries(s.ctx, 0, auditIds[0], 2)
+	if s.ok("GetAuditEntries of all the users", err) {
+		s.equal("GetAuditEntries of all the users", auditIdsOf(entries), []int64{auditIds[1], auditIds[2]})
+	}
+}
+
+func (s *suite) checkSessions(userA int64, userB int64) {
+	sessions := []models.Session{
+		{UserId: userA, DeviceName: "first", UserAgent: "agent", IP: "127.0.0.1", DateCreated: 100, LastSeen: 100, ExpiresAt: 5000},
+		{UserId: userA, DeviceName: "second", DateCreated: 100, LastSeen: 200, ExpiresAt: 5000},
+		{UserId: userA, DeviceName: "expired", DateCreated: 100, LastSeen: 300, ExpiresAt: 500},
+	}
+	for i := range sessions {
+		result, err := s.r.AddSession(s.ctx, sessions[i])
+		sessions[i].SessionId = s.insertId("AddSession", result, err)
+	}
+
+	_, err := s.r.AddSession(s.ctx, models.Session{UserId: 0})
+	s.foreignKey("AddSession of an unknown user", err)
+
+	list, err := s.r.GetSessions(s.ctx, userA, 1000)
+	if s.ok("GetSessions", err) {
+		s.equal("GetSessions is last seen first without the expired", list, []models.Session{sessions[1], sessions[0]})
+	}
+
+	result, err := s.r.RevokeSession(s.ctx, sessions[0].SessionId, userB, 150)
+	s.affected("RevokeSession of another user", result, err, 0)
+
+	result, err = s.r.RevokeSession(s.ctx, sessions[0].SessionId, userA, 150)
+	s.affected("RevokeSession", result, err, 1)
+	sessions[0].DateRevoked = 150
+
+	result, err = s.r.RevokeSession(s.ctx, sessions[0].SessionId, userA, 160)
+	s.affected("RevokeSession of a revoked session", result, err, 0)
+
+	result, err = s.r.UpdateSessionLastSeen(s.ctx, sessions[1].SessionId, 400)
+	s.affected("UpdateSessionLastSeen", result, err, 1)
+	sessions[1].LastSeen = 400
+
+	list, err = s.r.GetSessions(s.ctx, userA, 1000)
+	if s.ok("GetSessions after RevokeSession", err) {
+		s.equal("GetSessions leaves out the revoked sessions", list, []models.Session{sessions[1]})
+	}
+
+	session, err := s.r.GetSessionById(s.ctx, sessions[0].SessionId)
+	if s.ok("GetSessionById", err) {
+		s.equal("GetSessionById", *session, sessions[0])
+	}
+
+	_, err = s.r.GetSessionById(s.ctx, 0)
+	s.notFound("GetSessionById of an unknown session", err, "session not found")
+
+	list, err = s.r.GetUserSessions(s.ctx, userA)
+	if s.ok("GetUserSessions", err) {
+		s.equal("GetUserSessions has all the sessions oldest first", list, sessions)
+	}
+
+	result, err = s.r.RevokeUserSessions(s.ctx, userA, 450)
+	s.affected("RevokeUserSessions", result, err, 2)
+
+	result, err = s.r.RevokeUserSessions(s.ctx, userA, 460)
+	s.affected("RevokeUserSessions of revoked sessions", result, err, 0)
+
+	list, err = s.r.GetSessions(s.ctx, userA, 100)
+	if s.ok("GetSessions after RevokeUserSessions", err) && len(list) != 0 {
+		s.failf("GetSessions after RevokeUserSessions: expected no sessions, got %d", len(list))
+	}
+}
+
+func (s *suite) checkExportJobs(userA int64, userB int64) {
+	jobs := []models.ExportJob{
+		{UserId: userA, Status: models.ExportStatusPending, DateCreated: 100},
+		{UserId: userA, Status: models.ExportStatusDone, DateCreated: 100, DateCompleted: 110, ExpiresAt: 150},
+		{UserId: userA, Status: models.ExportStatusFailed, Error: "failed", DateCreated: 100, DateCompleted: 110},
+	}
+	for i := range jobs {
+		result, err := s.r.AddExportJob(s.ctx, jobs[i])
+		jobs[i].JobId = s.insertId("AddExportJob", result, err)
+	}
+
+	_, err := s.r.AddExportJob(s.ctx, models.ExportJob{UserId: userA, Status: models.ExportStatusPending, DateCreated: 120})
+	s.duplicate("AddExportJob of a second pending job", err)
+
+	list, err := s.r.GetExportJobs(s.ctx, userA)
+	if s.ok("GetExportJobs", err) {
+		s.equal("GetExportJobs is newest first", list, []models.ExportJob{jobs[2], jobs[1], jobs[0]})
+	}
+
+	job, err := s.r.GetExportJob(s.ctx, jobs[0].JobId, userA)
+	if s.ok("GetExportJob", err) {
+		s.equal("GetExportJob", *job, jobs[0])
+	}
+
+	_, err = s.r.GetExportJob(s.ctx, jobs[0].JobId, userB)
+	s.notFound("GetExportJob of another user", err, "export job not found")
+
+	list, err = s.r.GetStaleExportJobs(s.ctx, 200, 100, 50, 1000)
+	if s.ok("GetStaleExportJobs", err) {
+		var own []int64
+		for _, job := range list {
+			if job.UserId == userA {
+				own = append(own, job.JobId)
+			}
+		}
+		s.equal("GetStaleExportJobs", own, []int64{jobs[0].JobId, jobs[1].JobId})
+	}
+
+	jobs[0].Status = models.ExportStatusDone
+	jobs[0].DateCompleted = 120
+	jobs[0].ExpiresAt = 300
+	result, err := s.r.UpdateExportJob(s.ctx, jobs[0])
+	s.affected("UpdateExportJob", result, err, 1)
+
+	result, err = s.r.DeleteExportJob(s.ctx, jobs[2].JobId)
+	s.affected("DeleteExportJob", result, err, 1)
+
+	result, err = s.r.DeleteExportJob(s.ctx, jobs[2].JobId)
+	s.affected("DeleteExportJob of a deleted job", result, err, 0)
+}
+
+func (s *suite) checkSecurityEvents(userA int64, userB int64) {
+	events := []models.SecurityEvent{
+		{UserId: userA, Type: "conformance.a", Details: "details", IP: "127.0.0.1", UserAgent: "agent", DateCreated: 1},
+		{UserId: userA, Type: "conformance.b", DateCreated: 2},
+		{UserId: userB, Type: "conformance.a", DateCreated: 3},
+		{UserId: userA, Type: "conformance.a", DateCreated: 4},
+	}
+	for i := range events {
+		result, err := s.r.AddSecurityEvent(s.ctx, events[i])
+		events[i].EventId = s.insertId("AddSecurityEvent", result, err)
+	}
+
+	list, err := s.r.GetSecurityEvents(s.ctx, userA, "", 0, 10)
+	if s.ok("GetSecurityEvents", err) {
+		s.equal("GetSecurityEvents is oldest first", list, []models.SecurityEvent{events[0], events[1], events[3]})
+	}
+
+	list, err = s.r.GetSecurityEvents(s.ctx, userA, "conformance.a", 0, 10)
+	if s.ok("GetSecurityEvents of a type", err) {
+		s.equal("GetSecurityEvents of a type", list, []models.SecurityEvent{events[0], events[3]})
+	}
+
+	list, err = s.r.GetSecurityEvents(s.ctx, 0, "", events[0].EventId, 2)
+	if s.ok("GetSecurityEvents of all the users", err) {
+		s.equal("GetSecurityEvents of all the users", list, []models.SecurityEvent{events[1], events[2]})
+	}
+
+	// the events are the oldest of the store, the limit is kept whichever ones are deleted first
+	result, err := s.r.DeleteSecurityEventsBefore(s.ctx, 3, 1)
+	s.affected("DeleteSecurityEventsBefore with a limit", result, err, 1)
+
+	result, err = s.r.DeleteSecurityEventsBefore(s.ctx, 3, 10)
+	s.affected("DeleteSecurityEventsBefore", result, err, 1)
+
+	list, err = s.r.GetSecurityEvents(s.ctx, userA, "", 0, 10)
+	if s.ok("GetSecurityEvents after DeleteSecurityEventsBefore", err) {
+		s.equal("GetSecurityEvents after DeleteSecurityEventsBefore", list, []models.SecurityEvent{events[3]})
+	}
+}
+
+func (s *suite) checkTx(userId int64) {
+	errRollback := errors.New("rollback")
+
+	var committedId int64
+	err := s.r.WithTx(s.ctx, func(tx repo.IAppRepo) error {
+		result, err := tx.AddTask(s.ctx, models.Task{UserId: userId, Title: "committed"})
+		committedId = s.insertId("AddTask in WithTx", result, err)
+		return err
+	})
+	if s.ok("WithTx", err) {
+		_, err = s.r.GetTaskById(s.ctx, committedId, userId)
+		s.ok("GetTaskById of a committed task", err)
+	}
+
+	var rolledBackId int64
+	err = s.r.WithTx(s.ctx, func(tx repo.IAppRepo) error {
+		result, err := tx.AddTask(s.ctx, models.Task{UserId: userId, Title: "rolled back"})
+		rolledBackId = s.insertId("AddTask in WithTx", result, err)
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		s.failf("WithTx: expected the error of the function, got [%v]", err)
+	}
+
+	_, err = s.r.GetTaskById(s.ctx, rolledBackId, userId)
+	s.notFound("GetTaskById of a rolled back task", err, "task not found")
+
+	var keptId, droppedId int64
+	err = s.r.WithTx(s.ctx, func(tx repo.IAppRepo) error {
+		result, err := tx.AddTask(s.ctx, models.Task{UserId: userId, Title: "kept"})
+		keptId = s.insertId("AddTask before a savepoint", result, err)
+
+		txCtx := repo.ContextWithTx(s.ctx, tx)
+		err = s.r.WithTx(txCtx, func(savepoint repo.IAppRepo) error {
+			result, err := savepoint.AddTask(txCtx, models.Task{UserId: userId, Title: "dropped"})
+			droppedId = s.insertId("AddTask in a savepoint", result, err)
+
+			_, err = s.r.GetTaskById(txCtx, droppedId, userId)
+			s.ok("GetTaskById with a context of ContextWithTx", err)
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			s.failf("WithTx of a savepoint: expected the error of the function, got [%v]", err)
+		}
+
+		_, err = tx.GetTaskById(s.ctx, keptId, userId)
+		return err
+	})
+	if s.ok("WithTx with a savepoint rolled back", err) {
+		_, err = s.r.GetTaskById(s.ctx, keptId, userId)
+		s.ok("GetTaskById of a task before a rolled back savepoint", err)
+
+		_, err = s.r.GetTaskById(s.ctx, droppedId, userId)
+		s.notFound("GetTaskById of a task in a rolled back savepoint", err, "task not found")
+	}
+}
+
+func (s *suite) checkDeleteUser(userA int64, userB int64) {
+	if !s.ok("DeleteUser", s.r.DeleteUser(s.ctx, userA, true)) {
+		return
+	}
+
+	_, err := s.r.GetUserById(s.ctx, userA)
+	s.notFound("GetUserById of a deleted user", err, "user not found")
+
+	tasks, err := s.r.GetTasksByUser(s.ctx, userA, 0, 10)
+	if s.ok("GetTasksByUser of a deleted user", err) {
+		s.equal("GetTasksByUser of a deleted user", len(tasks), 0)
+	}
+
+	tokens, err := s.r.GetAccessTokens(s.ctx, userA)
+	if s.ok("GetAccessTokens of a deleted user", err) {
+		s.equal("GetAccessTokens of a deleted user", len(tokens), 0)
+	}
+
+	_, err = s.r.GetOAuthClientById(s.ctx, "client-1-"+s.suffix)
+	s.notFound("GetOAuthClientById of a deleted user", err, "oauth client not found")
+
+	_, err = s.r.GetOAuthCodeByCodeHash(s.ctx, "code-"+s.suffix)
+	s.notFound("GetOAuthCodeByCodeHash of a deleted user", err, "oauth code not found")
+
+	_, err = s.r.GetPasswordResetByTokenHash(s.ctx, "reset-"+s.suffix)
+	s.notFound("GetPasswordResetByTokenHash of a deleted user", err, "password reset not found")
+
+	identities, err := s.r.GetUserIdentities(s.ctx, userA)
+	if s.ok("GetUserIdentities of a deleted user", err) {
+		s.equal("GetUserIdentities of a deleted user", len(identities), 0)
+	}
+
+	sessions, err := s.r.GetUserSessions(s.ctx, userA)
+	if s.ok("GetUserSessions of a deleted user", err) {
+		s.equal("GetUserSessions of a deleted user", len(sessions), 0)
+	}
+
+	jobs, err := s.r.GetExportJobs(s.ctx, userA)
+	if s.ok("GetExportJobs of a deleted user", err) {
+		s.equal("GetExportJobs of a deleted user", len(jobs), 0)
+	}
+
+	events, err := s.r.GetSecurityEvents(s.ctx, userA, "", 0, 10)
+	if s.ok("GetSecurityEvents of a deleted user", err) {
+		s.equal("GetSecurityEvents of a deleted user", len(events), 0)
+	}
+
+	s.ok("DeleteUser", s.r.DeleteUser(s.ctx, userB, false))
+	_, err = s.r.GetUserById(s.ctx, userB)
+	s.notFound("GetUserById of a deleted user", err, "user not found")
+}
+
+func userIds(users []models.User) []int64 {
+	ids := make([]int64, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.UserId)
+	}
+
+	return ids
+}
+
+func containsUser(users []models.User, userId int64) bool {
+	for _, user := range users {
+		if user.UserId == userId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func taskIdsOf(tasks []models.Task) []int64 {
+	ids := make([]int64, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.TaskId)
+	}
+
+	return ids
+}
+
+func auditIdsOf(entries []models.AuditEntry) []int64 {
+	ids := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.AuditId)
+	}
+
+	return ids
+}
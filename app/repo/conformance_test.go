@@ -0,0 +1,73 @@
+package repo_test
+
+import (
+	"context"
+	"gos/app/config"
+	"gos/app/repo"
+	"gos/app/repo/conformance"
+	"gos/app/repo/migrate"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryRepoConformance(t *testing.T) {
+	conformance.RunConformance(t, func() repo.IAppRepo {
+		return repo.NewMemoryRepo()
+	})
+}
+
+func TestSQLiteRepoConformance(t *testing.T) {
+	conformance.RunConformance(t, migratedRepo(t, "sqlite://"+filepath.Join(t.TempDir(), "gos.db")))
+}
+
+// the mysql and postgres checks need a scratch database, its DSN is given like GOS_DB_DSN
+func TestMySQLRepoConformance(t *testing.T) {
+	conformance.RunConformance(t, migratedRepo(t, scratchDSN(t, "GOS_TEST_MYSQL_DSN")))
+}
+
+func TestPostgresRepoConformance(t *testing.T) {
+	conformance.RunConformance(t, migratedRepo(t, scratchDSN(t, "GOS_TEST_POSTGRES_DSN")))
+}
+
+// scratchDSN reads the DSN of a scratch database from the variable, the test is skipped without it
+func scratchDSN(t *testing.T, variable string) string {
+	dsn := os.Getenv(variable)
+	if len(dsn) == 0 {
+		t.Skipf("%s is not set", variable)
+	}
+
+	return dsn
+}
+
+// migratedRepo migrates the database of the DSN and opens the repo on it, it is closed with the test
+func migratedRepo(t *testing.T, dsn string) func() repo.IAppRepo {
+	return func() repo.IAppRepo {
+		dbConfig := config.Load().Db
+		dbConfig.DSN = dsn
+		dbConfig.Replicas = nil
+
+		con, err := repo.Connect(dbConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer con.Close()
+
+		migrator, err := migrate.New(con.DB, repo.DriverName(dbConfig))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := migrator.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		appRepo, err := repo.NewRepo(dbConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { appRepo.Close() })
+
+		return appRepo
+	}
+}
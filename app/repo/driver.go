@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"strings"
+	"time"
+)
+
+// the drivers of the repo
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+// DriverName returns the driver selected by the scheme of the DSN, or by the driver setting when the DSN has none
+func DriverName(dbConfig DbConfig) string {
+	if driver, _ := splitDSN(dbConfig.DSN); len(driver) > 0 {
+		// postgresql:// is the other scheme of the postgres urls
+		if driver == "postgresql" {
+			return DriverPostgres
+		}
+
+		return driver
+	}
+
+	if len(dbConfig.Driver) == 0 {
+		return DriverMySQL
+	}
+
+	return dbConfig.Driver
+}
+
+// NewRepo opens the repo of the configured driver, with its read replicas
+func NewRepo(dbConfig DbConfig) (IAppRepo, error) {
+	driver := DriverName(dbConfig)
+	if driver == DriverMemory {
+		if len(dbConfig.Replicas) > 0 {
+			return nil, errors.New("the memory driver has no replicas")
+		}
+
+		return NewMemoryRepo(), nil
+	}
+
+	dialect := mysqlDialect
+	switch driver {
+	case DriverPostgres:
+		dialect = postgresDialect
+	case DriverSQLite:
+		dialect = sqliteDialect
+	}
+
+	appRepo, err := openAppRepo(dbConfig, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dbConfig.Replicas) == 0 {
+		return appRepo, nil
+	}
+
+	replicaRepos := make([]*AppRepo, 0, len(dbConfig.Replicas))
+	for i, dsn := range dbConfig.Replicas {
+		replicaConfig := dbConfig
+		replicaConfig.DSN = dsn
+		replicaConfig.Replicas = nil
+
+		replicaRepo, err := openReplica(replicaConfig, driver, dialect)
+		if err != nil {
+			for _, opened := range replicaRepos {
+				opened.Close()
+			}
+			appRepo.Close()
+
+			return nil, fmt.Errorf("db replica %d: %w", i, err)
+		}
+
+		replicaRepos = append(replicaRepos, replicaRepo)
+	}
+
+	appRepo.replicas = newReplicaSet(replicaRepos, dbConfig.ReplicaCheckInterval, dbConfig.ReadYourWritesWindow)
+	return appRepo, nil
+}
+
+// openAppRepo connects to the database and prepares the statements of the repo on it
+func openAppRepo(dbConfig DbConfig, dialect sqlDialect) (*AppRepo, error) {
+	con, err := Connect(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	appRepo, err := prepareAppRepo(con, dialect)
+	if err != nil {
+		con.Close()
+		return nil, err
+	}
+
+	return appRepo, nil
+}
+
+// openReplica opens a read replica, it must be of the driver of the primary
+func openReplica(replicaConfig DbConfig, driver string, dialect sqlDialect) (*AppRepo, error) {
+	if replicaDriver := DriverName(replicaConfig); replicaDriver != driver {
+		return nil, fmt.Errorf("the replica is %s, the primary is %s", replicaDriver, driver)
+	}
+
+	return openAppRepo(replicaConfig, dialect)
+}
+
+// maxConnectBackoff caps the wait between the retries of a database which isn't ready yet
+const maxConnectBackoff = 30 * time.Second
+
+// Connect opens the database of the configured driver, the memory driver has none
+func Connect(dbConfig DbConfig) (*sqlx.DB, error) {
+	_, name := splitDSN(dbConfig.DSN)
+
+	switch driver := DriverName(dbConfig); driver {
+	case DriverMySQL:
+		return connectMySQL(dbConfig)
+	case DriverPostgres:
+		// lib/pq takes the whole url, the scheme included
+		if len(dbConfig.DSN) == 0 {
+			dataSourceName, err := postgresDataSourceName(dbConfig)
+			if err != nil {
+				return nil, err
+			}
+
+			return connect("postgres", dataSourceName, dbConfig, postgresDialect)
+		}
+
+		return connect("postgres", dbConfig.DSN, dbConfig, postgresDialect)
+	case DriverSQLite:
+		if len(name) == 0 {
+			return nil, fmt.Errorf("the sqlite DSN [%s] has no path", dbConfig.DSN)
+		}
+
+		return connectSQLite(name, dbConfig.BusyTimeout)
+	case DriverMemory:
+		return nil, errors.New("the memory driver has no database")
+	default:
+		return nil, fmt.Errorf("unknown db driver [%s]", driver)
+	}
+}
+
+// connect opens a mysql or postgres database with the pool settings. A database which isn't ready yet, unreachable or
+// refusing the connections for now, is retried ConnectRetries times, the wait doubling from ConnectBackoff.
+func connect(driverName string, dataSourceName string, dbConfig DbConfig, dialect sqlDialect) (*sqlx.DB, error) {
+	backoff := dbConfig.ConnectBackoff
+	for attempt := 1; ; attempt++ {
+		con, err := sqlx.Connect(driverName, dataSourceName)
+		if err == nil {
+			con.SetMaxOpenConns(dbConfig.MaxOpenConns)
+			if dbConfig.MaxIdleConns > 0 {
+				con.SetMaxIdleConns(dbConfig.MaxIdleConns)
+			}
+			con.SetConnMaxLifetime(dbConfig.ConnMaxLifetime)
+			con.SetConnMaxIdleTime(dbConfig.ConnMaxIdleTime)
+
+			return con, nil
+		}
+
+		if attempt > dbConfig.ConnectRetries || !(isUnreachable(err) || findError(err, dialect.isUnavailable)) {
+			return nil, fmt.Errorf("%s: could not connect after %d attempt/s: %w", driverName, attempt, err)
+		}
+
+		fmt.Printf("%s isn't ready, retrying in %s: %v\n", driverName, backoff, err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// splitDSN splits a DSN into the scheme naming the driver and the data source name of the driver
+func splitDSN(dsn string) (string, string) {
+	i := strings.Index(dsn, "://")
+	if i < 0 {
+		return "", dsn
+	}
+
+	return dsn[:i], dsn[i+len("://"):]
+}
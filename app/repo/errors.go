@@ -0,0 +1,119 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// The kinds of the errors of the repo, and of the services built on it, whatever the database.
+// The callers match them with errors.Is to pick their answer, the messages are kept for the humans.
+var (
+	// ErrNotFound is wrapped by the errors of the rows which don't exist, like "user not found"
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is wrapped by the errors of the writes conflicting with the existing rows
+	ErrConflict = errors.New("conflict")
+	// ErrValidation is wrapped by the errors of the values which can't be stored
+	ErrValidation = errors.New("invalid value")
+	// ErrUnavailable is wrapped by the errors of a database which can't be reached or can't serve the call right now
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// ErrDuplicate is wrapped by the errors of the inserts violating a primary or unique key, it is a conflict
+var ErrDuplicate error = &kindError{message: "duplicate entry", kind: ErrConflict}
+
+// ErrForeignKey is wrapped by the errors of the writes referencing a row which doesn't exist, it is a validation error
+var ErrForeignKey error = &kindError{message: "referenced row not found", kind: ErrValidation}
+
+// kindError is an error of one of the kinds, with its own message
+type kindError struct {
+	message string
+	kind    error
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// notFound returns the error of a row which doesn't exist, what names the row
+func notFound(what string) error {
+	return &kindError{message: what + " not found", kind: ErrNotFound}
+}
+
+// ErrCanceled is wrapped by the errors of the calls given up because their context was done, the error of the context
+// is wrapped too: context.Canceled when the client went away, context.DeadlineExceeded when the db timeout passed
+var ErrCanceled = errors.New("db call canceled")
+
+// canceled wraps ErrCanceled and the error of ctx around err when ctx is done, the drivers report it in their own ways
+func canceled(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ErrCanceled) {
+		return err
+	}
+
+	if errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
+	}
+
+	return fmt.Errorf("%w: %w: %v", ErrCanceled, ctx.Err(), err)
+}
+
+// isUnreachable reports whether err is the failure of a connection to the database, whatever the driver
+func isUnreachable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr)
+}
+
+// findError reports whether match matches err or an error it wraps, following the wrapping of fmt and the causes of
+// pkg/errors the callers of the repo use
+func findError(err error, match func(err error) bool) bool {
+	if err == nil {
+		return false
+	}
+
+	if match(err) {
+		return true
+	}
+
+	switch wrapper := err.(type) {
+	case interface{ Cause() error }:
+		return findError(wrapper.Cause(), match)
+	case interface{ Unwrap() error }:
+		return findError(wrapper.Unwrap(), match)
+	case interface{ Unwrap() []error }:
+		for _, wrapped := range wrapper.Unwrap() {
+			if findError(wrapped, match) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// dbError classifies an error of the database for the callers of the repo: ErrCanceled when ctx is done,
+// ErrDuplicate and ErrForeignKey for the violated keys, ErrUnavailable when the database can't serve the call
+func (r *AppRepo) dbError(ctx context.Context, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return canceled(ctx, err)
+	case errors.Is(err, ErrDuplicate) || errors.Is(err, ErrForeignKey) || errors.Is(err, ErrUnavailable):
+		return err
+	case r.dialect.isDuplicate(err):
+		return fmt.Errorf("%w: %v", ErrDuplicate, err)
+	case r.dialect.isForeignKey(err):
+		return fmt.Errorf("%w: %v", ErrForeignKey, err)
+	case isUnreachable(err) || findError(err, r.dialect.isUnavailable):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,1296 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gos/app/models"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryRepo keeps everything in memory with the same semantics as the mysql repo: the same ordering, not found errors,
+// auto increment ids, unique and foreign keys, and updates only affect the rows they change.
+// It is meant for tests and demos, everything is lost when the process exits.
+type MemoryRepo struct {
+	mu *sync.RWMutex
+	// inTx is set on the repo given to the function of WithTx, it runs under the lock the transaction holds
+	inTx bool
+
+	*memoryTables
+}
+
+// memoryTables are the rows of the memory repo, shared by the repo and its transactions
+type memoryTables struct {
+	users          []models.User
+	tasks          []models.Task
+	passwordResets []models.PasswordReset
+	recoveryCodes  []models.RecoveryCode
+	accessTokens   []models.AccessToken
+	oauthClients   []models.OAuthClient
+	oauthCodes     []models.OAuthCode
+	oauthConsents  []models.OAuthConsent
+	revokedTokens  map[string]bool
+	identities     []models.UserIdentity
+	auditEntries   []models.AuditEntry
+	sessions       []models.Session
+	exportJobs     []models.ExportJob
+	securityEvents []models.SecurityEvent
+
+	// lastIds keeps the last auto increment id of every table, ids are never reused like in mysql
+	lastIds map[string]int64
+}
+
+// memoryResult is the sql.Result of a statement of the memory repo
+type memoryResult struct {
+	lastInsertId int64
+	rowsAffected int64
+}
+
+func (r memoryResult) LastInsertId() (int64, error) {
+	return r.lastInsertId, nil
+}
+
+func (r memoryResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		mu: &sync.RWMutex{},
+		memoryTables: &memoryTables{
+			revokedTokens: make(map[string]bool),
+			lastIds:       make(map[string]int64),
+		},
+	}
+}
+
+// joined tells whether the call runs within a transaction of the repo, given to WithTx or carried by ctx,
+// which already holds the lock
+func (r *MemoryRepo) joined(ctx context.Context) bool {
+	if r.inTx {
+		return true
+	}
+
+	tx, ok := ctx.Value(txContextKey{}).(*MemoryRepo)
+	return ok && tx.inTx && tx.memoryTables == r.memoryTables
+}
+
+// lock takes the write lock for a call, unless the call joins a transaction
+func (r *MemoryRepo) lock(ctx context.Context) func() {
+	if r.joined(ctx) {
+		return func() {}
+	}
+
+	r.mu.Lock()
+	return r.mu.Unlock
+}
+
+// rlock takes the read lock for a call, unless the call joins a transaction
+func (r *MemoryRepo) rlock(ctx context.Context) func() {
+	if r.joined(ctx) {
+		return func() {}
+	}
+
+	r.mu.RLock()
+	return r.mu.RUnlock
+}
+
+// WithTx runs fn holding the write lock, so transactions are serializable and never retried. The rows are restored
+// when fn fails, nested transactions restore only their own changes like a savepoint. The ids taken by a rolled back
+// transaction are not reused, like in mysql. Within fn only the repo given to it, or a context of ContextWithTx,
+// may be used: other calls wait for the lock fn holds.
+func (r *MemoryRepo) WithTx(ctx context.Context, fn func(repo IAppRepo) error) (err error) {
+	if !r.joined(ctx) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+	}
+
+	snapshot := r.memoryTables.clone()
+	defer func() {
+		if p := recover(); p != nil {
+			r.memoryTables.restore(snapshot)
+			panic(p)
+		}
+
+		if err != nil {
+			r.memoryTables.restore(snapshot)
+		}
+	}()
+
+	return fn(&MemoryRepo{mu: r.mu, inTx: true, memoryTables: r.memoryTables})
+}
+
+// clone copies the rows, the rows are replaced and never changed in place so copying the lists is enough
+func (t *memoryTables) clone() *memoryTables {
+	c := *t
+	c.users = append([]models.User(nil), t.users...)
+	c.tasks = append([]models.Task(nil), t.tasks...)
+	c.passwordResets = append([]models.PasswordReset(nil), t.passwordResets...)
+	c.recoveryCodes = append([]models.RecoveryCode(nil), t.recoveryCodes...)
+	c.accessTokens = append([]models.AccessToken(nil), t.accessTokens...)
+	c.oauthClients = append([]models.OAuthClient(nil), t.oauthClients...)
+	c.oauthCodes = append([]models.OAuthCode(nil), t.oauthCodes...)
+	c.oauthConsents = append([]models.OAuthConsent(nil), t.oauthConsents...)
+	c.identities = append([]models.UserIdentity(nil), t.identities...)
+	c.auditEntries = append([]models.AuditEntry(nil), t.auditEntries...)
+	c.sessions = append([]models.Session(nil), t.sessions...)
+	c.exportJobs = append([]models.ExportJob(nil), t.exportJobs...)
+	c.securityEvents = append([]models.SecurityEvent(nil), t.securityEvents...)
+
+	c.revokedTokens = make(map[string]bool, len(t.revokedTokens))
+	for tokenId, revoked := range t.revokedTokens {
+		c.revokedTokens[tokenId] = revoked
+	}
+
+	return &c
+}
+
+// restore puts back the rows of a clone, the last ids are kept
+func (t *memoryTables) restore(snapshot *memoryTables) {
+	lastIds := t.lastIds
+	*t = *snapshot
+	t.lastIds = lastIds
+}
+
+func (r *MemoryRepo) nextId(table string) int64 {
+	r.lastIds[table]++
+	return r.lastIds[table]
+}
+
+func (r *MemoryRepo) userExists(userId int64) bool {
+	for _, user := range r.users {
+		if user.UserId == userId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// emailTaken tells whether another user has the email, compared case insensitively like the unique key of the emails
+func (r *MemoryRepo) emailTaken(email string, userId int64) bool {
+	for _, user := range r.users {
+		if user.UserId != userId && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *MemoryRepo) clientExists(clientId string) bool {
+	for _, client := range r.oauthClients {
+		if client.ClientId == clientId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func errForeignKey(table string) error {
+	return fmt.Errorf("memory: %w: cannot add or update a child row of %s", ErrForeignKey, table)
+}
+
+func errDuplicate(table string, key string) error {
+	return fmt.Errorf("memory: %w for key %s of %s", ErrDuplicate, key, table)
+}
+
+func (r *MemoryRepo) AddUser(ctx context.Context, user models.User) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if r.emailTaken(user.Email, 0) {
+		return nil, errDuplicate("GOS_USER", "email")
+	}
+
+	user.UserId = r.nextId("GOS_USER")
+	r.users = append(r.users, user)
+	return memoryResult{lastInsertId: user.UserId, rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) UpdateUser(ctx context.Context, user models.User) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	for i := range r.users {
+		if r.users[i].UserId == user.UserId {
+			if r.users[i] == user {
+				return memoryResult{}, nil
+			}
+
+			if r.emailTaken(user.Email, user.UserId) {
+				return nil, errDuplicate("GOS_USER", "email")
+			}
+
+			r.users[i] = user
+			return memoryResult{rowsAffected: 1}, nil
+		}
+	}
+
+	return memoryResult{}, nil
+}
+
+// UseTOTPStep records the step of a used totp code, no rows are affected when the same or a later step was used
+func (r *MemoryRepo) UseTOTPStep(ctx context.Context, userId int64, step int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	for i := range r.users {
+		if r.users[i].UserId == userId && r.users[i].TOTPLastStep < step {
+			r.users[i].TOTPLastStep = step
+			return memoryResult{rowsAffected: 1}, nil
+		}
+	}
+
+	return memoryResult{}, nil
+}
+
+func (r *MemoryRepo) IncrementFailedLoginAttempt(ctx context.Context, userId int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	for i := range r.users {
+		if r.users[i].UserId == userId {
+			r.users[i].FailedLoginAttempt++
+			return memoryResult{rowsAffected: 1}, nil
+		}
+	}
+
+	return memoryResult{}, nil
+}
+
+// LockLogin locks the login when the stored count reached maxAttempts, no rows are affected otherwise
+func (r *MemoryRepo) LockLogin(ctx context.Context, userId int64, maxAttempts int, lockedUntil int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	for i := range r.users {
+		if r.users[i].UserId == userId && r.users[i].FailedLoginAttempt >= maxAttempts {
+			r.users[i].FailedLoginAttempt = 0
+			r.users[i].LockedUntil = lockedUntil
+			return memoryResult{rowsAffected: 1}, nil
+		}
+	}
+
+	return memoryResult{}, nil
+}
+
+func (r *MemoryRepo) RecordLogin(ctx context.Context, userId int64, lastLogin int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	for i := range r.users {
+		if r.users[i].UserId == userId {
+			r.users[i].LastLogin = int(lastLogin)
+			r.users[i].FailedLoginAttempt = 0
+			r.users[i].LockedUntil = 0
+			return memoryResult{rowsAffected: 1}, nil
+		}
+	}
+
+	return memoryResult{}, nil
+}
+
+// RehashPassword replaces the password hash while it is still oldHash
+func (r *MemoryRepo) RehashPassword(ctx context.Context, userId int64, oldHash string, newHash string) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	for i := range r.users {
+		if r.users[i].UserId == userId && r.users[i].Password == oldHash {
+			r.users[i].Password = newHash
+			return memoryResult{rowsAffected: 1}, nil
+		}
+	}
+
+	return memoryResult{}, nil
+}
+
+// GetUserByEmail compares the emails case insensitively like the utf8_general_ci collation
+func (r *MemoryRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, user := range r.users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+
+	return nil, notFound("user")
+}
+
+func (r *MemoryRepo) GetUserById(ctx context.Context, userId int64) (*models.User, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, user := range r.users {
+		if user.UserId == userId {
+			return &user, nil
+		}
+	}
+
+	return nil, notFound("user")
+}
+
+func (r *MemoryRepo) GetAllTasks(ctx context.Context, lastTaskId int64, userId int64, limit int) ([]models.Task, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	tasks := make([]models.Task, 0)
+	for i := len(r.tasks) - 1; i >= 0 && len(tasks) < limit; i-- {
+		task := r.tasks[i]
+		if task.TaskId > lastTaskId && task.UserId == userId {
+			tasks = append(tasks, task)
+		}
+	}
+
+	return tasks, nil
+}
+
+func (r *MemoryRepo) GetTaskById(ctx context.Context, taskId int64, userId int64) (*models.Task, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, task := range r.tasks {
+		if task.TaskId == taskId && task.UserId == userId {
+			return &task, nil
+		}
+	}
+
+	return nil, notFound("task")
+}
+
+func (r *MemoryRepo) AddTask(ctx context.Context, task models.Task) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(task.UserId) {
+		return nil, errForeignKey("GOS_TASK")
+	}
+
+	task.TaskId = r.nextId("GOS_TASK")
+	r.tasks = append(r.tasks, task)
+	return memoryResult{lastInsertId: task.TaskId, rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) AddPasswordReset(ctx context.Context, reset models.PasswordReset) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(reset.UserId) {
+		return nil, errForeignKey("GOS_PASSWORD_RESET")
+	}
+
+	for _, existing := range r.passwordResets {
+		if existing.TokenHash == reset.TokenHash {
+			return nil, errDuplicate("GOS_PASSWORD_RESET", "token_hash")
+		}
+	}
+
+	reset.ResetId = r.nextId("GOS_PASSWORD_RESET")
+	r.passwordResets = append(r.passwordResets, reset)
+	return memoryResult{lastInsertId: reset.ResetId, rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) GetPasswordResetByTokenHash(ctx context.Context, tokenHash string) (*models.PasswordReset, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, reset := range r.passwordResets {
+		if reset.TokenHash == tokenHash {
+			return &reset, nil
+		}
+	}
+
+	return nil, notFound("password reset")
+}
+
+// UsePasswordReset marks a reset as used, no rows are affected if it was already used
+func (r *MemoryRepo) UsePasswordReset(ctx context.Context, resetId int64, dateUsed int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.passwordResets {
+		if r.passwordResets[i].ResetId == resetId && r.passwordResets[i].DateUsed == 0 && dateUsed != 0 {
+			r.passwordResets[i].DateUsed = dateUsed
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+// InvalidatePasswordResets marks all the unused resets of a user as used
+func (r *MemoryRepo) InvalidatePasswordResets(ctx context.Context, userId int64, dateUsed int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.passwordResets {
+		if r.passwordResets[i].UserId == userId && r.passwordResets[i].DateUsed == 0 && dateUsed != 0 {
+			r.passwordResets[i].DateUsed = dateUsed
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+func (r *MemoryRepo) AddRecoveryCode(ctx context.Context, code models.RecoveryCode) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(code.UserId) {
+		return nil, errForeignKey("GOS_RECOVERY_CODE")
+	}
+
+	code.CodeId = r.nextId("GOS_RECOVERY_CODE")
+	r.recoveryCodes = append(r.recoveryCodes, code)
+	return memoryResult{lastInsertId: code.CodeId, rowsAffected: 1}, nil
+}
+
+// UseRecoveryCode marks a recovery code as used, no rows are affected if it doesn't exist or was already used
+func (r *MemoryRepo) UseRecoveryCode(ctx context.Context, userId int64, codeHash string, dateUsed int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.recoveryCodes {
+		code := &r.recoveryCodes[i]
+		if code.UserId == userId && code.CodeHash == codeHash && code.DateUsed == 0 && dateUsed != 0 {
+			code.DateUsed = dateUsed
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+func (r *MemoryRepo) DeleteRecoveryCodes(ctx context.Context, userId int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	return memoryResult{rowsAffected: r.deleteRecoveryCodes(userId)}, nil
+}
+
+func (r *MemoryRepo) deleteRecoveryCodes(userId int64) int64 {
+	kept := r.recoveryCodes[:0]
+	for _, code := range r.recoveryCodes {
+		if code.UserId != userId {
+			kept = append(kept, code)
+		}
+	}
+
+	deleted := int64(len(r.recoveryCodes) - len(kept))
+	r.recoveryCodes = kept
+	return deleted
+}
+
+func (r *MemoryRepo) AddAccessToken(ctx context.Context, token models.AccessToken) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(token.UserId) {
+		return nil, errForeignKey("GOS_ACCESS_TOKEN")
+	}
+
+	for _, existing := range r.accessTokens {
+		if existing.TokenHash == token.TokenHash {
+			return nil, errDuplicate("GOS_ACCESS_TOKEN", "token_hash")
+		}
+	}
+
+	token.TokenId = r.nextId("GOS_ACCESS_TOKEN")
+	token.Scopes = copyFields(token.Scopes)
+	r.accessTokens = append(r.accessTokens, token)
+	return memoryResult{lastInsertId: token.TokenId, rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) GetAccessTokenByTokenHash(ctx context.Context, tokenHash string) (*models.AccessToken, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, token := range r.accessTokens {
+		if token.TokenHash == tokenHash {
+			token.Scopes = copyFields(token.Scopes)
+			return &token, nil
+		}
+	}
+
+	return nil, notFound("access token")
+}
+
+// GetAccessTokens gets all the access tokens of a user which are not revoked
+func (r *MemoryRepo) GetAccessTokens(ctx context.Context, userId int64) ([]models.AccessToken, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	tokens := make([]models.AccessToken, 0)
+	for i := len(r.accessTokens) - 1; i >= 0; i-- {
+		token := r.accessTokens[i]
+		if token.UserId == userId && token.DateRevoked == 0 {
+			token.Scopes = copyFields(token.Scopes)
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+// RevokeAccessToken revokes a token of the user, no rows are affected if it doesn't exist or was already revoked
+func (r *MemoryRepo) RevokeAccessToken(ctx context.Context, tokenId int64, userId int64, dateRevoked int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.accessTokens {
+		token := &r.accessTokens[i]
+		if token.TokenId == tokenId && token.UserId == userId && token.DateRevoked == 0 && dateRevoked != 0 {
+			token.DateRevoked = dateRevoked
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+func (r *MemoryRepo) RevokeUserAccessTokens(ctx context.Context, userId int64, dateRevoked int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.accessTokens {
+		token := &r.accessTokens[i]
+		if token.UserId == userId && token.DateRevoked == 0 && dateRevoked != 0 {
+			token.DateRevoked = dateRevoked
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+func (r *MemoryRepo) UpdateAccessTokenLastUsed(ctx context.Context, tokenId int64, lastUsed int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.accessTokens {
+		if r.accessTokens[i].TokenId == tokenId && r.accessTokens[i].LastUsed != lastUsed {
+			r.accessTokens[i].LastUsed = lastUsed
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+func (r *MemoryRepo) AddOAuthClient(ctx context.Context, client models.OAuthClient) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(client.UserId) {
+		return nil, errForeignKey("GOS_OAUTH_CLIENT")
+	}
+
+	if r.clientExists(client.ClientId) {
+		return nil, errDuplicate("GOS_OAUTH_CLIENT", "PRIMARY")
+	}
+
+	client.RedirectURIs = copyFields(client.RedirectURIs)
+	client.Scopes = copyFields(client.Scopes)
+	r.oauthClients = append(r.oauthClients, client)
+	return memoryResult{rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) GetOAuthClientById(ctx context.Context, clientId string) (*models.OAuthClient, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, client := range r.oauthClients {
+		if client.ClientId == clientId {
+			client.RedirectURIs = copyFields(client.RedirectURIs)
+			client.Scopes = copyFields(client.Scopes)
+			return &client, nil
+		}
+	}
+
+	return nil, notFound("oauth client")
+}
+
+func (r *MemoryRepo) GetOAuthClients(ctx context.Context, userId int64) ([]models.OAuthClient, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	clients := make([]models.OAuthClient, 0)
+	for _, client := range r.oauthClients {
+		if client.UserId == userId {
+			client.RedirectURIs = copyFields(client.RedirectURIs)
+			client.Scopes = copyFields(client.Scopes)
+			clients = append(clients, client)
+		}
+	}
+
+	sort.SliceStable(clients, func(i, j int) bool {
+		return clients[i].DateCreated > clients[j].DateCreated
+	})
+
+	return clients, nil
+}
+
+func (r *MemoryRepo) AddOAuthCode(ctx context.Context, code models.OAuthCode) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(code.UserId) || !r.clientExists(code.ClientId) {
+		return nil, errForeignKey("GOS_OAUTH_CODE")
+	}
+
+	for _, existing := range r.oauthCodes {
+		if existing.CodeHash == code.CodeHash {
+			return nil, errDuplicate("GOS_OAUTH_CODE", "PRIMARY")
+		}
+	}
+
+	code.Scopes = copyFields(code.Scopes)
+	r.oauthCodes = append(r.oauthCodes, code)
+	return memoryResult{rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) GetOAuthCodeByCodeHash(ctx context.Context, codeHash string) (*models.OAuthCode, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, code := range r.oauthCodes {
+		if code.CodeHash == codeHash {
+			code.Scopes = copyFields(code.Scopes)
+			return &code, nil
+		}
+	}
+
+	return nil, notFound("oauth code")
+}
+
+// UseOAuthCode marks a code as used, no rows are affected if it was already used
+func (r *MemoryRepo) UseOAuthCode(ctx context.Context, codeHash string, dateUsed int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.oauthCodes {
+		if r.oauthCodes[i].CodeHash == codeHash && r.oauthCodes[i].DateUsed == 0 && dateUsed != 0 {
+			r.oauthCodes[i].DateUsed = dateUsed
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+func (r *MemoryRepo) GetOAuthConsent(ctx context.Context, userId int64, clientId string) (*models.OAuthConsent, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, consent := range r.oauthConsents {
+		if consent.UserId == userId && consent.ClientId == clientId {
+			consent.Scopes = copyFields(consent.Scopes)
+			return &consent, nil
+		}
+	}
+
+	return nil, notFound("oauth consent")
+}
+
+// SaveOAuthConsent inserts the consent or replaces the scopes of the existing one,
+// like on duplicate key update 1 row is affected by an insert and 2 by an update
+func (r *MemoryRepo) SaveOAuthConsent(ctx context.Context, consent models.OAuthConsent) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(consent.UserId) || !r.clientExists(consent.ClientId) {
+		return nil, errForeignKey("GOS_OAUTH_CONSENT")
+	}
+
+	scopes := copyFields(consent.Scopes)
+	for i := range r.oauthConsents {
+		existing := &r.oauthConsents[i]
+		if existing.UserId == consent.UserId && existing.ClientId == consent.ClientId {
+			if strings.Join(existing.Scopes, " ") == strings.Join(scopes, " ") && existing.DateUpdated == consent.DateUpdated {
+				return memoryResult{}, nil
+			}
+
+			existing.Scopes = scopes
+			existing.DateUpdated = consent.DateUpdated
+			return memoryResult{rowsAffected: 2}, nil
+		}
+	}
+
+	consent.Scopes = scopes
+	r.oauthConsents = append(r.oauthConsents, consent)
+	return memoryResult{rowsAffected: 1}, nil
+}
+
+// AddRevokedToken ignores a token which is already revoked, like insert ignore
+func (r *MemoryRepo) AddRevokedToken(ctx context.Context, tokenId string, expiresAt int64, dateRevoked int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if r.revokedTokens[tokenId] {
+		return memoryResult{}, nil
+	}
+
+	r.revokedTokens[tokenId] = true
+	return memoryResult{rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) IsTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	return r.revokedTokens[tokenId], nil
+}
+
+func (r *MemoryRepo) AddUserIdentity(ctx context.Context, identity models.UserIdentity) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(identity.UserId) {
+		return nil, errForeignKey("GOS_USER_IDENTITY")
+	}
+
+	for _, existing := range r.identities {
+		if existing.Issuer == identity.Issuer && existing.Subject == identity.Subject {
+			return nil, errDuplicate("GOS_USER_IDENTITY", "issuer")
+		}
+	}
+
+	identity.IdentityId = r.nextId("GOS_USER_IDENTITY")
+	r.identities = append(r.identities, identity)
+	return memoryResult{lastInsertId: identity.IdentityId, rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) GetUserIdentity(ctx context.Context, issuer string, subject string) (*models.UserIdentity, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, identity := range r.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+
+	return nil, notFound("user identity")
+}
+
+func (r *MemoryRepo) UpdateUserIdentityLastLogin(ctx context.Context, identityId int64, lastLogin int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.identities {
+		if r.identities[i].IdentityId == identityId && r.identities[i].LastLogin != lastLogin {
+			r.identities[i].LastLogin = lastLogin
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+// SearchUsers pages through the users whose email or name contains the query, all the users when the query is empty,
+// the match is case insensitive like the like of mysql
+func (r *MemoryRepo) SearchUsers(ctx context.Context, query string, lastUserId int64, limit int) ([]models.User, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	query = strings.ToLower(query)
+	users := make([]models.User, 0)
+	for _, user := range r.users {
+		if len(users) >= limit {
+			break
+		}
+
+		if user.UserId <= lastUserId {
+			continue
+		}
+
+		if len(query) == 0 || strings.Contains(strings.ToLower(user.Email), query) || strings.Contains(strings.ToLower(user.Name), query) {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+func (r *MemoryRepo) AddAuditEntry(ctx context.Context, entry models.AuditEntry) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	entry.AuditId = r.nextId("GOS_AUDIT_LOG")
+	r.auditEntries = append(r.auditEntries, entry)
+	return memoryResult{lastInsertId: entry.AuditId, rowsAffected: 1}, nil
+}
+
+// GetAuditEntries pages through the audit log, only the entries about the target user when it isn't 0
+func (r *MemoryRepo) GetAuditEntries(ctx context.Context, targetUserId int64, lastAuditId int64, limit int) ([]models.AuditEntry, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	entries := make([]models.AuditEntry, 0)
+	for _, entry := range r.auditEntries {
+		if len(entries) >= limit {
+			break
+		}
+
+		if entry.AuditId > lastAuditId && (targetUserId == 0 || entry.TargetUserId == targetUserId) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (r *MemoryRepo) AddSession(ctx context.Context, session models.Session) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(session.UserId) {
+		return nil, errForeignKey("GOS_SESSION")
+	}
+
+	session.SessionId = r.nextId("GOS_SESSION")
+	session.Current = false
+	r.sessions = append(r.sessions, session)
+	return memoryResult{lastInsertId: session.SessionId, rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) GetSessionById(ctx context.Context, sessionId int64) (*models.Session, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, session := range r.sessions {
+		if session.SessionId == sessionId {
+			return &session, nil
+		}
+	}
+
+	return nil, notFound("session")
+}
+
+// GetSessions gets the sessions of a user which are neither revoked nor expired, the last seen first
+func (r *MemoryRepo) GetSessions(ctx context.Context, userId int64, now int64) ([]models.Session, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	sessions := make([]models.Session, 0)
+	for _, session := range r.sessions {
+		if session.UserId == userId && session.DateRevoked == 0 && session.ExpiresAt > now {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen > sessions[j].LastSeen
+	})
+
+	return sessions, nil
+}
+
+func (r *MemoryRepo) UpdateSessionLastSeen(ctx context.Context, sessionId int64, lastSeen int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.sessions {
+		if r.sessions[i].SessionId == sessionId && r.sessions[i].LastSeen != lastSeen {
+			r.sessions[i].LastSeen = lastSeen
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+// RevokeSession revokes a session of the user, no rows are affected if it doesn't exist or was already revoked
+func (r *MemoryRepo) RevokeSession(ctx context.Context, sessionId int64, userId int64, dateRevoked int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.sessions {
+		session := &r.sessions[i]
+		if session.SessionId == sessionId && session.UserId == userId && session.DateRevoked == 0 && dateRevoked != 0 {
+			session.DateRevoked = dateRevoked
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+func (r *MemoryRepo) RevokeUserSessions(ctx context.Context, userId int64, dateRevoked int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var affected int64
+	for i := range r.sessions {
+		session := &r.sessions[i]
+		if session.UserId == userId && session.DateRevoked == 0 && dateRevoked != 0 {
+			session.DateRevoked = dateRevoked
+			affected++
+		}
+	}
+
+	return memoryResult{rowsAffected: affected}, nil
+}
+
+func (r *MemoryRepo) GetUsersDueForDeletion(ctx context.Context, now int64, limit int) ([]models.User, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	users := make([]models.User, 0)
+	for _, user := range r.users {
+		if len(users) >= limit {
+			break
+		}
+
+		if user.DeletionScheduledAt > 0 && user.DeletionScheduledAt <= now {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+// DeleteUser deletes the user with everything that references it at once,
+// the tasks are either deleted or kept without an owner
+func (r *MemoryRepo) DeleteUser(ctx context.Context, userId int64, anonymizeTasks bool) error {
+	if err := ctx.Err(); err != nil {
+		return canceled(ctx, err)
+	}
+
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	tasks := r.tasks[:0]
+	for _, task := range r.tasks {
+		if task.UserId == userId {
+			if !anonymizeTasks {
+				continue
+			}
+			task.UserId = 0
+		}
+		tasks = append(tasks, task)
+	}
+	r.tasks = tasks
+
+	clientIds := make(map[string]bool)
+	clients := r.oauthClients[:0]
+	for _, client := range r.oauthClients {
+		if client.UserId == userId {
+			clientIds[client.ClientId] = true
+			continue
+		}
+		clients = append(clients, client)
+	}
+	r.oauthClients = clients
+
+	codes := r.oauthCodes[:0]
+	for _, code := range r.oauthCodes {
+		if code.UserId != userId && !clientIds[code.ClientId] {
+			codes = append(codes, code)
+		}
+	}
+	r.oauthCodes = codes
+
+	consents := r.oauthConsents[:0]
+	for _, consent := range r.oauthConsents {
+		if consent.UserId != userId && !clientIds[consent.ClientId] {
+			consents = append(consents, consent)
+		}
+	}
+	r.oauthConsents = consents
+
+	accessTokens := r.accessTokens[:0]
+	for _, token := range r.accessTokens {
+		if token.UserId != userId {
+			accessTokens = append(accessTokens, token)
+		}
+	}
+	r.accessTokens = accessTokens
+
+	r.deleteRecoveryCodes(userId)
+
+	resets := r.passwordResets[:0]
+	for _, reset := range r.passwordResets {
+		if reset.UserId != userId {
+			resets = append(resets, reset)
+		}
+	}
+	r.passwordResets = resets
+
+	identities := r.identities[:0]
+	for _, identity := range r.identities {
+		if identity.UserId != userId {
+			identities = append(identities, identity)
+		}
+	}
+	r.identities = identities
+
+	sessions := r.sessions[:0]
+	for _, session := range r.sessions {
+		if session.UserId != userId {
+			sessions = append(sessions, session)
+		}
+	}
+	r.sessions = sessions
+
+	jobs := r.exportJobs[:0]
+	for _, job := range r.exportJobs {
+		if job.UserId != userId {
+			jobs = append(jobs, job)
+		}
+	}
+	r.exportJobs = jobs
+
+	events := r.securityEvents[:0]
+	for _, event := range r.securityEvents {
+		if event.UserId != userId {
+			events = append(events, event)
+		}
+	}
+	r.securityEvents = events
+
+	users := r.users[:0]
+	for _, user := range r.users {
+		if user.UserId != userId {
+			users = append(users, user)
+		}
+	}
+	r.users = users
+
+	return nil
+}
+
+// GetTasksByUser returns the tasks of the user oldest first, paging by the last task id walks all of them
+func (r *MemoryRepo) GetTasksByUser(ctx context.Context, userId int64, lastTaskId int64, limit int) ([]models.Task, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	tasks := make([]models.Task, 0)
+	for _, task := range r.tasks {
+		if len(tasks) >= limit {
+			break
+		}
+
+		if task.UserId == userId && task.TaskId > lastTaskId {
+			tasks = append(tasks, task)
+		}
+	}
+
+	return tasks, nil
+}
+
+// GetUserSessions gets all the sessions of a user, including the revoked and expired ones
+func (r *MemoryRepo) GetUserSessions(ctx context.Context, userId int64) ([]models.Session, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	sessions := make([]models.Session, 0)
+	for _, session := range r.sessions {
+		if session.UserId == userId {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (r *MemoryRepo) GetUserIdentities(ctx context.Context, userId int64) ([]models.UserIdentity, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	identities := make([]models.UserIdentity, 0)
+	for _, identity := range r.identities {
+		if identity.UserId == userId {
+			identities = append(identities, identity)
+		}
+	}
+
+	return identities, nil
+}
+
+func (r *MemoryRepo) AddExportJob(ctx context.Context, job models.ExportJob) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	if !r.userExists(job.UserId) {
+		return nil, errForeignKey("GOS_EXPORT_JOB")
+	}
+
+	// like the unique key of the sql schema, a user has at most one pending job
+	for _, existing := range r.exportJobs {
+		if job.Status == models.ExportStatusPending && existing.UserId == job.UserId && existing.Status == models.ExportStatusPending {
+			return nil, errDuplicate("GOS_EXPORT_JOB", "pending_user_id")
+		}
+	}
+
+	job.JobId = r.nextId("GOS_EXPORT_JOB")
+	job.DownloadURL = ""
+	r.exportJobs = append(r.exportJobs, job)
+	return memoryResult{lastInsertId: job.JobId, rowsAffected: 1}, nil
+}
+
+func (r *MemoryRepo) GetExportJob(ctx context.Context, jobId int64, userId int64) (*models.ExportJob, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	for _, job := range r.exportJobs {
+		if job.JobId == jobId && job.UserId == userId {
+			return &job, nil
+		}
+	}
+
+	return nil, notFound("export job")
+}
+
+// GetExportJobs gets the export jobs of a user, the newest first
+func (r *MemoryRepo) GetExportJobs(ctx context.Context, userId int64) ([]models.ExportJob, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	jobs := make([]models.ExportJob, 0)
+	for i := len(r.exportJobs) - 1; i >= 0; i-- {
+		if r.exportJobs[i].UserId == userId {
+			jobs = append(jobs, r.exportJobs[i])
+		}
+	}
+
+	return jobs, nil
+}
+
+// GetStaleExportJobs gets the done jobs which expired, the pending jobs created before pendingBefore
+// and the failed jobs created before failedBefore
+func (r *MemoryRepo) GetStaleExportJobs(ctx context.Context, now int64, pendingBefore int64, failedBefore int64, limit int) ([]models.ExportJob, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	jobs := make([]models.ExportJob, 0)
+	for _, job := range r.exportJobs {
+		if len(jobs) >= limit {
+			break
+		}
+
+		if (job.Status == models.ExportStatusDone && job.ExpiresAt <= now) ||
+			(job.Status == models.ExportStatusPending && job.DateCreated <= pendingBefore) ||
+			(job.Status == models.ExportStatusFailed && job.DateCreated <= failedBefore) {
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+// UpdateExportJob updates the status, the error and the dates of a job
+func (r *MemoryRepo) UpdateExportJob(ctx context.Context, job models.ExportJob) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	for i := range r.exportJobs {
+		existing := &r.exportJobs[i]
+		if existing.JobId != job.JobId {
+			continue
+		}
+
+		if existing.Status == job.Status && existing.Error == job.Error && existing.DateCompleted == job.DateCompleted && existing.ExpiresAt == job.ExpiresAt {
+			return memoryResult{}, nil
+		}
+
+		existing.Status = job.Status
+		existing.Error = job.Error
+		existing.DateCompleted = job.DateCompleted
+		existing.ExpiresAt = job.ExpiresAt
+		return memoryResult{rowsAffected: 1}, nil
+	}
+
+	return memoryResult{}, nil
+}
+
+func (r *MemoryRepo) DeleteExportJob(ctx context.Context, jobId int64) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	jobs := r.exportJobs[:0]
+	for _, job := range r.exportJobs {
+		if job.JobId != jobId {
+			jobs = append(jobs, job)
+		}
+	}
+
+	deleted := int64(len(r.exportJobs) - len(jobs))
+	r.exportJobs = jobs
+	return memoryResult{rowsAffected: deleted}, nil
+}
+
+func (r *MemoryRepo) AddSecurityEvent(ctx context.Context, event models.SecurityEvent) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	event.EventId = r.nextId("GOS_SECURITY_EVENT")
+	r.securityEvents = append(r.securityEvents, event)
+	return memoryResult{lastInsertId: event.EventId, rowsAffected: 1}, nil
+}
+
+// GetSecurityEvents pages through the security events oldest first, filtered by the user and the type when they aren't empty
+func (r *MemoryRepo) GetSecurityEvents(ctx context.Context, userId int64, eventType string, lastEventId int64, limit int) ([]models.SecurityEvent, error) {
+	unlock := r.rlock(ctx)
+	defer unlock()
+
+	events := make([]models.SecurityEvent, 0)
+	for _, event := range r.securityEvents {
+		if len(events) >= limit {
+			break
+		}
+
+		if event.EventId > lastEventId && (userId == 0 || event.UserId == userId) && (len(eventType) == 0 || event.Type == eventType) {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+// DeleteSecurityEventsBefore deletes at most limit events older than before, the oldest first
+func (r *MemoryRepo) DeleteSecurityEventsBefore(ctx context.Context, before int64, limit int) (sql.Result, error) {
+	unlock := r.lock(ctx)
+	defer unlock()
+
+	var deleted int64
+	events := r.securityEvents[:0]
+	for _, event := range r.securityEvents {
+		if event.DateCreated < before && deleted < int64(limit) {
+			deleted++
+			continue
+		}
+		events = append(events, event)
+	}
+	r.securityEvents = events
+
+	return memoryResult{rowsAffected: deleted}, nil
+}
+
+func (r *MemoryRepo) Close() error {
+	return nil
+}
+
+// copyFields copies a list which is stored joined by spaces in mysql, so it reads back the same way
+func copyFields(values []string) []string {
+	return strings.Fields(strings.Join(values, " "))
+}
+
+var _ IAppRepo = (*MemoryRepo)(nil)
@@ -0,0 +1,659 @@
+// Package migrate applies the versioned migrations of the schema, embedded for every sql driver of the repo.
+// The migrations of a driver are the files <driver>/<version>_<name>.up.sql and <driver>/<version>_<name>.down.sql,
+// the applied ones are recorded in GOS_SCHEMA_MIGRATION with the checksum of their up script
+// and a migration changed after it was applied is refused.
+//
+// The instances migrating the same database take turns on an advisory lock, every migration runs in a transaction
+// and checks again in it that it is still pending. MySQL commits the DDL statements implicitly,
+// a failing migration can leave its first statements applied there.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gos/app/repo"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//go:embed mysql postgres sqlite
+var files embed.FS
+
+const createMigrationsTableStatement = `create table if not exists GOS_SCHEMA_MIGRATION (version bigint not null primary key, name varchar(255) not null, checksum char(64) not null, date_applied bigint not null)`
+const getMigrationsStatement = `select version, name, checksum, date_applied from GOS_SCHEMA_MIGRATION order by version`
+
+// lockRetryInterval is how often the lock is tried again while another instance holds it
+const lockRetryInterval = 500 * time.Millisecond
+
+// Migration is a version of the schema
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is the sha256 of the up script
+	Checksum string
+}
+
+// Status tells whether a migration is applied
+type Status struct {
+	Version     int64
+	Name        string
+	Applied     bool
+	DateApplied int64
+	// Changed is set when the up script of the build differs from the applied one
+	Changed bool
+	// Unknown is set when the migration is applied but this build doesn't have it
+	Unknown bool
+	// Dirty is set when the migration failed halfway on mysql, which commits the schema changes as they run,
+	// the schema must be repaired by hand and the migration forced before migrating again
+	Dirty bool
+}
+
+// dialect holds what differs between the databases
+type dialect struct {
+	// placeholder returns the nth parameter of a statement, from 1
+	placeholder func(n int) string
+	// tryLock takes the lock of the migrations for the session of the connection without waiting
+	tryLock func(ctx context.Context, conn *sql.Conn) (bool, error)
+	unlock  func(ctx context.Context, conn *sql.Conn) error
+}
+
+// the key of the advisory lock, "gos" in ascii for postgres
+const (
+	mysqlLockName   = "GOS_SCHEMA_MIGRATION"
+	postgresLockKey = 0x676f73
+)
+
+func questionMark(n int) string {
+	return "?"
+}
+
+var dialects = map[string]dialect{
+	repo.DriverMySQL: {
+		placeholder: questionMark,
+		tryLock: func(ctx context.Context, conn *sql.Conn) (bool, error) {
+			var locked sql.NullInt64
+			err := conn.QueryRowContext(ctx, "select get_lock(?, 0)", mysqlLockName).Scan(&locked)
+			return locked.Int64 == 1, err
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, "select release_lock(?)", mysqlLockName)
+			return err
+		},
+	},
+	repo.DriverPostgres: {
+		placeholder: func(n int) string {
+			return "$" + strconv.Itoa(n)
+		},
+		tryLock: func(ctx context.Context, conn *sql.Conn) (bool, error) {
+			var locked bool
+			err := conn.QueryRowContext(ctx, "select pg_try_advisory_lock($1)", postgresLockKey).Scan(&locked)
+			return locked, err
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, "select pg_advisory_unlock($1)", postgresLockKey)
+			return err
+		},
+	},
+	// sqlite has no advisory lock, the transactions of the repo take the write lock of the file when they begin
+	// so the migrations of the instances run one after the other, and one already applied is skipped
+	repo.DriverSQLite: {
+		placeholder: questionMark,
+		tryLock: func(ctx context.Context, conn *sql.Conn) (bool, error) {
+			return true, nil
+		},
+		unlock: func(ctx context.Context, conn *sql.Conn) error {
+			return nil
+		},
+	},
+}
+
+// Load returns the migrations of the driver by version
+func Load(driver string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, driver)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: no migrations for the %s driver", driver)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		up := strings.HasSuffix(fileName, ".up.sql")
+		if !up && !strings.HasSuffix(fileName, ".down.sql") {
+			return nil, fmt.Errorf("migrate: %s/%s is neither an up nor a down migration", driver, fileName)
+		}
+
+		base := strings.TrimSuffix(strings.TrimSuffix(fileName, ".up.sql"), ".down.sql")
+		i := strings.Index(base, "_")
+		if i < 0 {
+			return nil, fmt.Errorf("migrate: %s/%s isn't named <version>_<name>", driver, fileName)
+		}
+
+		version, err := strconv.ParseInt(base[:i], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migrate: %s/%s has no valid version", driver, fileName)
+		}
+
+		content, err := files.ReadFile(path.Join(driver, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: base[i+1:]}
+			byVersion[version] = migration
+		} else if migration.Name != base[i+1:] {
+			return nil, fmt.Errorf("migrate: %s has two migrations with the version %d", driver, version)
+		}
+
+		if up {
+			checksum := sha256.Sum256(content)
+			migration.Up = string(content)
+			migration.Checksum = hex.EncodeToString(checksum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if len(migration.Up) == 0 || len(migration.Down) == 0 {
+			return nil, fmt.Errorf("migrate: the migration %d %s of %s needs both an up and a down script", migration.Version, migration.Name, driver)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrator migrates a database
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+	// LockTimeout is how long to wait for another instance migrating the same database
+	LockTimeout time.Duration
+}
+
+// New returns the migrator of a database of the driver
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("migrate: the %s driver has no schema to migrate", driver)
+	}
+
+	migrations, err := Load(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:          db,
+		dialect:     d,
+		migrations:  migrations,
+		LockTimeout: time.Minute,
+	}, nil
+}
+
+// Up applies the pending migrations, the applied ones are returned
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.To(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down reverts the last steps applied migrations, the reverted ones are returned
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int64]Status) error {
+		if err := verify(applied); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if err := m.run(ctx, conn, migration, false); err != nil {
+				return err
+			}
+
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// To applies or reverts the migrations until the schema is at version, 0 reverts all of them.
+// The migrations run are returned.
+func (m *Migrator) To(ctx context.Context, version int64) ([]Migration, error) {
+	if version != 0 && m.find(version) < 0 {
+		return nil, fmt.Errorf("migrate: unknown version %d", version)
+	}
+
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int64]Status) error {
+		if err := verify(applied); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok || migration.Version > version {
+				continue
+			}
+
+			if err := m.run(ctx, conn, migration, true); err != nil {
+				return err
+			}
+
+			done = append(done, migration)
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok || migration.Version <= version {
+				continue
+			}
+
+			if err := m.run(ctx, conn, migration, false); err != nil {
+				return err
+			}
+
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Status returns the migrations of the build and the applied ones it doesn't have, by version
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int64]Status) error {
+		for _, migration := range m.migrations {
+			status, ok := applied[migration.Version]
+			if !ok {
+				status = Status{Version: migration.Version, Name: migration.Name}
+			}
+
+			delete(applied, migration.Version)
+			statuses = append(statuses, status)
+		}
+
+		for _, status := range applied {
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, err
+}
+
+// Command runs the subcommand of args: up, down [steps], to <version>, force <version> or status, and writes what it
+// did to out
+func (m *Migrator) Command(ctx context.Context, args []string, out io.Writer) error {
+	const usage = "usage: migrate up | down [steps] | to <version> | force <version> | status"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	var done []Migration
+	var err error
+	// reverted tells the migrations that ran down
+	reverted := func(migration Migration) bool {
+		return false
+	}
+	switch args[0] {
+	case "up":
+		done, err = m.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("migrate: invalid number of steps [%s]", args[1])
+			}
+		}
+
+		done, err = m.Down(ctx, steps)
+		reverted = func(migration Migration) bool {
+			return true
+		}
+	case "to":
+		if len(args) < 2 {
+			return errors.New(usage)
+		}
+
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil || version < 0 {
+			return fmt.Errorf("migrate: invalid version [%s]", args[1])
+		}
+
+		done, err = m.To(ctx, version)
+		// To reverts the migrations above the version and applies the others
+		reverted = func(migration Migration) bool {
+			return migration.Version > version
+		}
+	case "force":
+		if len(args) < 2 {
+			return errors.New(usage)
+		}
+
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil || version < 0 {
+			return fmt.Errorf("migrate: invalid version [%s]", args[1])
+		}
+
+		return m.printForced(ctx, version, out)
+	case "status":
+		return m.printStatus(ctx, out)
+	default:
+		return errors.New(usage)
+	}
+
+	for _, migration := range done {
+		if reverted(migration) {
+			fmt.Fprintf(out, "reverted %d %s\n", migration.Version, migration.Name)
+		} else {
+			fmt.Fprintf(out, "applied %d %s\n", migration.Version, migration.Name)
+		}
+	}
+
+	if err == nil && len(done) == 0 {
+		fmt.Fprintln(out, "nothing to migrate")
+	}
+
+	return err
+}
+
+func (m *Migrator) printForced(ctx context.Context, version int64, out io.Writer) error {
+	cleared, err := m.Force(ctx, version)
+	for _, status := range cleared {
+		if status.Version <= version {
+			fmt.Fprintf(out, "forced %d %s as applied\n", status.Version, status.Name)
+		} else {
+			fmt.Fprintf(out, "forced %d %s as reverted\n", status.Version, status.Name)
+		}
+	}
+
+	if err == nil && len(cleared) == 0 {
+		fmt.Fprintln(out, "no dirty migration")
+	}
+
+	return err
+}
+
+func (m *Migrator) printStatus(ctx context.Context, out io.Writer) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED")
+	for _, status := range statuses {
+		state, applied := "pending", ""
+		if status.Applied {
+			state, applied = "applied", time.Unix(status.DateApplied, 0).UTC().Format(time.RFC3339)
+		}
+
+		if status.Dirty {
+			state, applied = "dirty", ""
+		} else if status.Changed {
+			state = "changed"
+		} else if status.Unknown {
+			state = "unknown"
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, applied)
+	}
+
+	return w.Flush()
+}
+
+// withLock runs fn with the applied migrations, holding the lock of the migrations
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn, applied map[int64]Status) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(m.LockTimeout)
+	for {
+		locked, err := m.dialect.tryLock(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("migrate: could not take the lock: %v", err)
+		}
+
+		if locked {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("migrate: another instance has been migrating the database for more than %s", m.LockTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+	// the lock goes with the session, the connection is closed anyway when the unlock fails
+	defer m.dialect.unlock(context.Background(), conn)
+
+	if _, err := conn.ExecContext(ctx, createMigrationsTableStatement); err != nil {
+		return fmt.Errorf("migrate: could not create the migrations table: %v", err)
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+// applied reads the applied migrations and compares them with the build
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]Status, error) {
+	rows, err := conn.QueryContext(ctx, getMigrationsStatement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]Status)
+	for rows.Next() {
+		status := Status{Applied: true}
+		var checksum string
+		if err := rows.Scan(&status.Version, &status.Name, &checksum, &status.DateApplied); err != nil {
+			return nil, fmt.Errorf("migrate: could not read row: %v", err)
+		}
+
+		// the record of a running migration has no date yet, it is left behind by one that failed halfway
+		status.Dirty = status.DateApplied == 0
+
+		if i := m.find(status.Version); i < 0 {
+			status.Unknown = true
+		} else if m.migrations[i].Checksum != strings.TrimSpace(checksum) {
+			status.Changed = true
+		}
+
+		applied[status.Version] = status
+	}
+
+	return applied, rows.Err()
+}
+
+// verify fails when an applied migration was changed or is unknown to the build, the schema can't be migrated then
+func verify(applied map[int64]Status) error {
+	for _, status := range applied {
+		if status.Dirty {
+			return fmt.Errorf("migrate: the migration %d %s failed halfway, repair the schema and run migrate force <version>", status.Version, status.Name)
+		}
+
+		if status.Changed {
+			return fmt.Errorf("migrate: the migration %d %s was changed after it was applied", status.Version, status.Name)
+		}
+
+		if status.Unknown {
+			return fmt.Errorf("migrate: the database has the migration %d %s this build doesn't know, it is newer", status.Version, status.Name)
+		}
+	}
+
+	return nil
+}
+
+// run applies or reverts a migration in a transaction, it is skipped when another instance already did it.
+// MySQL commits every schema change as it runs, so its transaction only covers the statements between them: the
+// migration is recorded as dirty before the script, a script failing halfway leaves the record behind, which stops
+// the migrations until the schema is repaired by hand and the migration forced. The other databases roll it all back.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctx, "select count(*) from GOS_SCHEMA_MIGRATION where version = "+m.dialect.placeholder(1), migration.Version).Scan(&count)
+	if err != nil || (count > 0) == up {
+		tx.Rollback()
+		return err
+	}
+
+	script := migration.Down
+	mark, markArgs := "update GOS_SCHEMA_MIGRATION set date_applied = 0 where version = "+m.dialect.placeholder(1), []interface{}{migration.Version}
+	record, recordArgs := "delete from GOS_SCHEMA_MIGRATION where version = "+m.dialect.placeholder(1), []interface{}{migration.Version}
+	if up {
+		script = migration.Up
+		mark = fmt.Sprintf("insert into GOS_SCHEMA_MIGRATION (version, name, checksum, date_applied) VALUES (%s, %s, %s, 0)",
+			m.dialect.placeholder(1), m.dialect.placeholder(2), m.dialect.placeholder(3))
+		markArgs = []interface{}{migration.Version, migration.Name, migration.Checksum}
+		record = fmt.Sprintf("update GOS_SCHEMA_MIGRATION set date_applied = %s where version = %s", m.dialect.placeholder(1), m.dialect.placeholder(2))
+		recordArgs = []interface{}{time.Now().Unix(), migration.Version}
+	}
+
+	if _, err := tx.ExecContext(ctx, mark, markArgs...); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate: could not record the migration %d %s: %v", migration.Version, migration.Name, err)
+	}
+
+	for _, statement := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate: the migration %d %s failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, record, recordArgs...); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate: could not record the migration %d %s: %v", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit()
+}
+
+// Force clears the dirty migrations once the schema was repaired by hand: the ones up to version are recorded as
+// applied, the ones above it as reverted. The cleared ones are returned.
+func (m *Migrator) Force(ctx context.Context, version int64) ([]Status, error) {
+	var cleared []Status
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int64]Status) error {
+		for _, status := range applied {
+			if !status.Dirty {
+				continue
+			}
+
+			statement := "delete from GOS_SCHEMA_MIGRATION where version = " + m.dialect.placeholder(1)
+			args := []interface{}{status.Version}
+			if status.Version <= version {
+				statement = fmt.Sprintf("update GOS_SCHEMA_MIGRATION set date_applied = %s where version = %s", m.dialect.placeholder(1), m.dialect.placeholder(2))
+				args = []interface{}{time.Now().Unix(), status.Version}
+			}
+
+			if _, err := conn.ExecContext(ctx, statement, args...); err != nil {
+				return fmt.Errorf("migrate: could not force the migration %d %s: %v", status.Version, status.Name, err)
+			}
+
+			cleared = append(cleared, status)
+		}
+
+		return nil
+	})
+
+	sort.Slice(cleared, func(i, j int) bool {
+		return cleared[i].Version < cleared[j].Version
+	})
+
+	return cleared, err
+}
+
+// find returns the index of the migration of version, -1 when the build doesn't have it
+func (m *Migrator) find(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// splitStatements splits a script on the semicolons ending a line, outside of the $$ quoted bodies of the functions,
+// the drivers don't all run several statements at once
+func splitStatements(script string) []string {
+	var statements []string
+	var statement strings.Builder
+	quoted := false
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if statement.Len() == 0 && (len(trimmed) == 0 || strings.HasPrefix(trimmed, "--")) {
+			continue
+		}
+
+		if strings.Count(line, "$$")%2 == 1 {
+			quoted = !quoted
+		}
+
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if !quoted && strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, statement.String())
+			statement.Reset()
+		}
+	}
+
+	if rest := strings.TrimSpace(statement.String()); len(rest) > 0 {
+		statements = append(statements, rest)
+	}
+
+	return statements
+}
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS GOS_SECURITY_EVENT;
+DROP TABLE IF EXISTS GOS_EXPORT_JOB;
+DROP TABLE IF EXISTS GOS_SESSION;
+DROP TABLE IF EXISTS GOS_AUDIT_LOG;
+DROP TABLE IF EXISTS GOS_USER_IDENTITY;
+DROP TABLE IF EXISTS GOS_REVOKED_TOKEN;
+DROP TABLE IF EXISTS GOS_OAUTH_CONSENT;
+DROP TABLE IF EXISTS GOS_OAUTH_CODE;
+DROP TABLE IF EXISTS GOS_OAUTH_CLIENT;
+DROP TABLE IF EXISTS GOS_ACCESS_TOKEN;
+DROP TABLE IF EXISTS GOS_RECOVERY_CODE;
+DROP TABLE IF EXISTS GOS_PASSWORD_RESET;
+DROP TABLE IF EXISTS GOS_TASK;
+DROP TABLE IF EXISTS GOS_USER;
@@ -0,0 +1,159 @@
+-- the tables of gos, an existing database created by hand from the former README is adopted as it is
+
+CREATE TABLE IF NOT EXISTS GOS_USER (
+user_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+name VARCHAR(100) NOT NULL,
+email VARCHAR(200),
+password VARCHAR(100),
+last_login int(10),
+failed_login_attempt int(8),
+date_created int(10),
+date_updated int(10),
+token_version int(10) NOT NULL DEFAULT 0,
+email_verified tinyint(1) NOT NULL DEFAULT 0,
+date_verification_sent int(10) NOT NULL DEFAULT 0,
+totp_secret VARCHAR(64) NOT NULL DEFAULT '',
+totp_enabled tinyint(1) NOT NULL DEFAULT 0,
+totp_last_step BIGINT NOT NULL DEFAULT 0,
+role VARCHAR(50) NOT NULL DEFAULT 'user',
+disabled tinyint(1) NOT NULL DEFAULT 0,
+deletion_scheduled_at int(10) NOT NULL DEFAULT 0,
+locked_until int(10) NOT NULL DEFAULT 0) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_TASK (
+task_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+user_id BIGINT UNSIGNED,
+title VARCHAR(200) NOT NULL,
+description TEXT,
+date_created int(10),
+date_updated int(10),
+due_date int(10),
+date_complete int(10),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_PASSWORD_RESET (
+reset_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+user_id BIGINT UNSIGNED NOT NULL,
+token_hash CHAR(64) NOT NULL,
+date_created int(10),
+expires_at int(10),
+date_used int(10) NOT NULL DEFAULT 0,
+UNIQUE KEY (token_hash),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_RECOVERY_CODE (
+code_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+user_id BIGINT UNSIGNED NOT NULL,
+code_hash CHAR(64) NOT NULL,
+date_created int(10),
+date_used int(10) NOT NULL DEFAULT 0,
+KEY (user_id, code_hash),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_ACCESS_TOKEN (
+token_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+user_id BIGINT UNSIGNED NOT NULL,
+name VARCHAR(100) NOT NULL,
+token_hash CHAR(64) NOT NULL,
+scopes VARCHAR(500) NOT NULL,
+date_created int(10),
+expires_at int(10),
+last_used int(10) NOT NULL DEFAULT 0,
+date_revoked int(10) NOT NULL DEFAULT 0,
+UNIQUE KEY (token_hash),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_OAUTH_CLIENT (
+client_id VARCHAR(64) PRIMARY KEY,
+user_id BIGINT UNSIGNED NOT NULL,
+name VARCHAR(100) NOT NULL,
+client_secret_hash CHAR(64) NOT NULL DEFAULT '',
+redirect_uris TEXT NOT NULL,
+scopes VARCHAR(500) NOT NULL,
+confidential TINYINT(1) NOT NULL DEFAULT 0,
+date_created int(10),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_OAUTH_CODE (
+code_hash CHAR(64) PRIMARY KEY,
+client_id VARCHAR(64) NOT NULL,
+user_id BIGINT UNSIGNED NOT NULL,
+redirect_uri VARCHAR(2000) NOT NULL,
+scopes VARCHAR(500) NOT NULL,
+code_challenge VARCHAR(128) NOT NULL DEFAULT '',
+code_challenge_method VARCHAR(10) NOT NULL DEFAULT '',
+expires_at int(10),
+date_used int(10) NOT NULL DEFAULT 0,
+FOREIGN KEY (client_id) REFERENCES GOS_OAUTH_CLIENT(client_id),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_OAUTH_CONSENT (
+user_id BIGINT UNSIGNED NOT NULL,
+client_id VARCHAR(64) NOT NULL,
+scopes VARCHAR(500) NOT NULL,
+date_created int(10),
+date_updated int(10),
+PRIMARY KEY (user_id, client_id),
+FOREIGN KEY (client_id) REFERENCES GOS_OAUTH_CLIENT(client_id),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_REVOKED_TOKEN (
+token_id VARCHAR(64) PRIMARY KEY,
+expires_at int(10),
+date_revoked int(10)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_USER_IDENTITY (
+identity_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+user_id BIGINT UNSIGNED NOT NULL,
+issuer VARCHAR(255) NOT NULL,
+subject VARCHAR(255) NOT NULL,
+email VARCHAR(255) NOT NULL,
+date_created int(10),
+last_login int(10) NOT NULL DEFAULT 0,
+UNIQUE KEY (issuer, subject),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_AUDIT_LOG (
+audit_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+actor_user_id BIGINT UNSIGNED NOT NULL,
+action VARCHAR(50) NOT NULL,
+target_user_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+details TEXT,
+ip VARCHAR(45) NOT NULL DEFAULT '',
+date_created int(10),
+KEY (target_user_id, audit_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_SESSION (
+session_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+user_id BIGINT UNSIGNED NOT NULL,
+device_name VARCHAR(100) NOT NULL,
+user_agent VARCHAR(500) NOT NULL DEFAULT '',
+ip VARCHAR(45) NOT NULL DEFAULT '',
+date_created int(10),
+last_seen int(10),
+expires_at int(10),
+date_revoked int(10) NOT NULL DEFAULT 0,
+KEY (user_id, date_revoked),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_EXPORT_JOB (
+job_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+user_id BIGINT UNSIGNED NOT NULL,
+status VARCHAR(20) NOT NULL,
+error VARCHAR(200) NOT NULL DEFAULT '',
+date_created int(10),
+date_completed int(10) NOT NULL DEFAULT 0,
+expires_at int(10) NOT NULL DEFAULT 0,
+KEY (user_id),
+FOREIGN KEY (user_id) REFERENCES GOS_USER(user_id)) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS GOS_SECURITY_EVENT (
+event_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+user_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+type VARCHAR(50) NOT NULL,
+details TEXT,
+ip VARCHAR(45),
+user_agent VARCHAR(500),
+date_created int(10),
+KEY (user_id, event_id),
+KEY (date_created)) ENGINE=InnoDB;
@@ -0,0 +1 @@
+ALTER TABLE GOS_EXPORT_JOB DROP COLUMN pending_user_id;
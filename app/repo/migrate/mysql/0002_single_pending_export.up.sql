@@ -0,0 +1,8 @@
+-- a user has at most one pending export, the unique key on a column set only while pending enforces it
+UPDATE GOS_EXPORT_JOB SET status = 'failed', error = 'superseded by a newer export'
+WHERE status = 'pending' AND job_id NOT IN (
+SELECT job_id FROM (SELECT MAX(job_id) AS job_id FROM GOS_EXPORT_JOB WHERE status = 'pending' GROUP BY user_id) AS latest);
+
+ALTER TABLE GOS_EXPORT_JOB
+ADD COLUMN pending_user_id BIGINT UNSIGNED AS (IF(status = 'pending', user_id, NULL)) STORED,
+ADD UNIQUE KEY (pending_user_id);
@@ -0,0 +1 @@
+ALTER TABLE GOS_USER DROP INDEX email_unique;
@@ -0,0 +1,3 @@
+-- an email belongs to a single account, the unique key compares it with the case insensitive collation of the column.
+-- The accounts sharing an email must be merged or changed by hand before, the migration fails on them
+ALTER TABLE GOS_USER ADD UNIQUE KEY email_unique (email);
@@ -0,0 +1,16 @@
+drop table if exists GOS_SECURITY_EVENT;
+drop table if exists GOS_EXPORT_JOB;
+drop table if exists GOS_SESSION;
+drop table if exists GOS_AUDIT_LOG;
+drop table if exists GOS_USER_IDENTITY;
+drop table if exists GOS_REVOKED_TOKEN;
+drop table if exists GOS_OAUTH_CONSENT;
+drop table if exists GOS_OAUTH_CODE;
+drop table if exists GOS_OAUTH_CLIENT;
+drop table if exists GOS_ACCESS_TOKEN;
+drop table if exists GOS_RECOVERY_CODE;
+drop table if exists GOS_PASSWORD_RESET;
+drop table if exists GOS_TASK;
+drop table if exists GOS_USER;
+drop function if exists gos_unix(timestamptz);
+drop function if exists gos_time(bigint);
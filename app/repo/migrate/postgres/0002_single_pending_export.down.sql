@@ -0,0 +1 @@
+drop index if exists GOS_EXPORT_JOB_pending;
@@ -0,0 +1,2 @@
+create index if not exists GOS_USER_email on GOS_USER (lower(email));
+drop index if exists GOS_USER_email_unique;
@@ -0,0 +1,4 @@
+-- an email belongs to a single account whatever its case, the unique index replaces the lookup index of the emails.
+-- The accounts sharing an email must be merged or changed by hand before, the migration fails on them
+create unique index if not exists GOS_USER_email_unique on GOS_USER (lower(email));
+drop index if exists GOS_USER_email;
@@ -0,0 +1,165 @@
+-- the emails compare case insensitively like the utf8_general_ci collation of mysql
+
+create table if not exists GOS_USER (
+	user_id integer primary key autoincrement,
+	name varchar(100) not null,
+	email varchar(200) collate nocase,
+	password varchar(100),
+	last_login integer,
+	failed_login_attempt integer,
+	date_created integer,
+	date_updated integer,
+	token_version integer not null default 0,
+	email_verified boolean not null default 0,
+	date_verification_sent integer not null default 0,
+	totp_secret varchar(64) not null default '',
+	totp_enabled boolean not null default 0,
+	totp_last_step integer not null default 0,
+	role varchar(50) not null default 'user',
+	disabled boolean not null default 0,
+	deletion_scheduled_at integer not null default 0,
+	locked_until integer not null default 0
+);
+create index if not exists GOS_USER_email on GOS_USER (email);
+
+create table if not exists GOS_TASK (
+	task_id integer primary key autoincrement,
+	user_id integer references GOS_USER (user_id),
+	title varchar(200) not null,
+	description text,
+	date_created integer,
+	date_updated integer,
+	due_date integer,
+	date_complete integer
+);
+create index if not exists GOS_TASK_user_id on GOS_TASK (user_id, task_id);
+
+create table if not exists GOS_PASSWORD_RESET (
+	reset_id integer primary key autoincrement,
+	user_id integer not null references GOS_USER (user_id),
+	token_hash char(64) not null unique,
+	date_created integer,
+	expires_at integer,
+	date_used integer not null default 0
+);
+create index if not exists GOS_PASSWORD_RESET_user_id on GOS_PASSWORD_RESET (user_id);
+
+create table if not exists GOS_RECOVERY_CODE (
+	code_id integer primary key autoincrement,
+	user_id integer not null references GOS_USER (user_id),
+	code_hash char(64) not null,
+	date_created integer,
+	date_used integer not null default 0
+);
+create index if not exists GOS_RECOVERY_CODE_user_id on GOS_RECOVERY_CODE (user_id, code_hash);
+
+create table if not exists GOS_ACCESS_TOKEN (
+	token_id integer primary key autoincrement,
+	user_id integer not null references GOS_USER (user_id),
+	name varchar(100) not null,
+	token_hash char(64) not null unique,
+	scopes varchar(500) not null,
+	date_created integer,
+	expires_at integer,
+	last_used integer not null default 0,
+	date_revoked integer not null default 0
+);
+create index if not exists GOS_ACCESS_TOKEN_user_id on GOS_ACCESS_TOKEN (user_id);
+
+create table if not exists GOS_OAUTH_CLIENT (
+	client_id varchar(64) primary key,
+	user_id integer not null references GOS_USER (user_id),
+	name varchar(100) not null,
+	client_secret_hash char(64) not null default '',
+	redirect_uris text not null,
+	scopes varchar(500) not null,
+	confidential boolean not null default 0,
+	date_created integer
+);
+create index if not exists GOS_OAUTH_CLIENT_user_id on GOS_OAUTH_CLIENT (user_id);
+
+create table if not exists GOS_OAUTH_CODE (
+	code_hash char(64) primary key,
+	client_id varchar(64) not null references GOS_OAUTH_CLIENT (client_id),
+	user_id integer not null references GOS_USER (user_id),
+	redirect_uri varchar(2000) not null,
+	scopes varchar(500) not null,
+	code_challenge varchar(128) not null default '',
+	code_challenge_method varchar(10) not null default '',
+	expires_at integer,
+	date_used integer not null default 0
+);
+
+create table if not exists GOS_OAUTH_CONSENT (
+	user_id integer not null references GOS_USER (user_id),
+	client_id varchar(64) not null references GOS_OAUTH_CLIENT (client_id),
+	scopes varchar(500) not null,
+	date_created integer,
+	date_updated integer,
+	primary key (user_id, client_id)
+);
+
+create table if not exists GOS_REVOKED_TOKEN (
+	token_id varchar(64) primary key,
+	expires_at integer,
+	date_revoked integer
+);
+
+create table if not exists GOS_USER_IDENTITY (
+	identity_id integer primary key autoincrement,
+	user_id integer not null references GOS_USER (user_id),
+	issuer varchar(255) not null,
+	subject varchar(255) not null,
+	email varchar(255) not null,
+	date_created integer,
+	last_login integer not null default 0,
+	unique (issuer, subject)
+);
+create index if not exists GOS_USER_IDENTITY_user_id on GOS_USER_IDENTITY (user_id);
+
+create table if not exists GOS_AUDIT_LOG (
+	audit_id integer primary key autoincrement,
+	actor_user_id integer not null,
+	action varchar(50) not null,
+	target_user_id integer not null default 0,
+	details text,
+	ip varchar(45) not null default '',
+	date_created integer
+);
+create index if not exists GOS_AUDIT_LOG_target_user_id on GOS_AUDIT_LOG (target_user_id, audit_id);
+
+create table if not exists GOS_SESSION (
+	session_id integer primary key autoincrement,
+	user_id integer not null references GOS_USER (user_id),
+	device_name varchar(100) not null,
+	user_agent varchar(500) not null default '',
+	ip varchar(45) not null default '',
+	date_created integer,
+	last_seen integer,
+	expires_at integer,
+	date_revoked integer not null default 0
+);
+create index if not exists GOS_SESSION_user_id on GOS_SESSION (user_id, date_revoked);
+
+create table if not exists GOS_EXPORT_JOB (
+	job_id integer primary key autoincrement,
+	user_id integer not null references GOS_USER (user_id),
+	status varchar(20) not null,
+	error varchar(200) not null default '',
+	date_created integer,
+	date_completed integer not null default 0,
+	expires_at integer not null default 0
+);
+create index if not exists GOS_EXPORT_JOB_user_id on GOS_EXPORT_JOB (user_id);
+
+create table if not exists GOS_SECURITY_EVENT (
+	event_id integer primary key autoincrement,
+	user_id integer not null default 0,
+	type varchar(50) not null,
+	details text,
+	ip varchar(45),
+	user_agent varchar(500),
+	date_created integer
+);
+create index if not exists GOS_SECURITY_EVENT_user_id on GOS_SECURITY_EVENT (user_id, event_id);
+create index if not exists GOS_SECURITY_EVENT_date_created on GOS_SECURITY_EVENT (date_created);
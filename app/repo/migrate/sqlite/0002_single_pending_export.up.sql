@@ -0,0 +1,5 @@
+-- a user has at most one pending export, the partial unique index enforces it
+update GOS_EXPORT_JOB set status = 'failed', error = 'superseded by a newer export'
+where status = 'pending' and job_id not in (select max(job_id) from GOS_EXPORT_JOB where status = 'pending' group by user_id);
+
+create unique index if not exists GOS_EXPORT_JOB_pending on GOS_EXPORT_JOB (user_id) where status = 'pending';
@@ -0,0 +1,5 @@
+-- an email belongs to a single account, the unique index compares it with the nocase collation of the column and
+-- replaces the lookup index of the emails. The accounts sharing an email must be merged or changed by hand before,
+-- the migration fails on them
+create unique index if not exists GOS_USER_email_unique on GOS_USER (email);
+drop index if exists GOS_USER_email;
@@ -0,0 +1,180 @@
+package repo
+
+import (
+	"fmt"
+	"github.com/lib/pq"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// the columns of the selects, the dates are read as unix seconds
+const (
+	postgresUserColumns          = `user_id, name, email, password, gos_unix(last_login), failed_login_attempt, gos_unix(date_created), gos_unix(date_updated), token_version, email_verified, gos_unix(date_verification_sent), totp_secret, totp_enabled, totp_last_step, role, disabled, gos_unix(deletion_scheduled_at), gos_unix(locked_until)`
+	postgresTaskColumns          = `task_id, user_id, title, description, gos_unix(date_created), gos_unix(date_updated), gos_unix(due_date), gos_unix(date_complete)`
+	postgresPasswordResetColumns = `reset_id, user_id, token_hash, gos_unix(date_created), gos_unix(expires_at), gos_unix(date_used)`
+	postgresAccessTokenColumns   = `token_id, user_id, name, token_hash, scopes, gos_unix(date_created), gos_unix(expires_at), gos_unix(last_used), gos_unix(date_revoked)`
+	postgresOAuthClientColumns   = `client_id, user_id, name, client_secret_hash, redirect_uris, scopes, confidential, gos_unix(date_created)`
+	postgresOAuthCodeColumns     = `code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, code_challenge_method, gos_unix(expires_at), gos_unix(date_used)`
+	postgresOAuthConsentColumns  = `user_id, client_id, scopes, gos_unix(date_created), gos_unix(date_updated)`
+	postgresUserIdentityColumns  = `identity_id, user_id, issuer, subject, email, gos_unix(date_created), gos_unix(last_login)`
+	postgresAuditEntryColumns    = `audit_id, actor_user_id, action, target_user_id, details, ip, gos_unix(date_created)`
+	postgresSessionColumns       = `session_id, user_id, device_name, user_agent, ip, gos_unix(date_created), gos_unix(last_seen), gos_unix(expires_at), gos_unix(date_revoked)`
+	postgresExportJobColumns     = `job_id, user_id, status, error, gos_unix(date_created), gos_unix(date_completed), gos_unix(expires_at)`
+	postgresSecurityEventColumns = `event_id, user_id, type, details, ip, user_agent, gos_unix(date_created)`
+)
+
+// postgresStatements are the statements of the repo for postgres, the parameters keep the positions of the mysql ones,
+// the inserts into the tables with a serial id return it. The dates are timestamptz columns, gos_time and gos_unix,
+// created by the migrations, convert them from and to the unix seconds of the models, 0 is stored as null.
+var postgresStatements = map[string]string{
+	createUserStatement:                  `insert into GOS_USER (name, email, password, last_login, failed_login_attempt, date_created, date_updated, token_version, email_verified, date_verification_sent, totp_secret, totp_enabled, totp_last_step, role, disabled, deletion_scheduled_at, locked_until) VALUES ($1, $2, $3, gos_time($4), $5, gos_time($6), gos_time($7), $8, $9, gos_time($10), $11, $12, $13, $14, $15, gos_time($16), gos_time($17)) returning user_id`,
+	updateUserStatement:                  `update GOS_USER set name = $1, email = $2, password = $3, last_login = gos_time($4), failed_login_attempt = $5, date_created = gos_time($6), date_updated = gos_time($7), token_version = $8, email_verified = $9, date_verification_sent = gos_time($10), totp_secret = $11, totp_enabled = $12, totp_last_step = $13, role = $14, disabled = $15, deletion_scheduled_at = gos_time($16), locked_until = gos_time($17) where user_id = $18`,
+	getUserByEmailStatement:              `select ` + postgresUserColumns + ` from GOS_USER where lower(email) = lower($1)`,
+	getUserByIdStatement:                 `select ` + postgresUserColumns + ` from GOS_USER where user_id = $1`,
+	useTOTPStepStatement:                 `update GOS_USER set totp_last_step = $1 where user_id = $2 and totp_last_step < $3`,
+	incrementFailedLoginAttemptStatement: `update GOS_USER set failed_login_attempt = failed_login_attempt + 1 where user_id = $1`,
+	lockLoginStatement:                   `update GOS_USER set failed_login_attempt = 0, locked_until = gos_time($1) where user_id = $2 and failed_login_attempt >= $3`,
+	recordLoginStatement:                 `update GOS_USER set last_login = gos_time($1), failed_login_attempt = 0, locked_until = null where user_id = $2`,
+	rehashPasswordStatement:              `update GOS_USER set password = $1 where user_id = $2 and password = $3`,
+
+	insertTaskStatement:  `insert into GOS_TASK (user_id, title, description, date_created, date_updated, due_date, date_complete) VALUES ($1, $2, $3, gos_time($4), gos_time($5), gos_time($6), gos_time($7)) returning task_id`,
+	getTasksStatement:    `select ` + postgresTaskColumns + ` from GOS_TASK where task_id > $1 and user_id = $2 order by task_id desc limit $3`,
+	getTaskByIdStatement: `select ` + postgresTaskColumns + ` from GOS_TASK where task_id = $1 and user_id = $2`,
+
+	insertPasswordResetStatement:         `insert into GOS_PASSWORD_RESET (user_id, token_hash, date_created, expires_at, date_used) VALUES ($1, $2, gos_time($3), gos_time($4), gos_time($5)) returning reset_id`,
+	getPasswordResetByTokenHashStatement: `select ` + postgresPasswordResetColumns + ` from GOS_PASSWORD_RESET where token_hash = $1`,
+	usePasswordResetStatement:            `update GOS_PASSWORD_RESET set date_used = gos_time($1) where reset_id = $2 and date_used is null`,
+	invalidatePasswordResetsStatement:    `update GOS_PASSWORD_RESET set date_used = gos_time($1) where user_id = $2 and date_used is null`,
+
+	insertRecoveryCodeStatement:  `insert into GOS_RECOVERY_CODE (user_id, code_hash, date_created, date_used) VALUES ($1, $2, gos_time($3), gos_time($4)) returning code_id`,
+	useRecoveryCodeStatement:     `update GOS_RECOVERY_CODE set date_used = gos_time($1) where user_id = $2 and code_hash = $3 and date_used is null`,
+	deleteRecoveryCodesStatement: `delete from GOS_RECOVERY_CODE where user_id = $1`,
+
+	insertAccessTokenStatement:         `insert into GOS_ACCESS_TOKEN (user_id, name, token_hash, scopes, date_created, expires_at, last_used, date_revoked) VALUES ($1, $2, $3, $4, gos_time($5), gos_time($6), gos_time($7), gos_time($8)) returning token_id`,
+	getAccessTokenByTokenHashStatement: `select ` + postgresAccessTokenColumns + ` from GOS_ACCESS_TOKEN where token_hash = $1`,
+	getAccessTokensStatement:           `select ` + postgresAccessTokenColumns + ` from GOS_ACCESS_TOKEN where user_id = $1 and date_revoked is null order by token_id desc`,
+	revokeAccessTokenStatement:         `update GOS_ACCESS_TOKEN set date_revoked = gos_time($1) where token_id = $2 and user_id = $3 and date_revoked is null`,
+	updateAccessTokenLastUsedStatement: `update GOS_ACCESS_TOKEN set last_used = gos_time($1) where token_id = $2`,
+	revokeUserAccessTokensStatement:    `update GOS_ACCESS_TOKEN set date_revoked = gos_time($1) where user_id = $2 and date_revoked is null`,
+
+	insertOAuthClientStatement:      `insert into GOS_OAUTH_CLIENT (client_id, user_id, name, client_secret_hash, redirect_uris, scopes, confidential, date_created) VALUES ($1, $2, $3, $4, $5, $6, $7, gos_time($8))`,
+	getOAuthClientByIdStatement:     `select ` + postgresOAuthClientColumns + ` from GOS_OAUTH_CLIENT where client_id = $1`,
+	getOAuthClientsStatement:        `select ` + postgresOAuthClientColumns + ` from GOS_OAUTH_CLIENT where user_id = $1 order by date_created desc`,
+	insertOAuthCodeStatement:        `insert into GOS_OAUTH_CODE (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, code_challenge_method, expires_at, date_used) VALUES ($1, $2, $3, $4, $5, $6, $7, gos_time($8), gos_time($9))`,
+	getOAuthCodeByCodeHashStatement: `select ` + postgresOAuthCodeColumns + ` from GOS_OAUTH_CODE where code_hash = $1`,
+	useOAuthCodeStatement:           `update GOS_OAUTH_CODE set date_used = gos_time($1) where code_hash = $2 and date_used is null`,
+	getOAuthConsentStatement:        `select ` + postgresOAuthConsentColumns + ` from GOS_OAUTH_CONSENT where user_id = $1 and client_id = $2`,
+	saveOAuthConsentStatement:       `insert into GOS_OAUTH_CONSENT (user_id, client_id, scopes, date_created, date_updated) VALUES ($1, $2, $3, gos_time($4), gos_time($5)) on conflict (user_id, client_id) do update set scopes = excluded.scopes, date_updated = excluded.date_updated`,
+	insertRevokedTokenStatement:     `insert into GOS_REVOKED_TOKEN (token_id, expires_at, date_revoked) VALUES ($1, gos_time($2), gos_time($3)) on conflict do nothing`,
+	isTokenRevokedStatement:         `select count(*) from GOS_REVOKED_TOKEN where token_id = $1`,
+
+	insertUserIdentityStatement:          `insert into GOS_USER_IDENTITY (user_id, issuer, subject, email, date_created, last_login) VALUES ($1, $2, $3, $4, gos_time($5), gos_time($6)) returning identity_id`,
+	getUserIdentityStatement:             `select ` + postgresUserIdentityColumns + ` from GOS_USER_IDENTITY where issuer = $1 and subject = $2`,
+	updateUserIdentityLastLoginStatement: `update GOS_USER_IDENTITY set last_login = gos_time($1) where identity_id = $2`,
+
+	// like escapes with backslash by default in postgres too, ilike matches case insensitively like the mysql collation
+	searchUsersStatement:      `select ` + postgresUserColumns + ` from GOS_USER where user_id > $1 and ($2::text = '' or email ilike $3 or name ilike $4) order by user_id limit $5`,
+	insertAuditEntryStatement: `insert into GOS_AUDIT_LOG (actor_user_id, action, target_user_id, details, ip, date_created) VALUES ($1, $2, $3, $4, $5, gos_time($6)) returning audit_id`,
+	getAuditEntriesStatement:  `select ` + postgresAuditEntryColumns + ` from GOS_AUDIT_LOG where audit_id > $1 and ($2::bigint = 0 or target_user_id = $3) order by audit_id limit $4`,
+
+	insertSessionStatement:         `insert into GOS_SESSION (user_id, device_name, user_agent, ip, date_created, last_seen, expires_at, date_revoked) VALUES ($1, $2, $3, $4, gos_time($5), gos_time($6), gos_time($7), gos_time($8)) returning session_id`,
+	getSessionByIdStatement:        `select ` + postgresSessionColumns + ` from GOS_SESSION where session_id = $1`,
+	getSessionsStatement:           `select ` + postgresSessionColumns + ` from GOS_SESSION where user_id = $1 and date_revoked is null and expires_at > gos_time($2) order by last_seen desc`,
+	updateSessionLastSeenStatement: `update GOS_SESSION set last_seen = gos_time($1) where session_id = $2`,
+	revokeSessionStatement:         `update GOS_SESSION set date_revoked = gos_time($1) where session_id = $2 and user_id = $3 and date_revoked is null`,
+	revokeUserSessionsStatement:    `update GOS_SESSION set date_revoked = gos_time($1) where user_id = $2 and date_revoked is null`,
+
+	getUsersDueForDeletionStatement:   `select ` + postgresUserColumns + ` from GOS_USER where deletion_scheduled_at <= gos_time($1) order by user_id limit $2`,
+	deleteUserTasksStatement:          `delete from GOS_TASK where user_id = $1`,
+	anonymizeUserTasksStatement:       `update GOS_TASK set user_id = null where user_id = $1`,
+	deleteUserOAuthCodesStatement:     `delete from GOS_OAUTH_CODE where user_id = $1 or client_id in (select client_id from GOS_OAUTH_CLIENT where user_id = $2)`,
+	deleteUserOAuthConsentsStatement:  `delete from GOS_OAUTH_CONSENT where user_id = $1 or client_id in (select client_id from GOS_OAUTH_CLIENT where user_id = $2)`,
+	deleteUserOAuthClientsStatement:   `delete from GOS_OAUTH_CLIENT where user_id = $1`,
+	deleteUserAccessTokensStatement:   `delete from GOS_ACCESS_TOKEN where user_id = $1`,
+	deleteUserPasswordResetsStatement: `delete from GOS_PASSWORD_RESET where user_id = $1`,
+	deleteUserIdentitiesStatement:     `delete from GOS_USER_IDENTITY where user_id = $1`,
+	deleteUserSessionsStatement:       `delete from GOS_SESSION where user_id = $1`,
+	deleteUserStatement:               `delete from GOS_USER where user_id = $1`,
+
+	getTasksByUserStatement:       `select ` + postgresTaskColumns + ` from GOS_TASK where user_id = $1 and task_id > $2 order by task_id limit $3`,
+	getUserSessionsStatement:      `select ` + postgresSessionColumns + ` from GOS_SESSION where user_id = $1 order by session_id`,
+	getUserIdentitiesStatement:    `select ` + postgresUserIdentityColumns + ` from GOS_USER_IDENTITY where user_id = $1 order by identity_id`,
+	insertExportJobStatement:      `insert into GOS_EXPORT_JOB (user_id, status, error, date_created, date_completed, expires_at) VALUES ($1, $2, $3, gos_time($4), gos_time($5), gos_time($6)) returning job_id`,
+	getExportJobStatement:         `select ` + postgresExportJobColumns + ` from GOS_EXPORT_JOB where job_id = $1 and user_id = $2`,
+	getExportJobsStatement:        `select ` + postgresExportJobColumns + ` from GOS_EXPORT_JOB where user_id = $1 order by job_id desc`,
+	getStaleExportJobsStatement:   `select ` + postgresExportJobColumns + ` from GOS_EXPORT_JOB where (status = 'done' and coalesce(expires_at, 'epoch') <= gos_time($1)) or (status = 'pending' and date_created <= gos_time($2)) or (status = 'failed' and date_created <= gos_time($3)) order by job_id limit $4`,
+	updateExportJobStatement:      `update GOS_EXPORT_JOB set status = $1, error = $2, date_completed = gos_time($3), expires_at = gos_time($4) where job_id = $5`,
+	deleteExportJobStatement:      `delete from GOS_EXPORT_JOB where job_id = $1`,
+	deleteUserExportJobsStatement: `delete from GOS_EXPORT_JOB where user_id = $1`,
+
+	insertSecurityEventStatement:        `insert into GOS_SECURITY_EVENT (user_id, type, details, ip, user_agent, date_created) VALUES ($1, $2, $3, $4, $5, gos_time($6)) returning event_id`,
+	getSecurityEventsStatement:          `select ` + postgresSecurityEventColumns + ` from GOS_SECURITY_EVENT where event_id > $1 and ($2::bigint = 0 or user_id = $3) and ($4::text = '' or type = $5) order by event_id limit $6`,
+	deleteSecurityEventsBeforeStatement: `delete from GOS_SECURITY_EVENT where event_id in (select event_id from GOS_SECURITY_EVENT where date_created < gos_time($1) order by event_id limit $2)`,
+	deleteUserSecurityEventsStatement:   `delete from GOS_SECURITY_EVENT where user_id = $1`,
+}
+
+// postgresDialect rewrites every statement, the inserts return the id of their row
+var postgresDialect = sqlDialect{
+	rewrite: func(statement string) string {
+		if rewritten, ok := postgresStatements[statement]; ok {
+			return rewritten
+		}
+
+		return statement
+	},
+	returningIds: true,
+	isDuplicate: func(err error) bool {
+		pqErr, ok := err.(*pq.Error)
+		return ok && pqErr.Code.Name() == "unique_violation"
+	},
+	isForeignKey: func(err error) bool {
+		pqErr, ok := err.(*pq.Error)
+		return ok && pqErr.Code.Name() == "foreign_key_violation"
+	},
+	isUnavailable: func(err error) bool {
+		// the class 08 is the connection exceptions, the class 57 a server shutting down or starting up
+		pqErr, ok := err.(*pq.Error)
+		return err == pq.ErrSSLNotSupported || ok && (pqErr.Code.Class() == "08" || pqErr.Code.Class() == "57" || pqErr.Code.Name() == "too_many_connections")
+	},
+	isRetryable: func(err error) bool {
+		pqErr, ok := err.(*pq.Error)
+		return ok && (pqErr.Code.Name() == "serialization_failure" || pqErr.Code.Name() == "deadlock_detected")
+	},
+}
+
+// postgresDataSourceName builds the url of the database from the host, port, name, user and password,
+// the TLS settings pick the sslmode and the CA
+func postgresDataSourceName(dbConfig DbConfig) (string, error) {
+	params := url.Values{}
+	switch dbConfig.TLS {
+	case "":
+	case "false":
+		params.Set("sslmode", "disable")
+	case "true":
+		params.Set("sslmode", "verify-full")
+	case "skip-verify":
+		params.Set("sslmode", "require")
+	default:
+		return "", fmt.Errorf("unknown db TLS mode [%s]", dbConfig.TLS)
+	}
+
+	if len(dbConfig.TLSCA) > 0 {
+		if dbConfig.TLS == "false" || dbConfig.TLS == "skip-verify" {
+			return "", fmt.Errorf("the db TLS mode [%s] doesn't verify the server, the CA [%s] would be unused", dbConfig.TLS, dbConfig.TLSCA)
+		}
+
+		params.Set("sslmode", "verify-full")
+		params.Set("sslrootcert", dbConfig.TLSCA)
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(dbConfig.User, dbConfig.Password),
+		Host:     net.JoinHostPort(dbConfig.Host, strconv.Itoa(dbConfig.Port)),
+		Path:     "/" + dbConfig.DatabaseName,
+		RawQuery: params.Encode(),
+	}
+
+	return u.String(), nil
+}
@@ -0,0 +1,235 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaRetryDelay is how long a replica down waits before a read tries it again, when the health checks are disabled
+const replicaRetryDelay = 5 * time.Second
+
+// replicaSet routes the reads which tolerate a replication lag to the healthy replicas in turn, the primary serves them
+// when none is healthy. The keys of the rows written on the primary are pinned to it for a while, so their writer reads
+// them back, only within this process.
+type replicaSet struct {
+	replicas  []*replica
+	next      uint64
+	pinWindow time.Duration
+	// checked tells whether the health checks run, without them a read tries a replica down again after a delay
+	checked bool
+
+	mu        sync.Mutex
+	pinned    map[string]time.Time
+	lastUnpin time.Time
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// replica is a read replica with the statements of the repo prepared on it
+type replica struct {
+	index   int
+	repo    *AppRepo
+	healthy atomic.Bool
+	// retryAt is when a read may try the replica down again, in unix nanoseconds
+	retryAt atomic.Int64
+}
+
+// newReplicaSet starts checking the health of the replicas every interval, they start healthy
+func newReplicaSet(replicaRepos []*AppRepo, checkInterval time.Duration, pinWindow time.Duration) *replicaSet {
+	s := &replicaSet{
+		pinWindow: pinWindow,
+		checked:   checkInterval > 0,
+		pinned:    make(map[string]time.Time),
+		stop:      make(chan struct{}),
+	}
+
+	for i, replicaRepo := range replicaRepos {
+		rep := &replica{index: i, repo: replicaRepo}
+		rep.healthy.Store(true)
+		s.replicas = append(s.replicas, rep)
+	}
+
+	if s.checked {
+		s.done.Add(1)
+		go s.checkHealth(checkInterval)
+	}
+
+	return s
+}
+
+// checkHealth pings the replicas every interval until the set is closed, the expired pins are dropped too
+func (s *replicaSet) checkHealth(interval time.Duration) {
+	defer s.done.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, rep := range s.replicas {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := rep.repo.con.PingContext(ctx)
+			cancel()
+
+			s.setHealthy(rep, err)
+		}
+
+		s.unpinExpired(time.Now())
+	}
+}
+
+// setHealthy marks the replica up when err is nil and down otherwise, the changes are logged
+func (s *replicaSet) setHealthy(rep *replica, err error) {
+	if err != nil {
+		rep.retryAt.Store(time.Now().Add(replicaRetryDelay).UnixNano())
+	}
+
+	if rep.healthy.Swap(err == nil) == (err == nil) {
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("db replica %d is down, its reads go to the primary: %v\n", rep.index, err)
+	} else {
+		fmt.Printf("db replica %d is back\n", rep.index)
+	}
+}
+
+// pick returns the next healthy replica, nil when none is. Without the health checks a replica down is returned to
+// a single read once its retry delay passed, which marks it up again when the replica serves it.
+func (s *replicaSet) pick() *replica {
+	start := atomic.AddUint64(&s.next, 1)
+	now := time.Now().UnixNano()
+	for i := range s.replicas {
+		rep := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if rep.healthy.Load() {
+			return rep
+		}
+
+		if retryAt := rep.retryAt.Load(); !s.checked && now >= retryAt &&
+			rep.retryAt.CompareAndSwap(retryAt, now+int64(replicaRetryDelay)) {
+			return rep
+		}
+	}
+
+	return nil
+}
+
+// fellBack marks the replica down when err tells it can't serve the read, which then goes to the primary,
+// and up when it answered the read
+func (s *replicaSet) fellBack(rep *replica, err error) bool {
+	if !errors.Is(err, ErrUnavailable) {
+		if (err == nil || errors.Is(err, ErrNotFound)) && !rep.healthy.Load() {
+			s.setHealthy(rep, nil)
+		}
+
+		return false
+	}
+
+	s.setHealthy(rep, err)
+	return true
+}
+
+// pin sends the reads of the keys to the primary for the pin window
+func (s *replicaSet) pin(keys ...string) {
+	if s == nil || s.pinWindow <= 0 {
+		return
+	}
+
+	now := time.Now()
+	until := now.Add(s.pinWindow)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		s.pinned[key] = until
+	}
+
+	// the pins of the keys which are not read again are dropped here once a window, the health checks may be disabled
+	if now.Sub(s.lastUnpin) >= s.pinWindow {
+		s.lastUnpin = now
+		s.dropExpired(now)
+	}
+}
+
+// isPinned tells whether the reads of the key go to the primary, an expired pin is dropped here as well since the
+// health checks, which drop the others, may be disabled
+func (s *replicaSet) isPinned(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.pinned[key]
+	if ok && !time.Now().Before(until) {
+		delete(s.pinned, key)
+		return false
+	}
+
+	return ok
+}
+
+func (s *replicaSet) unpinExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dropExpired(now)
+}
+
+// dropExpired deletes the expired pins, mu is held
+func (s *replicaSet) dropExpired(now time.Time) {
+	for key, until := range s.pinned {
+		if !now.Before(until) {
+			delete(s.pinned, key)
+		}
+	}
+}
+
+// close stops the health checks and closes the replicas
+func (s *replicaSet) close() error {
+	close(s.stop)
+	s.done.Wait()
+
+	var errs []error
+	for _, rep := range s.replicas {
+		errs = append(errs, rep.repo.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// reader returns the replica serving a read of the key, nil when the primary serves it: within a transaction,
+// while the key is pinned after a write, or when no replica is healthy
+func (r *AppRepo) reader(ctx context.Context, key string) *replica {
+	if r.replicas == nil || r.txOf(ctx) != nil || r.replicas.isPinned(key) {
+		return nil
+	}
+
+	return r.replicas.pick()
+}
+
+// userKey pins the rows of a user, its tasks
+func userKey(userId int64) string {
+	return "user:" + strconv.FormatInt(userId, 10)
+}
+
+// accountKey pins the row of the user itself, apart from its tasks since every login writes it
+func accountKey(userId int64) string {
+	return "account:" + strconv.FormatInt(userId, 10)
+}
+
+// emailKey pins the user with the email, the lookups ignore the case
+func emailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"testing"
+)
+
+func TestReplicaDownIsRetriedWithoutHealthChecks(t *testing.T) {
+	s := newReplicaSet([]*AppRepo{{}}, 0, 0)
+	rep := s.replicas[0]
+
+	if !s.fellBack(rep, ErrUnavailable) {
+		t.Fatal("fellBack of an unavailable replica = false, want true")
+	}
+
+	if picked := s.pick(); picked != nil {
+		t.Fatal("pick returned the replica down before its retry delay")
+	}
+
+	// the retry delay passed, a single read tries the replica again
+	rep.retryAt.Store(0)
+	if picked := s.pick(); picked != rep {
+		t.Fatal("pick didn't return the replica down after its retry delay")
+	}
+
+	if picked := s.pick(); picked != nil {
+		t.Fatal("pick returned the replica down to a second read")
+	}
+
+	if s.fellBack(rep, nil) {
+		t.Fatal("fellBack of a served read = true, want false")
+	}
+
+	if picked := s.pick(); picked != rep {
+		t.Fatal("pick didn't return the replica marked up")
+	}
+}
+
+func TestReplicaDownWaitsForHealthChecks(t *testing.T) {
+	s := newReplicaSet([]*AppRepo{{}}, 0, 0)
+	s.checked = true
+	rep := s.replicas[0]
+
+	s.fellBack(rep, ErrUnavailable)
+	rep.retryAt.Store(0)
+
+	if picked := s.pick(); picked != nil {
+		t.Fatal("pick returned the replica down while the health checks run")
+	}
+}
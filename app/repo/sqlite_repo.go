@@ -0,0 +1,61 @@
+package repo
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"net/url"
+	"time"
+)
+
+// sqliteStatements replaces the statements using the mysql extensions sqlite doesn't have
+var sqliteStatements = map[string]string{
+	saveOAuthConsentStatement:   `insert into GOS_OAUTH_CONSENT (user_id, client_id, scopes, date_created, date_updated) VALUES (?, ?, ?, ?, ?) on conflict (user_id, client_id) do update set scopes = excluded.scopes, date_updated = excluded.date_updated`,
+	insertRevokedTokenStatement: `insert or ignore into GOS_REVOKED_TOKEN (token_id, expires_at, date_revoked) VALUES (?, ?, ?)`,
+	// sqlite has no default escape character for like
+	searchUsersStatement: `select user_id, name, email, password, last_login, failed_login_attempt, date_created, date_updated, token_version, email_verified, date_verification_sent, totp_secret, totp_enabled, totp_last_step, role, disabled, deletion_scheduled_at, locked_until from GOS_USER where user_id > ? and (? = '' or email like ? escape '\' or name like ? escape '\') order by user_id limit ?`,
+	// sqlite is usually built without the limit of delete
+	deleteSecurityEventsBeforeStatement: `delete from GOS_SECURITY_EVENT where event_id in (select event_id from GOS_SECURITY_EVENT where date_created < ? order by event_id limit ?)`,
+}
+
+// connectSQLite opens the sqlite database at path,
+// the journal is in WAL mode so the readers don't wait for the writer, and a writer waits busyTimeout for another one
+func connectSQLite(path string, busyTimeout time.Duration) (*sqlx.DB, error) {
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_busy_timeout", fmt.Sprint(busyTimeout.Milliseconds()))
+	params.Set("_foreign_keys", "1")
+	// the transactions take the write lock when they begin, a deferred one can't wait for it when it upgrades
+	params.Set("_txlock", "immediate")
+
+	return sqlx.Connect("sqlite3", "file:"+path+"?"+params.Encode())
+}
+
+// sqliteDialect rewrites the statements using the mysql extensions
+var sqliteDialect = sqlDialect{
+	rewrite: func(statement string) string {
+		if rewritten, ok := sqliteStatements[statement]; ok {
+			return rewritten
+		}
+
+		return statement
+	},
+	isDuplicate: func(err error) bool {
+		sqliteErr, ok := err.(sqlite3.Error)
+		return ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+	},
+	isForeignKey: func(err error) bool {
+		sqliteErr, ok := err.(sqlite3.Error)
+		return ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+	},
+	isUnavailable: func(err error) bool {
+		// the busy timeout passed waiting for another writer, or the file can't be opened
+		sqliteErr, ok := err.(sqlite3.Error)
+		return ok && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked || sqliteErr.Code == sqlite3.ErrCantOpen)
+	},
+	isRetryable: func(err error) bool {
+		// the busy timeout passed waiting for another writer
+		sqliteErr, ok := err.(sqlite3.Error)
+		return ok && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+	},
+}
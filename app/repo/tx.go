@@ -0,0 +1,144 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxTxAttempts is how many times WithTx runs a transaction failing on a deadlock or a serialization failure
+const maxTxAttempts = 3
+
+// txRetryDelay is the wait before running a transaction again, it grows with every attempt
+const txRetryDelay = 20 * time.Millisecond
+
+// txContextKey keys the transaction carried by a context of ContextWithTx
+type txContextKey struct{}
+
+// ContextWithTx returns a context carrying the transaction of the repo given to the function of WithTx.
+// The calls of the repo it was started from join the transaction when made with the context, and their WithTx run
+// as savepoints of it, so services taking a context compose their transactions without passing the repo around.
+// A decorator of the repo, like the cache, is unwrapped by its Unwrap method.
+func ContextWithTx(ctx context.Context, tx IAppRepo) context.Context {
+	for {
+		decorator, ok := tx.(interface{ Unwrap() IAppRepo })
+		if !ok {
+			break
+		}
+
+		tx = decorator.Unwrap()
+	}
+
+	return context.WithValue(ctx, txContextKey{}, tx)
+}
+
+// InTx tells whether ctx carries a transaction of ContextWithTx
+func InTx(ctx context.Context) bool {
+	return ctx.Value(txContextKey{}) != nil
+}
+
+// repoTx is the transaction of a WithTx, the nested ones are savepoints of it.
+// Like sql.Tx it is meant to be used by one goroutine at a time.
+type repoTx struct {
+	tx         *sql.Tx
+	savepoints int
+}
+
+// txOf returns the transaction the call runs in, the one of the repo or the one carried by ctx, nil when there is none
+func (r *AppRepo) txOf(ctx context.Context) *repoTx {
+	if r.tx != nil {
+		return r.tx
+	}
+
+	if tx, ok := ctx.Value(txContextKey{}).(*AppRepo); ok && tx.tx != nil && tx.con == r.con {
+		return tx.tx
+	}
+
+	return nil
+}
+
+// stmt returns the statement bound to the transaction of the call, or the statement itself outside of one
+func (r *AppRepo) stmt(ctx context.Context, stm *sql.Stmt) *sql.Stmt {
+	if tx := r.txOf(ctx); tx != nil {
+		return tx.tx.StmtContext(ctx, stm)
+	}
+
+	return stm
+}
+
+// WithTx runs fn with a repo bound to a transaction, committed when fn returns nil and rolled back otherwise,
+// a panic rolls back too. Within a transaction, given to fn or carried by ctx, it runs in a savepoint instead.
+// A transaction failing on a deadlock or a serialization failure is run again from the start, up to maxTxAttempts
+// times, so fn must not have effects outside of the repo. Within fn only the repo given to it, or a context of
+// ContextWithTx, may be used: other calls run on other connections and may wait for the locks of the transaction.
+func (r *AppRepo) WithTx(ctx context.Context, fn func(repo IAppRepo) error) error {
+	if tx := r.txOf(ctx); tx != nil {
+		return r.withSavepoint(ctx, tx, fn)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := r.runTx(ctx, fn)
+		if err == nil || attempt == maxTxAttempts || !findError(err, r.dialect.isRetryable) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return r.dbError(ctx, fmt.Errorf("%w, retry given up: %v", ctx.Err(), err))
+		case <-time.After(time.Duration(attempt) * txRetryDelay):
+		}
+	}
+}
+
+// runTx runs fn in a new transaction
+func (r *AppRepo) runTx(ctx context.Context, fn func(repo IAppRepo) error) error {
+	sqlTx, err := r.con.BeginTx(ctx, nil)
+	if err != nil {
+		return r.dbError(ctx, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	txRepo := *r
+	txRepo.tx = &repoTx{tx: sqlTx}
+	if err := fn(&txRepo); err != nil {
+		if rollbackErr := sqlTx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w, rollback failed: %v", err, rollbackErr)
+		}
+
+		return err
+	}
+
+	return r.dbError(ctx, sqlTx.Commit())
+}
+
+// withSavepoint runs fn in a savepoint of tx, a failing fn only rolls back its own changes
+func (r *AppRepo) withSavepoint(ctx context.Context, tx *repoTx, fn func(repo IAppRepo) error) error {
+	tx.savepoints++
+	defer func() { tx.savepoints-- }()
+
+	savepoint := fmt.Sprintf("gos_savepoint_%d", tx.savepoints)
+	if _, err := tx.tx.ExecContext(ctx, "savepoint "+savepoint); err != nil {
+		return r.dbError(ctx, err)
+	}
+
+	txRepo := *r
+	txRepo.tx = tx
+	if err := fn(&txRepo); err != nil {
+		if _, rollbackErr := tx.tx.ExecContext(ctx, "rollback to savepoint "+savepoint); rollbackErr != nil {
+			return fmt.Errorf("%w, rollback to savepoint failed: %v", err, rollbackErr)
+		}
+
+		return err
+	}
+
+	_, err := tx.tx.ExecContext(ctx, "release savepoint "+savepoint)
+	return r.dbError(ctx, err)
+}
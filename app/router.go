@@ -1,31 +1,42 @@
 package app
 
 import (
+	"context"
+	"crypto/subtle"
+	"expvar"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"gos/app/auth"
+	"gos/app/config"
 	"gos/app/controller"
 	"gos/app/models"
 	"net/http"
+	"time"
 )
 
 type Router struct {
 	Engine     *gin.Engine
 	Controller controller.IAppController
 	Auth       auth.IAuth
+	Config     config.Config
 }
 
 // NewRouter creates a new router
-func NewRouter(controller controller.IAppController, auth auth.IAuth) *Router {
+func NewRouter(controller controller.IAppController, auth auth.IAuth, cfg config.Config) *Router {
 	engine := gin.Default()
 
 	router := &Router{
 		Engine:     engine,
 		Controller: controller,
 		Auth:       auth,
+		Config:     cfg,
 	}
 
 	engine.Use(addContentTypeHeader)
 	engine.Use(handle404)
+	if cfg.DbRequestTimeout > 0 {
+		engine.Use(dbTimeout(cfg.DbRequestTimeout))
+	}
 
 	registerRoutes(engine, router)
 
@@ -36,10 +47,36 @@ func addContentTypeHeader(context *gin.Context) {
 	context.Header("Content-Type", "application/json; charset=utf-8")
 }
 
+// dbTimeout bounds the db calls of a request, they run with the context of the request
+// which is also canceled when the client goes away
+func dbTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		dbCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+
+		ctx.Request = ctx.Request.WithContext(dbCtx)
+		ctx.Next()
+	}
+}
+
 func (r *Router) authMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		accessToken := ctx.GetHeader(auth.TokenHeader)
+		accessToken := auth.RequestAccessToken(ctx)
+		cookieAuth := false
+		if len(accessToken) == 0 {
+			accessToken, _ = ctx.Cookie(auth.SessionCookie)
+			cookieAuth = len(accessToken) > 0
+		}
+
 		_, err := r.Auth.AuthenticateUser(ctx, accessToken)
+		if status, failed := controller.FailureStatus(err); failed {
+			ctx.AbortWithStatusJSON(status, &models.Response{
+				Message: "AUTHENTICATION FAILED",
+				Errors:  []string{err.Error()},
+			})
+			return
+		}
+
 		if err != nil {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, &models.Response{
 				Message: "UNAUTHORIZED ACCESS",
@@ -47,6 +84,87 @@ func (r *Router) authMiddleware() gin.HandlerFunc {
 			})
 			return
 		}
+
+		if cookieAuth && !isSafeMethod(ctx.Request.Method) && !r.validCSRFToken(ctx, accessToken) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, &models.Response{
+				Message: "INVALID CSRF TOKEN",
+				Errors:  []string{fmt.Sprintf("requests authenticated by the session cookie must send the %s header", auth.CSRFHeader)},
+			})
+			return
+		}
+	}
+}
+
+// validCSRFToken checks the double-submit csrf token, the header must repeat the cookie and be signed for the session
+func (r *Router) validCSRFToken(ctx *gin.Context, sessionToken string) bool {
+	header := ctx.GetHeader(auth.CSRFHeader)
+	cookie, err := ctx.Cookie(auth.CSRFCookie)
+	if err != nil || len(header) == 0 || subtle.ConstantTimeCompare([]byte(header), []byte(cookie)) != 1 {
+		return false
+	}
+
+	return r.Auth.ValidateCSRFToken(sessionToken, header)
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// requireScopes aborts with 403 unless the token was granted all the scopes
+func (r *Router) requireScopes(scopes ...string) gin.HandlerFunc {
+	required := auth.JoinScopes(scopes)
+	return func(ctx *gin.Context) {
+		claims := ctx.MustGet("claims").(*auth.Claims)
+		if auth.IsSubset(scopes, claims.Scopes()) {
+			return
+		}
+
+		ctx.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", error_description="the token is missing a required scope", scope="%s"`, required))
+		ctx.AbortWithStatusJSON(http.StatusForbidden, &models.Response{
+			Message: "INSUFFICIENT SCOPE",
+			Errors:  []string{fmt.Sprintf("the token requires the scopes [%s]", required)},
+		})
+	}
+}
+
+// requireRole aborts with 403 unless the token was issued with the role and the user still has it,
+// so a demoted admin loses the access at once
+func (r *Router) requireRole(role string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims := ctx.MustGet("claims").(*auth.Claims)
+		user := ctx.MustGet("user").(*models.User)
+		if claims.Role == role && auth.UserRole(user.Role) == role {
+			return
+		}
+
+		ctx.AbortWithStatusJSON(http.StatusForbidden, &models.Response{
+			Message: "FORBIDDEN",
+			Errors:  []string{fmt.Sprintf("the role [%s] is required", role)},
+		})
+	}
+}
+
+// verifiedMiddleware applies the configured policy for users who haven't verified their email
+func (r *Router) verifiedMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		user := ctx.MustGet("user").(*models.User)
+		if user.EmailVerified {
+			return
+		}
+
+		switch r.Config.UnverifiedAccess {
+		case config.UnverifiedAccessFull:
+			return
+		case config.UnverifiedAccessReadOnly:
+			if isSafeMethod(ctx.Request.Method) {
+				return
+			}
+		}
+
+		ctx.AbortWithStatusJSON(http.StatusForbidden, &models.Response{
+			Message: "EMAIL NOT VERIFIED",
+			Errors:  []string{"verify your email to access this resource"},
+		})
 	}
 }
 
@@ -64,16 +182,86 @@ func registerRoutes(engine *gin.Engine, router *Router) {
 	{
 		api.POST("/register", router.Controller.Register)
 		api.POST("/login", router.Controller.Login)
+		api.POST("/login/2fa", router.Controller.LoginMFA)
+		api.POST("/logout", router.Controller.Logout)
+		api.POST("/password/forgot", router.Controller.ForgotPassword)
+		api.POST("/password/reset", router.Controller.ResetPassword)
+		api.GET("/verify", router.Controller.VerifyEmail)
+		api.POST("/verify/resend", router.Controller.ResendVerification)
+		api.GET("/email/confirm", router.Controller.ConfirmEmailChange)
+		api.GET("/oidc/login", router.Controller.OIDCLogin)
+		api.GET("/oidc/callback", router.Controller.OIDCCallback)
+	}
+
+	// the download links carry their own signed token, so they work from a plain browser download
+	exports := engine.Group("/api/exports")
+	{
+		exports.GET("/download", router.Controller.DownloadExport)
+	}
+
+	// oauth clients authenticate with their own credentials
+	oauth := engine.Group("/oauth")
+	{
+		oauth.POST("/token", router.Controller.Token)
+		oauth.POST("/introspect", router.Controller.Introspect)
+		oauth.POST("/revoke", router.Controller.Revoke)
 	}
 
 	// basic auth
 	secured := engine.Group("/api/secured")
 	{
 		secured.Use(router.authMiddleware())
+		secured.Use(router.verifiedMiddleware())
+		{
+			secured.POST("/tasks", router.requireScopes(auth.ScopeTasksWrite), router.Controller.AddTask)
+			secured.GET("/tasks", router.requireScopes(auth.ScopeTasksRead), router.Controller.GetTasks)
+			secured.GET("/tasks/:taskId", router.requireScopes(auth.ScopeTasksRead), router.Controller.GetTask)
+
+			account := router.requireScopes(auth.ScopeAccountAdmin)
+			secured.POST("/2fa/enroll", account, router.Controller.EnrollTOTP)
+			secured.POST("/2fa/confirm", account, router.Controller.ConfirmTOTP)
+			secured.POST("/2fa/disable", account, router.Controller.DisableTOTP)
+
+			secured.POST("/tokens", account, router.Controller.CreateAccessToken)
+			secured.GET("/tokens", account, router.Controller.GetAccessTokens)
+			secured.DELETE("/tokens/:tokenId", account, router.Controller.RevokeAccessToken)
+
+			secured.GET("/sessions", account, router.Controller.GetSessions)
+			secured.DELETE("/sessions/:sessionId", account, router.Controller.RevokeSession)
+
+			secured.GET("/me", account, router.Controller.GetAccount)
+			secured.PATCH("/me", account, router.Controller.UpdateAccount)
+			secured.DELETE("/me", account, router.Controller.DeleteAccount)
+			secured.POST("/me/password", account, router.Controller.ChangePassword)
+			secured.POST("/me/email", account, router.Controller.ChangeEmail)
+			secured.POST("/me/restore", account, router.Controller.RestoreAccount)
+			secured.POST("/me/export", account, router.Controller.RequestExport)
+			secured.GET("/me/export/:jobId", account, router.Controller.GetExport)
+			secured.GET("/me/security-events", account, router.Controller.GetSecurityEvents)
+
+			secured.POST("/oauth/clients", account, router.Controller.CreateOAuthClient)
+			secured.GET("/oauth/clients", account, router.Controller.GetOAuthClients)
+			secured.GET("/oauth/authorize", account, router.Controller.GetAuthorization)
+			secured.POST("/oauth/authorize", account, router.Controller.Authorize)
+		}
+	}
+
+	admin := engine.Group("/api/admin")
+	{
+		admin.Use(router.authMiddleware())
+		admin.Use(router.requireRole(auth.RoleAdmin))
+		admin.Use(router.requireScopes(auth.ScopeAdmin))
 		{
-			secured.POST("/tasks", router.Controller.AddTask)
-			secured.GET("/tasks", router.Controller.GetTasks)
-			secured.GET("/tasks/:taskId", router.Controller.GetTask)
+			admin.GET("/users", router.Controller.GetUsers)
+			admin.GET("/users/:userId", router.Controller.GetUser)
+			admin.POST("/users/:userId/disable", router.Controller.DisableUser)
+			admin.POST("/users/:userId/enable", router.Controller.EnableUser)
+			admin.POST("/users/:userId/password-reset", router.Controller.ForcePasswordReset)
+			admin.PUT("/users/:userId/role", router.Controller.UpdateUserRole)
+			admin.GET("/users/:userId/tasks", router.Controller.GetUserTasks)
+			admin.GET("/audit", router.Controller.GetAuditLog)
+			admin.GET("/security-events", router.Controller.GetSecurityLog)
+			admin.GET("/metrics", gin.WrapH(expvar.Handler()))
 		}
 	}
 }
@@ -3,8 +3,10 @@ package service
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"gos/app/models"
 	"gos/app/repo"
+	"strings"
 )
 
 // IAppService is the main service for the app
@@ -21,8 +23,12 @@ type AppService struct {
 	appRepo *repo.AppRepo
 }
 
-// AddUser adds a user
+// AddUser adds a user, it needs a name and an email
 func (s *AppService) AddUser(ctx context.Context, user models.User) (sql.Result, error) {
+	if len(strings.TrimSpace(user.Name)) == 0 || len(strings.TrimSpace(user.Email)) == 0 {
+		return nil, fmt.Errorf("%w: a user needs a name and an email", repo.ErrValidation)
+	}
+
 	return s.appRepo.AddUser(ctx, user)
 }
 
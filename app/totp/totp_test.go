@@ -0,0 +1,102 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA-1 vectors of RFC 6238 appendix B, truncated to the last Digits digits
+func TestCodeRFC6238(t *testing.T) {
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, v := range vectors {
+		code, err := Code(rfcSecret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", v.unix, err)
+		}
+
+		if code != v.code {
+			t.Errorf("Code at %d = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	for _, offset := range []int64{-1, 0, 1} {
+		code, err := codeAt(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := Validate(rfcSecret, code, now, 1)
+		if !ok {
+			t.Errorf("Validate of the code at step offset %d failed with skew 1", offset)
+		}
+
+		if step != current+offset {
+			t.Errorf("Validate of the code at step offset %d returned step %d, want %d", offset, step, current+offset)
+		}
+	}
+
+	code, err := codeAt(rfcSecret, current+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := Validate(rfcSecret, code, now, 0); ok {
+		t.Error("Validate accepted the code of the next step with skew 0")
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	for _, offset := range []int64{-3, -2, 2, 3} {
+		code, err := codeAt(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Errorf("Validate accepted the code at step offset %d with skew 1", offset)
+		}
+	}
+
+	for _, code := range []string{"", "00592", "0059240", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Errorf("Validate accepted %q", code)
+		}
+	}
+
+	if _, ok := Validate("not base32!", "005924", now, 1); ok {
+		t.Error("Validate accepted a code of an invalid secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Code(secret, time.Now()); err != nil {
+		t.Errorf("Code of a generated secret: %v", err)
+	}
+}
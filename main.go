@@ -15,14 +15,22 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 	"gos/app"
 	"gos/app/auth"
+	"gos/app/config"
 	"gos/app/controller"
+	"gos/app/mailer"
+	"gos/app/oidc"
+	"gos/app/password"
 	"gos/app/repo"
+	"gos/app/repo/cache"
+	"gos/app/repo/migrate"
 	"os"
+	"time"
 )
 
 func die(err error) {
@@ -30,28 +38,156 @@ func die(err error) {
 		err = errors.New("die with no error..")
 	}
 
-	fmt.Printf(err.Error())
+	fmt.Println(err.Error())
 	os.Exit(1)
 }
 
+func newMailer(cfg config.MailConfig) (mailer.IMailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.SMTP), nil
+	case "log":
+		if len(cfg.LogFile) == 0 {
+			return mailer.NewLogMailer(os.Stdout), nil
+		}
+
+		file, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open mail log file")
+		}
+
+		return mailer.NewLogMailer(file), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver [%s]", cfg.Driver)
+	}
+}
+
+// newCache decorates appRepo with the configured cache, its stats are published under cache at /api/admin/metrics
+func newCache(appRepo repo.IAppRepo, cfg config.CacheConfig) (repo.IAppRepo, error) {
+	var backend cache.Backend
+	switch cfg.Driver {
+	case "":
+		return appRepo, nil
+	case "memory":
+		backend = cache.NewLRU(cfg.Size)
+	default:
+		return nil, fmt.Errorf("unknown cache driver [%s]", cfg.Driver)
+	}
+
+	cachedRepo := cache.New(appRepo, backend, cfg.TTL)
+	expvar.Publish("cache", expvar.Func(func() interface{} {
+		return cachedRepo.Stats()
+	}))
+
+	return cachedRepo, nil
+}
+
+// purgePeriodically runs a purge every interval, forever
+func purgePeriodically(what string, interval time.Duration, purge func(ctx context.Context, now int64) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := purge(context.Background(), time.Now().Unix())
+		if err != nil {
+			fmt.Println(errors.Wrapf(err, "failed to purge %s", what))
+			continue
+		}
+
+		if deleted > 0 {
+			fmt.Printf("purged %d %s\n", deleted, what)
+		}
+	}
+}
+
+// runMigrate runs a migrate subcommand against the configured database
+func runMigrate(dbConfig repo.DbConfig, args []string) error {
+	con, err := repo.Connect(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	migrator, err := migrate.New(con.DB, repo.DriverName(dbConfig))
+	if err != nil {
+		return err
+	}
+
+	return migrator.Command(context.Background(), args, os.Stdout)
+}
+
 func main() {
-	userRepo, err := repo.NewAppRepo(repo.DbConfig{
-		Host:         "127.0.0.1", // get the host from env variable
-		Port:         3306,
-		DatabaseName: "gos",
-		User:         "gos",
-		Password:     "1234", // do not put password in the code, get it from env variable
-	})
+	cfg := config.Load()
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg.Db, os.Args[2:]); err != nil {
+			die(err)
+		}
+
+		return
+	}
+
+	if err := password.ValidateClasses(cfg.Password.Classes); err != nil {
+		die(err)
+	}
+
+	if cfg.AccountDeletion.Tasks != config.AccountDeletionTasksDelete && cfg.AccountDeletion.Tasks != config.AccountDeletionTasksAnonymize {
+		die(fmt.Errorf("unknown account deletion tasks mode [%s]", cfg.AccountDeletion.Tasks))
+	}
+
+	if cfg.Db.AutoMigrate && repo.DriverName(cfg.Db) != repo.DriverMemory {
+		if err := runMigrate(cfg.Db, []string{"up"}); err != nil {
+			die(errors.Wrap(err, "failed to migrate the database"))
+		}
+	}
+
+	userRepo, err := repo.NewRepo(cfg.Db)
+	if err != nil {
+		die(errors.Wrap(err, "failed to open the repo, run `migrate up` when the schema is missing"))
+	}
+
+	userRepo, err = newCache(userRepo, cfg.Cache)
 	if err != nil {
 		die(err)
 	}
 
-	authService := auth.NewAuth(userRepo, "some-key")
-	appController := controller.NewAppController(userRepo, authService)
-	router := app.NewRouter(appController, authService)
+	appMailer, err := newMailer(cfg.Mail)
+	if err != nil {
+		die(err)
+	}
+
+	err = os.MkdirAll(cfg.Export.Dir, 0700)
+	if err != nil {
+		die(errors.Wrap(err, "failed to create the export dir"))
+	}
+
+	passwordHasher, err := password.NewHasher(cfg.PasswordHasher)
+	if err != nil {
+		die(err)
+	}
+
+	var oidcProvider oidc.IProvider
+	if cfg.OIDC.Enabled() {
+		oidcProvider = oidc.NewProvider(cfg.OIDC.Provider)
+	}
+
+	authService := auth.NewAuth(userRepo, cfg.JWTKey)
+	appController := controller.NewAppController(userRepo, authService, appMailer, passwordHasher, oidcProvider, cfg)
+	router := app.NewRouter(appController, authService, cfg)
+
+	if cfg.AccountDeletion.PurgeInterval > 0 {
+		go purgePeriodically("deleted account/s", cfg.AccountDeletion.PurgeInterval, appController.PurgeDeletedAccounts)
+	}
+
+	if cfg.Export.PurgeInterval > 0 {
+		go purgePeriodically("expired export/s", cfg.Export.PurgeInterval, appController.PurgeExpiredExports)
+	}
+
+	if cfg.SecurityEvents.PurgeInterval > 0 {
+		go purgePeriodically("security event/s", cfg.SecurityEvents.PurgeInterval, appController.PurgeSecurityEvents)
+	}
 
-	err = router.Engine.Run(":8080")
+	err = router.Engine.Run(cfg.Address)
 	if err != nil {
 		die(err)
 	}
@@ -0,0 +1,96 @@
+// breached-split splits a breached password list into the hash prefix files read by the password policy.
+// The input has one `SHA1:COUNT` line per password, like the Pwned Passwords dump ordered by hash,
+// or plain passwords with -plain.
+//
+//	go run tools/breached-split/main.go -in pwned-passwords-sha1-ordered-by-hash.txt -out /var/lib/gos/breached
+//	GOS_PASSWORD_BREACHED_DIR=/var/lib/gos/breached go run main.go
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"gos/app/password"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func main() {
+	in := flag.String("in", "", "input file, stdin when empty")
+	out := flag.String("out", "breached", "directory the prefix files are written to")
+	plain := flag.Bool("plain", false, "the input has one plain password per line instead of SHA1:COUNT")
+	flag.Parse()
+
+	input := os.Stdin
+	if len(*in) > 0 {
+		file, err := os.Open(*in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	// the lines are grouped by prefix in memory, a full dump should be ordered by hash so it can be flushed as it goes
+	lines := make(map[string][]string)
+	current := ""
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		if *plain {
+			sum := sha1.Sum([]byte(line))
+			line = hex.EncodeToString(sum[:]) + ":1"
+		}
+
+		line = strings.ToUpper(line)
+		if len(line) < 40 {
+			log.Fatalf("invalid line [%s]", line)
+		}
+
+		prefix := line[:password.PrefixLength]
+		if !*plain && prefix != current && len(current) > 0 {
+			flush(*out, current, lines[current])
+			delete(lines, current)
+		}
+
+		current = prefix
+		lines[prefix] = append(lines[prefix], line[password.PrefixLength:])
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	for prefix, suffixes := range lines {
+		flush(*out, prefix, suffixes)
+	}
+}
+
+// flush appends to the prefix file, so an input not ordered by hash still ends up complete
+func flush(dir string, prefix string, suffixes []string) {
+	sort.Strings(suffixes)
+	file, err := os.OpenFile(filepath.Join(dir, prefix+".txt"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	for _, suffix := range suffixes {
+		if _, err := fmt.Fprintln(file, suffix); err != nil {
+			log.Fatal(err)
+		}
+	}
+}